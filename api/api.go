@@ -0,0 +1,356 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/web"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultListenAddress only accepts local clients
+const defaultListenAddress = "127.0.0.1:8081"
+
+// Options configures the admin API
+type Options struct {
+	// ListenAddress is the address of the API, "127.0.0.1:8081" by default. A non-loopback address requires a token.
+	ListenAddress string `json:"listenAddress"`
+	RootPath      string `json:"rootPath"`
+	// Token is the bearer token required in the Authorization header of every request
+	Token string `json:"token"`
+	// Shared mounts the routes on the shared HTTP server under RootPath instead of listening on ListenAddress, it
+	// requires a token and a RootPath other than "/"
+	Shared bool `json:"shared"`
+}
+
+// API serves an HTTP API to manage the components and the pipes of a running server
+type API struct {
+	server  *server.Server
+	options *Options
+	srv     *http.Server
+	// unmount removes the routes from the shared HTTP server
+	unmount func()
+	logger  *log.Entry
+}
+
+// New creates a new instance of API
+func New(server *server.Server, options *Options) *API {
+	if options.ListenAddress == "" {
+		options.ListenAddress = defaultListenAddress
+	}
+	return &API{
+		server:  server,
+		options: options,
+		logger:  log.New().WithFields(log.Fields{"module": "API"}),
+	}
+}
+
+// Init starts the HTTP server, or mounts the routes on the shared HTTP server
+func (a *API) Init() error {
+	r := a.router()
+	if a.options.Shared {
+		return a.mount(r)
+	}
+	if a.options.Token == "" && !loopback(a.options.ListenAddress) {
+		return errors.New("a token is required to serve the API on the non-loopback address " + a.options.ListenAddress)
+	}
+	ln, err := net.Listen("tcp", a.options.ListenAddress)
+	if err != nil {
+		return err
+	}
+	a.srv = &http.Server{Handler: r}
+	a.logger.WithField("addr", ln.Addr()).Info("API server is listening")
+	go func() {
+		err := a.srv.Serve(ln)
+		if err != http.ErrServerClosed {
+			a.logger.WithField("addr", a.options.ListenAddress).WithError(err).Error("API server ended with error")
+		}
+	}()
+	return nil
+}
+
+// router returns the handler of the routes under the root path, every request is authorized
+func (a *API) router() *gin.Engine {
+	r := gin.Default()
+	// the ids containing "/", such as the pipes instantiated for a stream, are passed escaped as "%2F"
+	r.UseRawPath = true
+	a.routes(r.Group(a.options.RootPath, a.authorize))
+	return r
+}
+
+// mount serves the routes on the shared HTTP server, its listeners may be public
+func (a *API) mount(r http.Handler) error {
+	if a.options.Token == "" {
+		return errors.New("a token is required to mount the API on the shared HTTP server")
+	}
+	if strings.Trim(a.options.RootPath, "/") == "" {
+		return errors.New("a root path is required to mount the API on the shared HTTP server")
+	}
+	shared, err := web.Shared(a.server)
+	if err != nil {
+		return err
+	}
+	if a.unmount, err = shared.Mount(a.options.RootPath, r); err != nil {
+		return err
+	}
+	a.logger.WithField("path", a.options.RootPath).Info("API is mounted on the shared HTTP server")
+	return nil
+}
+
+// Close stops the HTTP server or unmounts the routes
+func (a *API) Close() error {
+	if a.unmount != nil {
+		a.unmount()
+	}
+	if a.srv == nil {
+		return nil
+	}
+	return a.srv.Close()
+}
+
+// authorize rejects the requests without the token, every request is accepted if there is no token
+func (a *API) authorize(c *gin.Context) {
+	if a.options.Token == "" {
+		return
+	}
+	expected := "Bearer " + a.options.Token
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte(expected)) != 1 {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+	}
+}
+
+// loopback reports whether an address only accepts local clients
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (a *API) routes(r gin.IRouter) {
+	a.componentRoutes(r.Group("/inbounds"), server.KindInbound)
+	a.componentRoutes(r.Group("/outbounds"), server.KindOutbound)
+	a.componentRoutes(r.Group("/processes"), server.KindProcess)
+
+	pipes := r.Group("/pipes")
+	pipes.GET("", a.listPipes)
+	pipes.POST("", a.addPipe)
+	pipes.GET("/:id", a.getPipe)
+	pipes.DELETE("/:id", a.removePipe)
+	pipes.POST("/:id/start", a.startPipe)
+	pipes.POST("/:id/stop", a.stopPipe)
+
+	r.GET("/graph", a.getGraph)
+}
+
+func (a *API) componentRoutes(r gin.IRouter, kind server.Kind) {
+	r.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, a.server.Components(kind))
+	})
+	r.POST("", func(c *gin.Context) {
+		config := server.ComponentConfig{}
+		if err := c.BindJSON(&config); err != nil {
+			return
+		}
+		var err error
+		switch kind {
+		case server.KindInbound:
+			err = a.server.AddInbound(config.ID, config.Type, config.Options)
+		case server.KindOutbound:
+			err = a.server.AddOutbound(config.ID, config.Type, config.Options)
+		case server.KindProcess:
+			err = a.server.AddProcess(config.ID, config.Type, config.Options)
+		}
+		if err != nil {
+			a.abort(c, err)
+			return
+		}
+		a.logger.WithFields(log.Fields{string(kind): config.ID, "type": config.Type}).Info(string(kind) + " added")
+		a.respondComponent(c, http.StatusCreated, kind, config.ID)
+	})
+	r.GET("/:id", func(c *gin.Context) {
+		a.respondComponent(c, http.StatusOK, kind, c.Param("id"))
+	})
+	r.DELETE("/:id", func(c *gin.Context) {
+		if !a.checkKind(c, kind, c.Param("id")) {
+			return
+		}
+		if err := a.server.RemoveComponent(c.Param("id")); err != nil {
+			a.abort(c, err)
+			return
+		}
+		a.logger.WithField(string(kind), c.Param("id")).Info(string(kind) + " removed")
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/:id/connections", func(c *gin.Context) {
+		if !a.checkKind(c, kind, c.Param("id")) {
+			return
+		}
+		connections, err := a.server.Connections(c.Param("id"))
+		if err != nil {
+			a.abort(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, connections)
+	})
+	r.GET("/:id/viewers", func(c *gin.Context) {
+		if !a.checkKind(c, kind, c.Param("id")) {
+			return
+		}
+		viewers, err := a.server.Viewers(c.Param("id"))
+		if err != nil {
+			a.abort(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, viewers)
+	})
+	r.DELETE("/:id/viewers/:viewer", func(c *gin.Context) {
+		if !a.checkKind(c, kind, c.Param("id")) {
+			return
+		}
+		if err := a.server.KickViewer(c.Param("id"), c.Param("viewer")); err != nil {
+			a.abort(c, err)
+			return
+		}
+		a.logger.WithFields(log.Fields{string(kind): c.Param("id"), "viewer": c.Param("viewer")}).Info("viewer kicked")
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/:id/start", func(c *gin.Context) {
+		if !a.checkKind(c, kind, c.Param("id")) {
+			return
+		}
+		if err := a.server.StartComponent(c.Param("id")); err != nil {
+			a.abort(c, err)
+			return
+		}
+		a.respondComponent(c, http.StatusOK, kind, c.Param("id"))
+	})
+	r.POST("/:id/stop", func(c *gin.Context) {
+		if !a.checkKind(c, kind, c.Param("id")) {
+			return
+		}
+		if err := a.server.StopComponent(c.Param("id")); err != nil {
+			a.abort(c, err)
+			return
+		}
+		a.respondComponent(c, http.StatusOK, kind, c.Param("id"))
+	})
+}
+
+// checkKind aborts with 404 if the component does not exist or is of another kind
+func (a *API) checkKind(c *gin.Context, kind server.Kind, id string) bool {
+	status, err := a.server.Component(id)
+	if err == nil && status.Kind != kind {
+		err = errors.New(string(kind) + " " + id + " " + server.ErrNotFound.Error())
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		a.abort(c, err)
+		return false
+	}
+	return true
+}
+
+func (a *API) respondComponent(c *gin.Context, code int, kind server.Kind, id string) {
+	if !a.checkKind(c, kind, id) {
+		return
+	}
+	status, _ := a.server.Component(id)
+	c.JSON(code, status)
+}
+
+func (a *API) listPipes(c *gin.Context) {
+	c.JSON(http.StatusOK, a.server.Pipes())
+}
+
+func (a *API) addPipe(c *gin.Context) {
+	config := server.PipeConfig{}
+	if err := c.BindJSON(&config); err != nil {
+		return
+	}
+	if config.ID == "" {
+		config.ID = config.In
+	}
+	if err := a.server.AddPipe(config.ID, config.In, config.Outs, &config.PipeOptions); err != nil {
+		a.abort(c, err)
+		return
+	}
+	a.logger.WithFields(log.Fields{"pipe": config.ID, "in": config.In, "outs": config.Outs}).Info("pipe added")
+	a.respondPipe(c, http.StatusCreated, config.ID)
+}
+
+func (a *API) getPipe(c *gin.Context) {
+	a.respondPipe(c, http.StatusOK, c.Param("id"))
+}
+
+func (a *API) removePipe(c *gin.Context) {
+	if err := a.server.RemovePipe(c.Param("id")); err != nil {
+		a.abort(c, err)
+		return
+	}
+	a.logger.WithField("pipe", c.Param("id")).Info("pipe removed")
+	c.Status(http.StatusNoContent)
+}
+
+func (a *API) startPipe(c *gin.Context) {
+	if err := a.server.StartPipe(c.Param("id")); err != nil {
+		a.abort(c, err)
+		return
+	}
+	a.respondPipe(c, http.StatusOK, c.Param("id"))
+}
+
+func (a *API) stopPipe(c *gin.Context) {
+	if err := a.server.StopPipe(c.Param("id")); err != nil {
+		a.abort(c, err)
+		return
+	}
+	a.respondPipe(c, http.StatusOK, c.Param("id"))
+}
+
+func (a *API) respondPipe(c *gin.Context, code int, id string) {
+	status, err := a.server.Pipe(id)
+	if err != nil {
+		a.abort(c, err)
+		return
+	}
+	c.JSON(code, status)
+}
+
+// getGraph responds the pipe graph as JSON, or in the Graphviz DOT language with format=dot
+func (a *API) getGraph(c *gin.Context) {
+	g := a.server.Graph()
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, g)
+	case "dot":
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(g.DOT()))
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown format: " + c.Query("format")})
+	}
+}
+
+// abort responds the error with the status code matching it
+func (a *API) abort(c *gin.Context, err error) {
+	code := http.StatusBadRequest
+	switch {
+	case errors.Is(err, server.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, server.ErrExists):
+		code = http.StatusConflict
+	case errors.Is(err, server.ErrServerClosed):
+		code = http.StatusServiceUnavailable
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/howyoungzhou/golive/server"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// nopComponent is an inbound reading nothing
+type nopComponent struct{}
+
+func (nopComponent) Init() error                { return nil }
+func (nopComponent) Close() error               { return nil }
+func (nopComponent) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func newTestAPI(t *testing.T, options *Options) *API {
+	gin.SetMode(gin.TestMode)
+	s := server.New()
+	s.RegisterInbound("nop", func(*server.Server, string, map[string]interface{}) (server.Inbound, error) {
+		return nopComponent{}, nil
+	})
+	a := New(s, options)
+	a.logger.Logger.SetOutput(ioutil.Discard)
+	return a
+}
+
+// do sends a request to the routes of an API
+func do(a *API, method, path, authorization, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	a.router().ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		status        int
+	}{
+		{name: "valid token", token: "secret", authorization: "Bearer secret", status: http.StatusOK},
+		{name: "missing token", token: "secret", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer wrong", status: http.StatusUnauthorized},
+		{name: "token prefix", token: "secret", authorization: "Bearer secre", status: http.StatusUnauthorized},
+		{name: "wrong scheme", token: "secret", authorization: "Basic secret", status: http.StatusUnauthorized},
+		{name: "no token required", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAPI(t, &Options{RootPath: "/api", Token: tt.token})
+			res := do(a, http.MethodGet, "/api/inbounds", tt.authorization, "")
+			if res.Code != tt.status {
+				t.Fatalf("got %d, want %d", res.Code, tt.status)
+			}
+			if challenge := res.Header().Get("WWW-Authenticate"); (challenge == "Bearer") != (tt.status == http.StatusUnauthorized) {
+				t.Fatalf("got the challenge %q with %d", challenge, res.Code)
+			}
+		})
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{name: "loopback", options: Options{ListenAddress: "127.0.0.1:0"}},
+		{name: "any address without token", options: Options{ListenAddress: ":0"}, wantErr: true},
+		{name: "public address without token", options: Options{ListenAddress: "0.0.0.0:0"}, wantErr: true},
+		{name: "host name without token", options: Options{ListenAddress: "example.com:8081"}, wantErr: true},
+		{name: "shared without token", options: Options{Shared: true, RootPath: "/api"}, wantErr: true},
+		{name: "shared on the root path", options: Options{Shared: true, RootPath: "/", Token: "secret"}, wantErr: true},
+		{name: "shared without HTTP server", options: Options{Shared: true, RootPath: "/api", Token: "secret"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			a := newTestAPI(t, &options)
+			err := a.Init()
+			if err == nil {
+				a.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got the error %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if a := newTestAPI(t, &Options{}); !loopback(a.options.ListenAddress) {
+		t.Fatalf("got the default address %s", a.options.ListenAddress)
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "127.0.0.1:8081", want: true},
+		{addr: "127.0.0.2:8081", want: true},
+		{addr: "[::1]:8081", want: true},
+		{addr: "localhost:8081", want: true},
+		{addr: ":8081"},
+		{addr: "0.0.0.0:8081"},
+		{addr: "[::]:8081"},
+		{addr: "192.0.2.1:8081"},
+		{addr: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := loopback(tt.addr); got != tt.want {
+			t.Errorf("loopback(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{err: fmt.Errorf("component in %w", server.ErrNotFound), status: http.StatusNotFound},
+		{err: fmt.Errorf("pipe in %w", server.ErrExists), status: http.StatusConflict},
+		{err: server.ErrServerClosed, status: http.StatusServiceUnavailable},
+		{err: errors.New("unknown inbound: nop"), status: http.StatusBadRequest},
+	}
+	a := newTestAPI(t, &Options{})
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		a.abort(c, tt.err)
+		var body struct{ Error string }
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tt.status || body.Error != tt.err.Error() || !c.IsAborted() {
+			t.Errorf("abort(%v): got %d with %q, want %d", tt.err, w.Code, body.Error, tt.status)
+		}
+	}
+}
+
+func TestStatusCodes(t *testing.T) {
+	a := newTestAPI(t, &Options{})
+	steps := []struct {
+		method, path, body string
+		status             int
+	}{
+		{method: http.MethodPost, path: "/inbounds", body: `{"id": "in", "type": "nop"}`, status: http.StatusCreated},
+		{method: http.MethodPost, path: "/inbounds", body: `{"id": "in", "type": "nop"}`, status: http.StatusConflict},
+		{method: http.MethodPost, path: "/inbounds", body: `{"id": "other", "type": "unknown"}`, status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/inbounds", body: `{"id": `, status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/inbounds/in", status: http.StatusOK},
+		{method: http.MethodGet, path: "/inbounds/missing", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/outbounds/in", status: http.StatusNotFound},
+		{method: http.MethodDelete, path: "/processes/in", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/pipes/missing", status: http.StatusNotFound},
+		{method: http.MethodDelete, path: "/pipes/missing", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/graph?format=svg", status: http.StatusBadRequest},
+		{method: http.MethodDelete, path: "/inbounds/in", status: http.StatusNoContent},
+		{method: http.MethodDelete, path: "/inbounds/in", status: http.StatusNotFound},
+	}
+	for _, s := range steps {
+		if res := do(a, s.method, s.path, "", s.body); res.Code != s.status {
+			t.Fatalf("%s %s: got %d, want %d: %s", s.method, s.path, res.Code, s.status, res.Body)
+		}
+	}
+
+	if err := a.server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res := do(a, http.MethodPost, "/inbounds", "", `{"id": "late", "type": "nop"}`); res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d once the server is shut down, want %d", res.Code, http.StatusServiceUnavailable)
+	}
+}
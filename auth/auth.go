@@ -0,0 +1,217 @@
+// Package auth authorizes the peers connecting to the inbounds and the outbounds, with an allowlist, signed tokens and
+// an HTTP callback
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mode is what a peer requests to do with a stream
+type Mode string
+
+const (
+	ModePublish Mode = "publish"
+	ModePlay    Mode = "play"
+)
+
+const defaultCallbackTimeout = 2000
+
+// ErrNotAllowed rejects the peers whose address is not allowed
+var ErrNotAllowed = errors.New("address not allowed")
+
+// Options of an Authorizer, all the configured checks must pass
+type Options struct {
+	// Allow lists the IP addresses and the CIDR blocks of the peers allowed to connect, all the peers are allowed if
+	// it is empty
+	Allow []string
+	// Secret enables the tokens, the peers must then present a token signed with the secret for the stream and the
+	// mode they request
+	Secret string
+	// Callback is the URL of an HTTP hook authorizing each connection, see Request
+	Callback string
+	// CallbackTimeout is the timeout of the callback in milliseconds (2000 by default)
+	CallbackTimeout int
+	// Passphrases sets the SRT passphrase of the peers of each stream, by stream, "" being the component itself. The
+	// peers of the streams not listed use the passphrase of the listener.
+	Passphrases map[string]string
+}
+
+// Request describes a peer requesting to connect, it is posted as JSON to the callback. A response with a 2xx status
+// authorizes the peer.
+type Request struct {
+	// ID is the id of the component the peer connects to
+	ID     string `json:"id"`
+	Addr   string `json:"addr"`
+	Stream string `json:"stream"`
+	Mode   Mode   `json:"mode"`
+	User   string `json:"user,omitempty"`
+	// Token is the token presented by the peer, it is not sent to the callback
+	Token string `json:"-"`
+}
+
+// Authorizer checks the peers against the configured rules
+type Authorizer struct {
+	options Options
+	allow   []*net.IPNet
+	client  *http.Client
+}
+
+// New creates an Authorizer, nil is returned if no rule is configured
+func New(options Options) (*Authorizer, error) {
+	if len(options.Allow) == 0 && options.Secret == "" && options.Callback == "" && len(options.Passphrases) == 0 {
+		return nil, nil
+	}
+	for stream, passphrase := range options.Passphrases {
+		// the lengths accepted by SRT
+		if len(passphrase) < 10 || len(passphrase) > 79 {
+			return nil, fmt.Errorf("the passphrase of stream %q must be 10 to 79 characters long", stream)
+		}
+	}
+	a := &Authorizer{options: options}
+	for _, allow := range options.Allow {
+		if !strings.Contains(allow, "/") {
+			ip := net.ParseIP(allow)
+			if ip == nil {
+				return nil, errors.New("invalid IP address: " + allow)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			a.allow = append(a.allow, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(allow)
+		if err != nil {
+			return nil, err
+		}
+		a.allow = append(a.allow, ipNet)
+	}
+	if options.Callback != "" {
+		timeout := options.CallbackTimeout
+		if timeout <= 0 {
+			timeout = defaultCallbackTimeout
+		}
+		a.client = &http.Client{Timeout: time.Duration(timeout) * time.Millisecond}
+	}
+	return a, nil
+}
+
+// Authorize returns an error describing why the peer is rejected, nil if it is authorized. A nil Authorizer
+// authorizes every peer.
+func (a *Authorizer) Authorize(req Request) error {
+	if err := a.Check(req); err != nil {
+		return err
+	}
+	if a != nil && a.client != nil {
+		return a.callback(req)
+	}
+	return nil
+}
+
+// Check runs the checks of Authorize but the callback, it never blocks. ErrNotAllowed is returned for an address not
+// allowed.
+func (a *Authorizer) Check(req Request) error {
+	if a == nil {
+		return nil
+	}
+	if len(a.allow) > 0 && !a.allowed(req.Addr) {
+		return ErrNotAllowed
+	}
+	if a.options.Secret != "" {
+		if err := Verify(a.options.Secret, req.Token, req.Stream, req.Mode, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Passphrase returns the SRT passphrase of the peers of a stream, false if the stream has none
+func (a *Authorizer) Passphrase(stream string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	passphrase, ok := a.options.Passphrases[stream]
+	return passphrase, ok
+}
+
+func (a *Authorizer) allowed(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range a.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authorizer) callback(req Request) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res, err := a.client.Post(a.options.Callback, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("auth callback failed: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.New("rejected by the auth callback: " + res.Status)
+	}
+	return nil
+}
+
+// Sign returns a token allowing to publish or play a stream until the expiry, the token is "<expiry>.<signature>"
+// where the expiry is a Unix time and the signature is the base64url encoded HMAC-SHA256 of the stream, the mode and
+// the expiry
+func Sign(secret, stream string, mode Mode, expiry time.Time) string {
+	exp := strconv.FormatInt(expiry.Unix(), 10)
+	return exp + "." + signature(secret, stream, mode, exp)
+}
+
+// Verify checks a token created by Sign
+func Verify(secret, token, stream string, mode Mode, now time.Time) error {
+	if token == "" {
+		return errors.New("missing token")
+	}
+	i := strings.Index(token, ".")
+	if i < 0 {
+		return errors.New("malformed token")
+	}
+	exp, sig := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, stream, mode, exp))) {
+		return errors.New("invalid token")
+	}
+	expiry, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if now.Unix() >= expiry {
+		return errors.New("expired token")
+	}
+	return nil
+}
+
+func signature(secret, stream string, mode Mode, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stream + "\n" + string(mode) + "\n" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	token := Sign("secret", "live/cam1", ModePublish, now.Add(time.Minute))
+	i := strings.Index(token, ".")
+	tampered := []byte(token)
+	if tampered[i+1] == 'A' {
+		tampered[i+1] = 'B'
+	} else {
+		tampered[i+1] = 'A'
+	}
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		stream string
+		mode   Mode
+		now    time.Time
+		err    string
+	}{
+		{name: "valid", secret: "secret", token: token, stream: "live/cam1", mode: ModePublish, now: now},
+		{name: "just before expiry", secret: "secret", token: token, stream: "live/cam1", mode: ModePublish, now: now.Add(time.Minute - time.Second)},
+		{name: "expired", secret: "secret", token: token, stream: "live/cam1", mode: ModePublish, now: now.Add(time.Minute), err: "expired token"},
+		{name: "wrong stream", secret: "secret", token: token, stream: "live/cam2", mode: ModePublish, now: now, err: "invalid token"},
+		{name: "component itself", secret: "secret", token: token, stream: "", mode: ModePublish, now: now, err: "invalid token"},
+		{name: "wrong mode", secret: "secret", token: token, stream: "live/cam1", mode: ModePlay, now: now, err: "invalid token"},
+		{name: "wrong secret", secret: "other", token: token, stream: "live/cam1", mode: ModePublish, now: now, err: "invalid token"},
+		{name: "tampered signature", secret: "secret", token: string(tampered), stream: "live/cam1", mode: ModePublish, now: now, err: "invalid token"},
+		{name: "tampered expiry", secret: "secret", token: "9" + token, stream: "live/cam1", mode: ModePublish, now: now, err: "invalid token"},
+		{name: "missing", secret: "secret", token: "", stream: "live/cam1", mode: ModePublish, now: now, err: "missing token"},
+		{name: "malformed", secret: "secret", token: "abc", stream: "live/cam1", mode: ModePublish, now: now, err: "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.token, tt.stream, tt.mode, tt.now)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAllow(t *testing.T) {
+	a, err := New(Options{Allow: []string{"10.0.0.0/8", "192.168.1.10", "::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"10.1.2.3:9000", true},
+		{"192.168.1.10:9000", true},
+		{"192.168.1.11:9000", false},
+		{"[::1]:9000", true},
+		{"10.1.2.3", true},
+		{"invalid", false},
+	}
+	for _, tt := range tests {
+		if err := a.Authorize(Request{Addr: tt.addr}); (err == nil) != tt.ok {
+			t.Errorf("Authorize(%s) = %v, want authorized %v", tt.addr, err, tt.ok)
+		}
+	}
+	if _, err := New(Options{Allow: []string{"10.0.0"}}); err == nil {
+		t.Error("an invalid address is accepted")
+	}
+}
+
+func TestCallback(t *testing.T) {
+	release := make(chan struct{})
+	var got Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got = req
+		switch req.Stream {
+		case "allow":
+			w.WriteHeader(http.StatusNoContent)
+		case "deny":
+			w.WriteHeader(http.StatusForbidden)
+		case "timeout":
+			<-release
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	a, err := New(Options{Callback: srv.URL, CallbackTimeout: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		stream string
+		err    string
+	}{
+		{stream: "allow"},
+		{stream: "deny", err: "rejected by the auth callback: 403 Forbidden"},
+		{stream: "timeout", err: "auth callback failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stream, func(t *testing.T) {
+			req := Request{ID: "srt-in", Addr: "10.1.2.3:9000", Stream: tt.stream, Mode: ModePublish, User: "alice", Token: "token"}
+			start := time.Now()
+			err := a.Authorize(req)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+			} else if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Fatalf("got %v, want %q", err, tt.err)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Fatalf("the callback took %v", d)
+			}
+			if tt.stream == "timeout" {
+				return
+			}
+			// the token is not sent to the callback
+			req.Token = ""
+			if got != req {
+				t.Fatalf("the callback received %+v, want %+v", got, req)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	// the callback is never reached by Check
+	a, err := New(Options{Allow: []string{"10.0.0.0/8"}, Secret: "secret", Callback: "http://127.0.0.1:1/auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := Sign("secret", "live/cam1", ModePublish, time.Now().Add(time.Minute))
+	tests := []struct {
+		addr  string
+		token string
+		err   error
+	}{
+		{addr: "10.1.2.3:9000", token: token},
+		{addr: "192.168.1.10:9000", token: token, err: ErrNotAllowed},
+		{addr: "10.1.2.3:9000", token: "", err: errors.New("missing token")},
+	}
+	for _, tt := range tests {
+		err := a.Check(Request{Addr: tt.addr, Stream: "live/cam1", Mode: ModePublish, Token: tt.token})
+		if (err == nil) != (tt.err == nil) || err != nil && err.Error() != tt.err.Error() {
+			t.Errorf("Check(%s, %q) = %v, want %v", tt.addr, tt.token, err, tt.err)
+		}
+	}
+	var none *Authorizer
+	if err := none.Check(Request{}); err != nil {
+		t.Errorf("a nil Authorizer rejects with %v", err)
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	a, err := New(Options{Passphrases: map[string]string{"": "passphrase0", "live/cam1": "passphrase1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == nil {
+		t.Fatal("the passphrases alone do not create an Authorizer")
+	}
+	tests := []struct {
+		stream string
+		want   string
+		ok     bool
+	}{
+		{stream: "", want: "passphrase0", ok: true},
+		{stream: "live/cam1", want: "passphrase1", ok: true},
+		{stream: "live/cam2"},
+	}
+	for _, tt := range tests {
+		if got, ok := a.Passphrase(tt.stream); got != tt.want || ok != tt.ok {
+			t.Errorf("Passphrase(%q) = %q, %v, want %q, %v", tt.stream, got, ok, tt.want, tt.ok)
+		}
+	}
+	if err := a.Authorize(Request{Stream: "live/cam2"}); err != nil {
+		t.Errorf("the passphrases reject a peer: %v", err)
+	}
+	for _, passphrase := range []string{"short", strings.Repeat("a", 80)} {
+		if _, err := New(Options{Passphrases: map[string]string{"live/cam1": passphrase}}); err == nil {
+			t.Errorf("the passphrase %q is accepted", passphrase)
+		}
+	}
+}
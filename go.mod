@@ -7,6 +7,11 @@ require (
 	github.com/gin-gonic/gin v1.7.1
 	github.com/haivision/srtgo v0.0.0-20210308180300-b484f9267f13
 	github.com/mitchellh/mapstructure v1.4.1
+	github.com/pion/ice/v2 v2.1.7
+	github.com/pion/interceptor v0.0.12
+	github.com/pion/rtcp v1.2.6
+	github.com/pion/rtp v1.6.2
+	github.com/pion/turn/v2 v2.0.5
 	github.com/pion/webrtc/v3 v3.0.27
 	github.com/sirupsen/logrus v1.8.1
 )
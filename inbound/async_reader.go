@@ -1,34 +1,121 @@
 package inbound
 
-// AsyncReader implements io.Reader interface with asynchronous read performed with channel
+import (
+	"github.com/howyoungzhou/golive/mpegts"
+	"github.com/howyoungzhou/golive/server"
+	"io"
+	"sync"
+	"time"
+)
+
+// asyncResult is the result of a read request
+type asyncResult struct {
+	n      int
+	source string
+	err    error
+}
+
+// AsyncReader implements io.Reader and server.PacketReader interfaces with asynchronous read performed with channel.
+// The packets read are flagged as keyframes by scanning the data as MPEG-TS once ScanKeyframes is called.
 type AsyncReader struct {
 	bufChannel chan []byte
-	nChannel   chan int
-	errChannel chan error
+	resChannel chan asyncResult
+	done       chan struct{}
+	closeOnce  sync.Once
+	// scanner is only used by ReadPacket, nil unless ScanKeyframes is called
+	scanner *mpegts.Scanner
 }
 
 // NewAsyncReader creates a new instance of AsyncReader
 func NewAsyncReader() *AsyncReader {
 	return &AsyncReader{
 		make(chan []byte),
-		make(chan int),
-		make(chan error),
+		make(chan asyncResult),
+		make(chan struct{}),
+		sync.Once{},
+		nil,
 	}
 }
 
-// Read blocks until read request completes
+// ScanKeyframes declares the data as MPEG-TS, the packets read with ReadPacket are then flagged as keyframes when they
+// start an H.264 keyframe. It must be called before the first read.
+func (r *AsyncReader) ScanKeyframes() {
+	r.scanner = mpegts.NewScanner()
+}
+
+// Read blocks until read request completes, io.EOF is returned once the reader is closed
 func (r *AsyncReader) Read(p []byte) (n int, err error) {
-	r.bufChannel <- p
-	return <-r.nChannel, <-r.errChannel
+	res, err := r.request(p)
+	if err != nil {
+		return 0, err
+	}
+	return res.n, res.err
 }
 
-// Fetch blocks to wait a new read request
+// ReadPacket blocks until a packet of up to server.DefaultReadSize bytes is read into a pooled buffer, io.EOF is
+// returned once the reader is closed
+func (r *AsyncReader) ReadPacket() (*server.Packet, error) {
+	pkt := server.NewPacket(server.DefaultReadSize)
+	res, err := r.request(pkt.Payload)
+	if err == nil {
+		err = res.err
+	}
+	if err != nil {
+		pkt.Release()
+		return nil, err
+	}
+	pkt.Payload = pkt.Payload[:res.n]
+	pkt.Time = time.Now()
+	pkt.Source = res.source
+	if r.scanner != nil {
+		pkt.Keyframe = r.scanner.ScanStream(pkt.Payload)
+	}
+	return pkt, nil
+}
+
+// FlagsKeyframes reports whether the packets read with ReadPacket flag the H.264 keyframes of MPEG-TS
+func (r *AsyncReader) FlagsKeyframes() bool {
+	return r.scanner != nil
+}
+
+func (r *AsyncReader) request(p []byte) (asyncResult, error) {
+	select {
+	case r.bufChannel <- p:
+	case <-r.done:
+		return asyncResult{}, io.EOF
+	}
+	return <-r.resChannel, nil
+}
+
+// Fetch blocks to wait a new read request, nil is returned once the reader is closed
 func (r *AsyncReader) Fetch() []byte {
-	return <-r.bufChannel
+	select {
+	case p := <-r.bufChannel:
+		return p
+	case <-r.done:
+		return nil
+	}
 }
 
 // Return responds to the latest request
 func (r *AsyncReader) Return(n int, err error) {
-	r.nChannel <- n
-	r.errChannel <- err
+	r.ReturnFrom(n, "", err)
+}
+
+// ReturnFrom responds to the latest request with the source of the data, e.g. the address of the peer
+func (r *AsyncReader) ReturnFrom(n int, source string, err error) {
+	r.resChannel <- asyncResult{n, source, err}
+}
+
+// Close unblocks the pending and future requests
+func (r *AsyncReader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+	return nil
+}
+
+// Done returns a channel that is closed when the reader is closed
+func (r *AsyncReader) Done() <-chan struct{} {
+	return r.done
 }
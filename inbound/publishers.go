@@ -0,0 +1,211 @@
+package inbound
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// PublisherPolicy decides what an inbound does when a publisher connects while another one is publishing
+type PublisherPolicy string
+
+const (
+	// PublisherReject rejects the new publisher
+	PublisherReject PublisherPolicy = "reject"
+	// PublisherTakeover disconnects the current publisher and lets the new one publish
+	PublisherTakeover PublisherPolicy = "takeover"
+	// PublisherStandby keeps the new publisher connected and lets it publish once the current one disconnects, only
+	// one publisher is kept on standby and the others are rejected
+	PublisherStandby PublisherPolicy = "standby"
+)
+
+// PublisherStatus describes a connected publisher
+type PublisherStatus struct {
+	Addr  string    `json:"addr"`
+	Since time.Time `json:"since"`
+}
+
+// PublishersStatus describes the publishers of an inbound
+type PublishersStatus struct {
+	Policy  PublisherPolicy  `json:"publisherPolicy"`
+	Active  *PublisherStatus `json:"active"`
+	Standby *PublisherStatus `json:"standby,omitempty"`
+}
+
+// publisher is a connection publishing to an inbound
+type publisher struct {
+	addr      string
+	since     time.Time
+	read      func(p []byte) (int, error)
+	closeFunc func() error
+	closeOnce sync.Once
+}
+
+func newPublisher(addr string, read func(p []byte) (int, error), close func() error) *publisher {
+	return &publisher{addr: addr, since: time.Now(), read: read, closeFunc: close}
+}
+
+func (p *publisher) close() {
+	p.closeOnce.Do(func() {
+		p.closeFunc()
+	})
+}
+
+func (p *publisher) status() *PublisherStatus {
+	if p == nil {
+		return nil
+	}
+	return &PublisherStatus{Addr: p.addr, Since: p.since}
+}
+
+// publishers serves the read requests of an AsyncReader from a single publisher at a time, so the data of several
+// publishers never interleave
+type publishers struct {
+	policy  PublisherPolicy
+	reader  *AsyncReader
+	active  *publisher
+	standby *publisher
+	mux     sync.Mutex
+	// changed is signaled when a publisher becomes active
+	changed chan struct{}
+	// empty is called, if set, once the last publisher disconnects
+	empty  func()
+	logger *log.Entry
+}
+
+func newPublishers(policy PublisherPolicy, reader *AsyncReader, logger *log.Entry) (*publishers, error) {
+	switch policy {
+	case "":
+		policy = PublisherStandby
+	case PublisherReject, PublisherTakeover, PublisherStandby:
+	default:
+		return nil, errors.New("unknown publisher policy: " + string(policy))
+	}
+	return &publishers{
+		policy:  policy,
+		reader:  reader,
+		changed: make(chan struct{}, 1),
+		logger:  logger,
+	}, nil
+}
+
+// add applies the policy to a newly connected publisher
+func (ps *publishers) add(p *publisher) {
+	ps.mux.Lock()
+	defer ps.mux.Unlock()
+	logger := ps.logger.WithField("addr", p.addr)
+	select {
+	case <-ps.reader.Done():
+		p.close()
+		return
+	default:
+	}
+	switch {
+	case ps.active == nil:
+		ps.active = p
+		logger.Info("Publisher connected")
+	case ps.policy == PublisherTakeover:
+		logger.WithField("previous", ps.active.addr).Info("Publisher took over")
+		// the pending read of the previous publisher fails and is retried on the new one
+		ps.active.close()
+		ps.active = p
+	case ps.policy == PublisherStandby && ps.standby == nil:
+		ps.standby = p
+		logger.Info("Publisher on standby")
+		return
+	default:
+		logger.WithField("active", ps.active.addr).Warn("Publisher rejected")
+		p.close()
+		return
+	}
+	select {
+	case ps.changed <- struct{}{}:
+	default:
+	}
+}
+
+// current blocks until a publisher is active, nil is returned once the reader is closed
+func (ps *publishers) current() *publisher {
+	for {
+		ps.mux.Lock()
+		p := ps.active
+		ps.mux.Unlock()
+		if p != nil {
+			return p
+		}
+		select {
+		case <-ps.changed:
+		case <-ps.reader.Done():
+			return nil
+		}
+	}
+}
+
+// drop closes a failed publisher and returns the publisher replacing it, nil if there is none
+func (ps *publishers) drop(p *publisher) *publisher {
+	p.close()
+	ps.mux.Lock()
+	defer ps.mux.Unlock()
+	if ps.active != p {
+		// the publisher is taken over
+		return ps.active
+	}
+	ps.logger.WithField("addr", p.addr).Info("Connection closed")
+	ps.active, ps.standby = ps.standby, nil
+	if ps.active != nil {
+		ps.logger.WithField("addr", ps.active.addr).Info("Standby publisher promoted")
+	}
+	return ps.active
+}
+
+// serve reads from the active publisher for each read request until the reader is closed. A read error is only
+// returned if no other publisher can take over.
+func (ps *publishers) serve() {
+	defer ps.close()
+	for {
+		p := ps.current()
+		if p == nil {
+			return
+		}
+		buf := ps.reader.Fetch()
+		if buf == nil {
+			// the inbound is closed
+			return
+		}
+		for {
+			n, err := p.read(buf)
+			if err == nil {
+				ps.reader.ReturnFrom(n, p.addr, nil)
+				break
+			}
+			next := ps.drop(p)
+			if next == nil {
+				ps.reader.ReturnFrom(n, p.addr, err)
+				if ps.empty != nil {
+					ps.empty()
+				}
+				break
+			}
+			p = next
+		}
+	}
+}
+
+// close disconnects all the publishers
+func (ps *publishers) close() {
+	ps.mux.Lock()
+	defer ps.mux.Unlock()
+	if ps.active != nil {
+		ps.active.close()
+	}
+	if ps.standby != nil {
+		ps.standby.close()
+	}
+}
+
+func (ps *publishers) status() PublishersStatus {
+	ps.mux.Lock()
+	defer ps.mux.Unlock()
+	return PublishersStatus{Policy: ps.policy, Active: ps.active.status(), Standby: ps.standby.status()}
+}
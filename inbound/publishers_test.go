@@ -0,0 +1,205 @@
+package inbound
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// fakePublisher is a connection sending the chunks written to data, its reads fail once it is closed
+type fakePublisher struct {
+	*publisher
+	data   chan []byte
+	closed chan struct{}
+}
+
+var errDisconnected = errors.New("disconnected")
+
+func newFakePublisher(addr string) *fakePublisher {
+	f := &fakePublisher{data: make(chan []byte), closed: make(chan struct{})}
+	f.publisher = newPublisher(addr, func(p []byte) (int, error) {
+		select {
+		case b := <-f.data:
+			return copy(p, b), nil
+		case <-f.closed:
+			return 0, errDisconnected
+		}
+	}, func() error {
+		close(f.closed)
+		return nil
+	})
+	return f
+}
+
+func (f *fakePublisher) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func newTestPublishers(t *testing.T, policy PublisherPolicy) *publishers {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	reader := NewAsyncReader()
+	ps, err := newPublishers(policy, reader, log.NewEntry(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return ps
+}
+
+func TestPublisherPolicy(t *testing.T) {
+	tests := []struct {
+		policy  PublisherPolicy
+		active  string
+		standby string
+		// closed lists the publishers disconnected by the policy
+		closed []string
+	}{
+		{policy: "", active: "a", standby: "b", closed: []string{"c"}},
+		{policy: PublisherStandby, active: "a", standby: "b", closed: []string{"c"}},
+		{policy: PublisherReject, active: "a", closed: []string{"b", "c"}},
+		{policy: PublisherTakeover, active: "c", closed: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			ps := newTestPublishers(t, tt.policy)
+			all := map[string]*fakePublisher{}
+			for _, addr := range []string{"a", "b", "c"} {
+				all[addr] = newFakePublisher(addr)
+				ps.add(all[addr].publisher)
+			}
+			st := ps.status()
+			if st.Active == nil || st.Active.Addr != tt.active {
+				t.Fatalf("got the active publisher %+v, want %s", st.Active, tt.active)
+			}
+			if (st.Standby == nil) != (tt.standby == "") || st.Standby != nil && st.Standby.Addr != tt.standby {
+				t.Fatalf("got the standby publisher %+v, want %q", st.Standby, tt.standby)
+			}
+			closed := map[string]bool{}
+			for _, addr := range tt.closed {
+				closed[addr] = true
+			}
+			for addr, p := range all {
+				if p.isClosed() != closed[addr] {
+					t.Errorf("publisher %s closed: %v, want %v", addr, p.isClosed(), closed[addr])
+				}
+			}
+		})
+	}
+
+	if _, err := newPublishers("queue", NewAsyncReader(), nil); err == nil {
+		t.Fatal("an unknown policy is accepted")
+	}
+}
+
+func TestPublisherReplace(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PublisherPolicy
+		// replace replaces the publisher a while a read is pending on it
+		replace func(a, b *fakePublisher, ps *publishers)
+	}{
+		{
+			name:   "standby promoted",
+			policy: PublisherStandby,
+			replace: func(a, b *fakePublisher, ps *publishers) {
+				ps.add(b.publisher)
+				a.close()
+			},
+		},
+		{
+			name:   "takeover",
+			policy: PublisherTakeover,
+			replace: func(a, b *fakePublisher, ps *publishers) {
+				ps.add(b.publisher)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newTestPublishers(t, tt.policy)
+			empty := make(chan struct{})
+			ps.empty = func() { close(empty) }
+			a, b := newFakePublisher("a"), newFakePublisher("b")
+			ps.add(a.publisher)
+			go ps.serve()
+
+			read := func() (string, string, error) {
+				pkt, err := ps.reader.ReadPacket()
+				if err != nil {
+					return "", "", err
+				}
+				defer pkt.Release()
+				return string(pkt.Payload), pkt.Source, nil
+			}
+			expect := func(data, source string) {
+				t.Helper()
+				if gotData, gotSource, err := read(); gotData != data || gotSource != source || err != nil {
+					t.Fatalf("read %q from %q with %v, want %q from %q", gotData, gotSource, err, data, source)
+				}
+			}
+
+			go func() { a.data <- []byte("a1") }()
+			expect("a1", "a")
+			// the next read is pending on a when it is replaced, it is retried on b without failing
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				tt.replace(a, b, ps)
+				b.data <- []byte("b1")
+			}()
+			expect("b1", "b")
+			if !a.isClosed() {
+				t.Fatal("the replaced publisher is still connected")
+			}
+			if st := ps.status(); st.Active == nil || st.Active.Addr != "b" || st.Standby != nil {
+				t.Fatalf("got the status %+v, want b active", st)
+			}
+
+			// the error is returned once no other publisher can take over
+			b.close()
+			if _, _, err := read(); err != errDisconnected {
+				t.Fatalf("got the error %v, want %v", err, errDisconnected)
+			}
+			select {
+			case <-empty:
+			case <-time.After(time.Second):
+				t.Fatal("empty not called once the last publisher disconnected")
+			}
+			if st := ps.status(); st.Active != nil {
+				t.Fatalf("got the active publisher %+v after it disconnected", st.Active)
+			}
+		})
+	}
+}
+
+func TestPublisherAfterClose(t *testing.T) {
+	ps := newTestPublishers(t, PublisherStandby)
+	a, b := newFakePublisher("a"), newFakePublisher("b")
+	ps.add(a.publisher)
+	ps.add(b.publisher)
+	done := make(chan struct{})
+	go func() {
+		ps.serve()
+		close(done)
+	}()
+	ps.reader.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("serve still running once the reader is closed")
+	}
+	if !a.isClosed() || !b.isClosed() {
+		t.Fatal("the publishers are still connected once the reader is closed")
+	}
+	c := newFakePublisher("c")
+	if ps.add(c.publisher); !c.isClosed() {
+		t.Fatal("a publisher is accepted once the reader is closed")
+	}
+}
@@ -1,32 +1,151 @@
 package inbound
 
 import (
+	"errors"
 	"github.com/haivision/srtgo"
+	"github.com/howyoungzhou/golive/auth"
+	"github.com/howyoungzhou/golive/reconnect"
 	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/srtsock"
+	"github.com/howyoungzhou/golive/srtstats"
+	"github.com/howyoungzhou/golive/streamid"
 	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"sync"
 )
 
+// SRTMode decides how an SRT connection is established
+type SRTMode string
+
+const (
+	// SRTModeListener accepts the connections on Host and Port, it is the default mode
+	SRTModeListener SRTMode = "listener"
+	// SRTModeCaller connects to the listener on Host and Port, and reconnects once the connection is lost
+	SRTModeCaller SRTMode = "caller"
+	// SRTModeRendezvous connects to the peer on Host and Port from LocalPort while the peer connects back, and
+	// reconnects once the connection is lost
+	SRTModeRendezvous SRTMode = "rendezvous"
+)
+
+// CheckSRTPeer runs the checks of an authorizer but the callback in an SRT listen callback, and sets the passphrase of
+// the stream of the peer. The peers not allowed are rejected with srtsock.RejectForbidden, the others with
+// srtsock.RejectUnauthorized.
+func CheckSRTPeer(a *auth.Authorizer, c *srtsock.Conn, req auth.Request) error {
+	if err := a.Check(req); err == auth.ErrNotAllowed {
+		return srtsock.Reject(srtsock.RejectForbidden, err)
+	} else if err != nil {
+		return srtsock.Reject(srtsock.RejectUnauthorized, err)
+	}
+	if passphrase, ok := a.Passphrase(req.Stream); ok {
+		return c.SetPassphrase(passphrase)
+	}
+	return nil
+}
+
+// DialSRT connects to a remote peer in caller or rendezvous mode, localPort is only bound in rendezvous mode
+func DialSRT(mode SRTMode, host string, port, localPort uint16, options map[string]string) (*srtgo.SrtSocket, error) {
+	sck := srtgo.NewSrtSocket(host, port, options)
+	if sck == nil {
+		return nil, errors.New("failed to create SRT socket")
+	}
+	if mode == SRTModeRendezvous {
+		if err := srtsock.Rendezvous(sck, host, localPort); err != nil {
+			sck.Close()
+			return nil, err
+		}
+	}
+	if err := sck.Connect(); err != nil {
+		sck.Close()
+		return nil, err
+	}
+	return sck, nil
+}
+
 type SRTInboundOptions struct {
-	Host    string
-	Port    uint16
-	Timeout int
-	Options map[string]string
+	// Mode is SRTModeListener by default
+	Mode SRTMode
+	Host string
+	Port uint16
+	// LocalPort is the local port in rendezvous mode, Port by default
+	LocalPort uint16
+	Timeout   int
+	Options   map[string]string
+	// PublisherPolicy decides what happens when a second publisher connects, PublisherStandby by default
+	PublisherPolicy PublisherPolicy
+	// RouteStreams routes the publishers to a stream named after the resource of their stream id, e.g. "live/cam1"
+	// for "#!::r=live/cam1,m=publish". The streams are read as "<id>/<resource>", the publishers without a stream id
+	// are read from the inbound itself.
+	RouteStreams bool
+	// MaxStreams limits the number of streams, 0 means no limit
+	MaxStreams int
+	// Reconnect sets the backoff between the connection attempts in caller and rendezvous mode
+	Reconnect reconnect.Options
+	// Stats sets the interval between the samples of the statistics of each connection
+	Stats srtstats.Options
+	// Auth authorizes the publishers in listener mode and sets the passphrase of each stream, the token is read from
+	// the "token" key of the stream id
+	Auth auth.Options
+	// MPEGTS declares the data as MPEG-TS, the H.264 keyframes of the inbound and of its streams are then flagged for
+	// the dropUntilKeyframe policy
+	MPEGTS bool
 }
 
 // SRTInbound implements SRT protocol for input
 type SRTInbound struct {
-	options *SRTInboundOptions
-	logger  *log.Entry
-	reader  *AsyncReader
+	options    *SRTInboundOptions
+	logger     *log.Entry
+	reader     *AsyncReader
+	publishers *publishers
+	sck        *srtgo.SrtSocket
+	// removeCallback removes the listen callback once the listener is closed
+	removeCallback func()
+	server         *server.Server
+	id             string
+	streams        map[string]*publishers
+	streamsMux     sync.Mutex
+	// createMux serializes the creation of the streams
+	createMux sync.Mutex
+	auth      *auth.Authorizer
+	caller    *reconnect.Loop
+	samplers  *srtstats.Set
 }
 
 // NewSrtpInbound creates a new instance of SRTInbound
 func NewSrtpInbound(options *SRTInboundOptions) (*SRTInbound, error) {
+	switch options.Mode {
+	case "", SRTModeListener, SRTModeCaller, SRTModeRendezvous:
+	default:
+		return nil, errors.New("unknown SRT mode: " + string(options.Mode))
+	}
+	if options.LocalPort == 0 {
+		options.LocalPort = options.Port
+	}
+	if options.Mode != "" && options.Mode != SRTModeListener && options.RouteStreams {
+		return nil, errors.New("routeStreams is only supported in listener mode")
+	}
+	authorizer, err := auth.New(options.Auth)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New().WithFields(log.Fields{"module": "SRTInbound"})
+	reader := NewAsyncReader()
+	if options.MPEGTS {
+		reader.ScanKeyframes()
+	}
+	ps, err := newPublishers(options.PublisherPolicy, reader, logger)
+	if err != nil {
+		return nil, err
+	}
 	return &SRTInbound{
-		options,
-		log.New().WithFields(log.Fields{"module": "SRTInbound"}),
-		NewAsyncReader(),
+		options:    options,
+		logger:     logger,
+		reader:     reader,
+		publishers: ps,
+		streams:    make(map[string]*publishers),
+		samplers:   srtstats.NewSet(options.Stats, logger),
+		auth:       authorizer,
 	}, nil
 }
 
@@ -36,41 +155,294 @@ func RegisterSRTInbound(server *server.Server, id string, options map[string]int
 	if err := mapstructure.Decode(options, opt); err != nil {
 		return nil, err
 	}
-	return NewSrtpInbound(opt)
+	in, err := NewSrtpInbound(opt)
+	if err != nil {
+		return nil, err
+	}
+	in.server, in.id = server, id
+	return in, nil
 }
 
-// Init starts the SRT server
+// Init starts the SRT server, or connects to the remote peer in caller and rendezvous mode
 func (s *SRTInbound) Init() error {
+	if s.options.Mode == SRTModeCaller || s.options.Mode == SRTModeRendezvous {
+		addr := net.JoinHostPort(s.options.Host, strconv.Itoa(int(s.options.Port)))
+		s.caller = reconnect.New(addr, s.dial, s.options.Reconnect, s.logger)
+		go s.publishers.serve()
+		go s.caller.Run()
+		return nil
+	}
 	sck := srtgo.NewSrtSocket(s.options.Host, s.options.Port, s.options.Options)
-	err := sck.Listen(2)
+	if sck == nil {
+		return errors.New("failed to create SRT socket")
+	}
+	removeCallback, err := srtsock.SetListenCallback(sck, s.check)
 	if err != nil {
-		panic(err)
+		sck.Close()
+		return err
+	}
+	if err := sck.Listen(2); err != nil {
+		removeCallback()
+		return err
 	}
+	s.sck, s.removeCallback = sck, removeCallback
 	s.logger.WithFields(log.Fields{"host": s.options.Host, "port": s.options.Port}).Info("The server is listening")
+	go s.publishers.serve()
 	go func() {
 		// loop to accept new connections
 		for {
 			remoteSck, addr, err := sck.Accept()
 			if err != nil {
-				continue
-			}
-			s.logger.WithFields(log.Fields{"host": addr.IP, "port": addr.Port}).Info("Incoming connection")
-			// loop to receive packets once the connection is established
-			for {
-				n, err := remoteSck.Read(s.reader.Fetch(), s.options.Timeout)
-				s.reader.Return(n, err)
-				if err != nil {
-					remoteSck.Close()
-					s.logger.WithFields(log.Fields{"host": addr.IP, "port": addr.Port}).Info("Connection closed")
-					break
+				select {
+				case <-s.reader.Done():
+					return
+				default:
+					continue
 				}
 			}
+			s.logger.WithFields(log.Fields{"host": addr.IP, "port": addr.Port}).Info("Incoming connection")
+			// the connections are handled concurrently so a slow auth callback does not hold the others
+			go s.accept(remoteSck, addr.String())
 		}
 	}()
 	return nil
 }
 
+// check rejects the publishers in the listen callback, so they are told why, and sets the passphrase of their stream.
+// The auth callback is left to accept since it would block the receiving thread of libsrt.
+func (s *SRTInbound) check(c *srtsock.Conn) error {
+	if !s.options.RouteStreams && s.auth == nil {
+		return nil
+	}
+	stream, id, err := s.route(c.StreamID)
+	if err == nil {
+		req := auth.Request{ID: s.id, Addr: c.Addr, Stream: stream, Mode: auth.ModePublish, User: id.User, Token: id.Keys["token"]}
+		err = CheckSRTPeer(s.auth, c, req)
+	}
+	if err != nil {
+		s.logger.WithField("addr", c.Addr).WithError(err).Warn("Publisher rejected")
+	}
+	return err
+}
+
+// accept authorizes a new connection and adds it to the publishers of its stream
+func (s *SRTInbound) accept(sck *srtgo.SrtSocket, addr string) {
+	logger := s.logger.WithField("addr", addr)
+	id := &streamid.StreamID{}
+	stream := ""
+	if s.options.RouteStreams || s.auth != nil {
+		sid, err := sck.GetSockOptString(srtgo.SRTO_STREAMID)
+		if err == nil {
+			stream, id, err = s.route(sid)
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Publisher rejected")
+			sck.Close()
+			return
+		}
+		if stream != "" {
+			logger = logger.WithField("stream", stream)
+		}
+	}
+	req := auth.Request{ID: s.id, Addr: addr, Stream: stream, Mode: auth.ModePublish, User: id.User, Token: id.Keys["token"]}
+	if err := s.auth.Authorize(req); err != nil {
+		logger.WithError(err).Warn("Publisher rejected")
+		sck.Close()
+		return
+	}
+
+	p := s.newPublisher(sck, addr, stream, nil)
+	if stream == "" {
+		s.publishers.add(p)
+		return
+	}
+	ps, err := s.stream(stream)
+	if err != nil {
+		logger.WithError(err).Warn("Publisher rejected")
+		p.close()
+		return
+	}
+	ps.add(p)
+}
+
+// newPublisher creates a publisher reading from a connection, the statistics of the connection are sampled until it
+// is closed. lost is closed, if not nil, once the connection is closed.
+func (s *SRTInbound) newPublisher(sck *srtgo.SrtSocket, addr, stream string, lost chan struct{}) *publisher {
+	sampler := s.samplers.Start(sck, addr, stream)
+	read := func(p []byte) (int, error) {
+		return sck.Read(p, s.options.Timeout)
+	}
+	closeConn := func() error {
+		sampler.Stop()
+		sck.Close()
+		if lost != nil {
+			close(lost)
+		}
+		return nil
+	}
+	return newPublisher(addr, read, closeConn)
+}
+
+// dial connects to the remote peer, the connection is the publisher of the inbound
+func (s *SRTInbound) dial() (<-chan struct{}, error) {
+	sck, err := DialSRT(s.options.Mode, s.options.Host, s.options.Port, s.options.LocalPort, s.options.Options)
+	if err != nil {
+		return nil, err
+	}
+	// the publisher is closed once
+	lost := make(chan struct{})
+	s.publishers.add(s.newPublisher(sck, s.caller.Status().Addr, "", lost))
+	return lost, nil
+}
+
+// route parses the stream id of a publisher and selects its stream, "" if the streams are not routed
+func (s *SRTInbound) route(sid string) (string, *streamid.StreamID, error) {
+	id, err := streamid.Parse(sid)
+	if err != nil {
+		return "", nil, srtsock.Reject(srtsock.RejectBadRequest, err)
+	}
+	if !s.options.RouteStreams {
+		return "", id, nil
+	}
+	stream, err := s.selectStream(id)
+	return stream, id, err
+}
+
+// selectStream returns the stream named after the resource of the stream id of a publisher, "" if it has no resource.
+// The errors carry the reason of the rejection.
+func (s *SRTInbound) selectStream(id *streamid.StreamID) (string, error) {
+	if id.Resource == "" {
+		return "", nil
+	}
+	if id.Mode == streamid.ModeRequest || id.Mode == streamid.ModeBidirectional {
+		return "", srtsock.Reject(srtsock.RejectBadMode, errors.New("the inbound does not serve playback requests"))
+	}
+	if !streamid.ValidResource(id.Resource) {
+		return "", srtsock.Reject(srtsock.RejectBadRequest, errors.New("invalid resource: "+id.Resource))
+	}
+	return id.Resource, nil
+}
+
+// stream returns the publishers of a stream, the stream is created and added to the server if needed
+func (s *SRTInbound) stream(name string) (*publishers, error) {
+	// streamsMux is only held briefly since the server may be closing the inbound while a stream is added
+	s.createMux.Lock()
+	defer s.createMux.Unlock()
+	s.streamsMux.Lock()
+	ps, ok := s.streams[name]
+	n := len(s.streams)
+	s.streamsMux.Unlock()
+	if ok {
+		return ps, nil
+	}
+	if s.options.MaxStreams > 0 && n >= s.options.MaxStreams {
+		return nil, errors.New("too many streams")
+	}
+	reader := NewAsyncReader()
+	if s.options.MPEGTS {
+		reader.ScanKeyframes()
+	}
+	ps, err := newPublishers(s.options.PublisherPolicy, reader, s.logger.WithField("stream", name))
+	if err != nil {
+		return nil, err
+	}
+	ps.empty = func() {
+		s.removeStream(name, ps)
+	}
+	// AddStream fails once the inbound is closed
+	if err := s.server.AddStream(s.id, name, reader); err != nil {
+		return nil, err
+	}
+	s.streamsMux.Lock()
+	defer s.streamsMux.Unlock()
+	select {
+	case <-s.reader.Done():
+		reader.Close()
+		return nil, errors.New("inbound closed")
+	default:
+	}
+	s.streams[name] = ps
+	go ps.serve()
+	return ps, nil
+}
+
+// removeStream removes a stream once its last publisher disconnects
+func (s *SRTInbound) removeStream(name string, ps *publishers) {
+	s.streamsMux.Lock()
+	if s.streams[name] != ps || ps.status().Active != nil {
+		// a publisher connected in the meantime
+		s.streamsMux.Unlock()
+		return
+	}
+	delete(s.streams, name)
+	s.streamsMux.Unlock()
+	ps.reader.Close()
+	s.server.RemoveStream(s.id, name, ps.reader)
+}
+
 // Read blocks to wait for a packet and puts it in the buffer
 func (s *SRTInbound) Read(p []byte) (n int, err error) {
 	return s.reader.Read(p)
 }
+
+// ReadPacket blocks to wait for a packet of the active publisher, the source is the address of the peer
+func (s *SRTInbound) ReadPacket() (*server.Packet, error) {
+	return s.reader.ReadPacket()
+}
+
+// FlagsKeyframes reports whether the packets flag the keyframes of the MPEG-TS carried
+func (s *SRTInbound) FlagsKeyframes() bool {
+	return s.reader.FlagsKeyframes()
+}
+
+// SRTInboundStatus describes the publishers of an SRT inbound and of its streams
+type SRTInboundStatus struct {
+	PublishersStatus
+	Streams map[string]PublishersStatus `json:"streams,omitempty"`
+	// Connection is the state of the connection in caller and rendezvous mode
+	Connection *reconnect.Status `json:"connection,omitempty"`
+}
+
+// Status returns the connected publishers
+func (s *SRTInbound) Status() interface{} {
+	res := SRTInboundStatus{PublishersStatus: s.publishers.status()}
+	if s.caller != nil {
+		status := s.caller.Status()
+		res.Connection = &status
+	}
+	s.streamsMux.Lock()
+	defer s.streamsMux.Unlock()
+	if len(s.streams) > 0 {
+		res.Streams = make(map[string]PublishersStatus)
+		for name, ps := range s.streams {
+			res.Streams[name] = ps.status()
+		}
+	}
+	return res
+}
+
+// Connections returns the statistics of the connected publishers
+func (s *SRTInbound) Connections() []server.ConnectionStats {
+	return s.samplers.List()
+}
+
+// Close stops the SRT server and disconnects the publishers
+func (s *SRTInbound) Close() error {
+	s.reader.Close()
+	if s.caller != nil {
+		s.caller.Close()
+	}
+	s.publishers.close()
+	s.streamsMux.Lock()
+	for name, ps := range s.streams {
+		ps.reader.Close()
+		ps.close()
+		delete(s.streams, name)
+	}
+	s.streamsMux.Unlock()
+	if s.sck != nil {
+		s.sck.Close()
+		s.removeCallback()
+	}
+	return nil
+}
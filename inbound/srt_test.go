@@ -0,0 +1,122 @@
+package inbound
+
+import (
+	"github.com/howyoungzhou/golive/auth"
+	"github.com/howyoungzhou/golive/srtsock"
+	"github.com/howyoungzhou/golive/streamid"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSRTInboundSelectStream(t *testing.T) {
+	tests := []struct {
+		sid  string
+		want string
+		err  bool
+	}{
+		{sid: "", want: ""},
+		{sid: "live/cam1", want: "live/cam1"},
+		{sid: "#!::r=live/cam1", want: "live/cam1"},
+		{sid: "#!::r=live/cam1,m=publish", want: "live/cam1"},
+		{sid: "#!::u=alice,m=publish", want: ""},
+		{sid: "#!::r=live/cam1,m=request", err: true},
+		{sid: "#!::r=live/cam1,m=bidirectional", err: true},
+		{sid: "#!::r=/live,m=publish", err: true},
+		{sid: `#!::r=live\,cam1,m=publish`, err: true},
+		{sid: "../live", err: true},
+	}
+	s := &SRTInbound{}
+	for _, tt := range tests {
+		id, err := streamid.Parse(tt.sid)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.sid, err)
+		}
+		got, err := s.selectStream(id)
+		if tt.err {
+			if err == nil {
+				t.Errorf("selectStream(%q) = %q, want an error", tt.sid, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("selectStream(%q) = %q, %v, want %q", tt.sid, got, err, tt.want)
+		}
+	}
+}
+
+func TestNewSrtpInboundModes(t *testing.T) {
+	tests := []struct {
+		options   SRTInboundOptions
+		localPort uint16
+		err       bool
+	}{
+		{options: SRTInboundOptions{Port: 9000}, localPort: 9000},
+		{options: SRTInboundOptions{Mode: SRTModeCaller, Port: 9000}, localPort: 9000},
+		{options: SRTInboundOptions{Mode: SRTModeRendezvous, Port: 9000}, localPort: 9000},
+		{options: SRTInboundOptions{Mode: SRTModeRendezvous, Port: 9000, LocalPort: 9001}, localPort: 9001},
+		{options: SRTInboundOptions{Mode: "server", Port: 9000}, err: true},
+		{options: SRTInboundOptions{Mode: SRTModeListener, Port: 9000, RouteStreams: true}, localPort: 9000},
+		{options: SRTInboundOptions{Mode: SRTModeCaller, Port: 9000, RouteStreams: true}, err: true},
+		{options: SRTInboundOptions{Mode: SRTModeRendezvous, Port: 9000, RouteStreams: true}, err: true},
+	}
+	for _, tt := range tests {
+		options := tt.options
+		s, err := NewSrtpInbound(&options)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%+v: got no error", tt.options)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", tt.options, err)
+			continue
+		}
+		if s.options.LocalPort != tt.localPort {
+			t.Errorf("%+v: got the local port %d, want %d", tt.options, s.options.LocalPort, tt.localPort)
+		}
+	}
+}
+
+func TestSRTInboundCheck(t *testing.T) {
+	publish := auth.Sign("secret", "live/cam1", auth.ModePublish, time.Now().Add(time.Minute))
+	play := auth.Sign("secret", "live/cam1", auth.ModePlay, time.Now().Add(time.Minute))
+	tests := []struct {
+		addr string
+		sid  string
+		// reason is the reason of the rejection, 0 if the publisher is accepted
+		reason int
+	}{
+		{addr: "10.1.2.3:9000", sid: "#!::r=live/cam1,m=publish,token=" + publish},
+		{addr: "10.1.2.3:9000", sid: "#!::r=live/cam1,m=publish", reason: srtsock.RejectUnauthorized},
+		{addr: "10.1.2.3:9000", sid: "#!::r=live/cam1,m=publish,token=" + play, reason: srtsock.RejectUnauthorized},
+		{addr: "192.168.1.10:9000", sid: "#!::r=live/cam1,m=publish", reason: srtsock.RejectForbidden},
+		{addr: "10.1.2.3:9000", sid: "#!::r=live/cam1,m=request", reason: srtsock.RejectBadMode},
+		{addr: "10.1.2.3:9000", sid: "#!::r=../live,m=publish", reason: srtsock.RejectBadRequest},
+		{addr: "10.1.2.3:9000", sid: "#!::m=stream", reason: srtsock.RejectBadRequest},
+	}
+	s, err := NewSrtpInbound(&SRTInboundOptions{
+		RouteStreams: true,
+		Auth:         auth.Options{Allow: []string{"10.0.0.0/8"}, Secret: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.logger.Logger.SetOutput(ioutil.Discard)
+	for _, tt := range tests {
+		err := s.check(&srtsock.Conn{Addr: tt.addr, StreamID: tt.sid})
+		reason := 0
+		if err != nil {
+			rejectErr, ok := err.(*srtsock.RejectError)
+			if !ok {
+				t.Errorf("check(%s, %q) = %v, want a *srtsock.RejectError", tt.addr, tt.sid, err)
+				continue
+			}
+			reason = rejectErr.Reason
+		}
+		if reason != tt.reason {
+			t.Errorf("check(%s, %q) rejects with %d, want %d", tt.addr, tt.sid, reason, tt.reason)
+		}
+	}
+}
@@ -10,19 +10,35 @@ import (
 type TCPInboundOptions struct {
 	Network string `json:"network"`
 	Address string `json:"address"`
+	// PublisherPolicy decides what happens when a second publisher connects, PublisherStandby by default
+	PublisherPolicy PublisherPolicy `json:"publisherPolicy"`
+	// MPEGTS declares the data as MPEG-TS, the H.264 keyframes are then flagged for the dropUntilKeyframe policy
+	MPEGTS bool `json:"mpegts"`
 }
 
 type TCPInbound struct {
-	options *TCPInboundOptions
-	logger  *log.Entry
-	reader  *AsyncReader
+	options    *TCPInboundOptions
+	logger     *log.Entry
+	reader     *AsyncReader
+	publishers *publishers
+	ln         net.Listener
 }
 
 func NewTCPInbound(options *TCPInboundOptions) (*TCPInbound, error) {
+	logger := log.New().WithFields(log.Fields{"module": "TCPInbound"})
+	reader := NewAsyncReader()
+	if options.MPEGTS {
+		reader.ScanKeyframes()
+	}
+	publishers, err := newPublishers(options.PublisherPolicy, reader, logger)
+	if err != nil {
+		return nil, err
+	}
 	res := &TCPInbound{
-		options: options,
-		logger:  log.New().WithFields(log.Fields{"module": "TCPInbound"}),
-		reader:  NewAsyncReader(),
+		options:    options,
+		logger:     logger,
+		reader:     reader,
+		publishers: publishers,
 	}
 	return res, nil
 }
@@ -38,28 +54,27 @@ func RegisterTCPInbound(server *server.Server, id string, options map[string]int
 func (s *TCPInbound) Init() error {
 	ln, err := net.Listen(s.options.Network, s.options.Address)
 	if err != nil {
-		s.logger.WithError(err).WithFields(log.Fields{"network": s.options.Network, "addr": s.options.Address}).Fatal("Failed to listen")
+		s.logger.WithError(err).WithFields(log.Fields{"network": s.options.Network, "addr": s.options.Address}).Error("Failed to listen")
 		return err
 	}
 
+	s.ln = ln
 	s.logger.WithFields(log.Fields{"network": s.options.Network, "addr": ln.Addr()}).Info("The server is listening")
+	go s.publishers.serve()
 	go func() {
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
-				s.logger.WithField("addr", conn.RemoteAddr()).Warn("Failed to accept connection")
+				select {
+				case <-s.reader.Done():
+					return
+				default:
+				}
+				s.logger.WithError(err).Warn("Failed to accept connection")
 				continue
 			}
 			s.logger.WithField("addr", conn.RemoteAddr()).Info("Incoming connection")
-			for {
-				n, err := conn.Read(s.reader.Fetch())
-				s.reader.Return(n, err)
-				if err != nil {
-					conn.Close()
-					s.logger.WithFields(log.Fields{"addr": conn.RemoteAddr()}).Info("Connection closed")
-					break
-				}
-			}
+			s.publishers.add(newPublisher(conn.RemoteAddr().String(), conn.Read, conn.Close))
 		}
 	}()
 	return nil
@@ -68,3 +83,28 @@ func (s *TCPInbound) Init() error {
 func (s *TCPInbound) Read(p []byte) (n int, err error) {
 	return s.reader.Read(p)
 }
+
+// ReadPacket blocks to wait for the data of the active publisher, the source is the address of the peer
+func (s *TCPInbound) ReadPacket() (*server.Packet, error) {
+	return s.reader.ReadPacket()
+}
+
+// FlagsKeyframes reports whether the packets flag the keyframes of the MPEG-TS carried
+func (s *TCPInbound) FlagsKeyframes() bool {
+	return s.reader.FlagsKeyframes()
+}
+
+// Status returns the connected publishers
+func (s *TCPInbound) Status() interface{} {
+	return s.publishers.status()
+}
+
+// Close stops the TCP server and disconnects the publishers
+func (s *TCPInbound) Close() error {
+	s.reader.Close()
+	s.publishers.close()
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
@@ -10,12 +10,15 @@ import (
 type UDPInboundOptions struct {
 	Network string `json:"network"`
 	Address string `json:"address"`
+	// MPEGTS declares the data as MPEG-TS, the H.264 keyframes are then flagged for the dropUntilKeyframe policy
+	MPEGTS bool `json:"mpegts"`
 }
 
 type UDPInbound struct {
 	options *UDPInboundOptions
 	logger  *log.Entry
 	reader  *AsyncReader
+	conn    net.PacketConn
 }
 
 func NewUDPInbound(options *UDPInboundOptions) (*UDPInbound, error) {
@@ -24,6 +27,9 @@ func NewUDPInbound(options *UDPInboundOptions) (*UDPInbound, error) {
 		logger:  log.New().WithFields(log.Fields{"module": "UDPInbound"}),
 		reader:  NewAsyncReader(),
 	}
+	if options.MPEGTS {
+		res.reader.ScanKeyframes()
+	}
 	return res, nil
 }
 
@@ -38,16 +44,26 @@ func RegisterUDPInbound(server *server.Server, id string, options map[string]int
 func (s *UDPInbound) Init() error {
 	conn, err := net.ListenPacket(s.options.Network, s.options.Address)
 	if err != nil {
-		s.logger.WithError(err).WithFields(log.Fields{"network": s.options.Network, "addr": s.options.Address}).Fatal("Failed to listen")
+		s.logger.WithError(err).WithFields(log.Fields{"network": s.options.Network, "addr": s.options.Address}).Error("Failed to listen")
 		return err
 	}
 
+	s.conn = conn
 	s.logger.WithFields(log.Fields{"network": s.options.Network, "addr": conn.LocalAddr()}).Info("The server is listening")
 	go func() {
 		defer conn.Close()
 		for {
-			n, _, err := conn.ReadFrom(s.reader.Fetch())
-			s.reader.Return(n, err)
+			buf := s.reader.Fetch()
+			if buf == nil {
+				// the inbound is closed
+				return
+			}
+			n, addr, err := conn.ReadFrom(buf)
+			source := ""
+			if addr != nil {
+				source = addr.String()
+			}
+			s.reader.ReturnFrom(n, source, err)
 		}
 	}()
 	return nil
@@ -56,3 +72,22 @@ func (s *UDPInbound) Init() error {
 func (s *UDPInbound) Read(p []byte) (n int, err error) {
 	return s.reader.Read(p)
 }
+
+// ReadPacket blocks to wait for a datagram, the source is the address of the sender
+func (s *UDPInbound) ReadPacket() (*server.Packet, error) {
+	return s.reader.ReadPacket()
+}
+
+// FlagsKeyframes reports whether the packets flag the keyframes of the MPEG-TS carried
+func (s *UDPInbound) FlagsKeyframes() bool {
+	return s.reader.FlagsKeyframes()
+}
+
+// Close stops listening
+func (s *UDPInbound) Close() error {
+	s.reader.Close()
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,482 @@
+package inbound
+
+import (
+	"errors"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/howyoungzhou/golive/auth"
+	"github.com/howyoungzhou/golive/rtc"
+	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/web"
+	"github.com/howyoungzhou/golive/whip"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWHIPRootPath   = "/whip"
+	defaultWHIPBufferSize = 256
+	// maxSDPSize limits the size of the offers
+	maxSDPSize = 64 * 1024
+)
+
+// whipKinds are the kinds of the tracks read from a publisher, each one is read as "<id>:<kind>"
+var whipKinds = []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio}
+
+type WHIPInboundOptions struct {
+	WebRTC webrtc.Configuration
+	// ICE tunes the candidates and the ports of the peer connections, e.g. to serve them all on a single port
+	ICE rtc.Options
+	// SDPServer receives the offers of the publishers on RootPath ("/whip" by default), the sessions are served under
+	// "<RootPath>/<session id>". CORS is only enabled if origins are allowed. The routes are mounted on the shared HTTP
+	// server of the config if ListenAddress is empty.
+	SDPServer struct {
+		CORS          cors.Config
+		ListenAddress string
+		RootPath      string
+	}
+	// BufferSize is the number of RTP packets buffered for each track (256 by default), the packets are dropped once
+	// the buffer is full
+	BufferSize int
+	// Auth authorizes the publishers, the token is read from the bearer token of the Authorization header
+	Auth auth.Options
+}
+
+// WHIPInbound receives WebRTC publishers with the WebRTC-HTTP ingestion protocol, the RTP packets of the video and the
+// audio tracks are read from "<id>:video" and "<id>:audio". A single publisher is accepted at a time.
+type WHIPInbound struct {
+	options *WHIPInboundOptions
+	id      string
+	tracks  map[webrtc.RTPCodecType]*whipTrack
+	session *whipSession
+	mux     sync.Mutex
+	auth    *auth.Authorizer
+	logger  *log.Entry
+	srv     *http.Server
+	// server provides the shared HTTP server, unmount removes the routes from it
+	server  *server.Server
+	unmount func()
+	api     *rtc.API
+	done    chan struct{}
+	once    sync.Once
+	// negotiating is set while the offer of a publisher is answered
+	negotiating bool
+}
+
+// whipSession is the peer connection of a publisher
+type whipSession struct {
+	id    string
+	addr  string
+	since time.Time
+	pc    *webrtc.PeerConnection
+	// state is the webrtc.PeerConnectionState of the peer connection, accessed atomically
+	state int64
+	kinds []string
+	// ssrcs are the SSRCs of the tracks by kind, the keyframes are requested with them
+	ssrcs map[webrtc.RTPCodecType]webrtc.SSRC
+	mux   sync.Mutex
+}
+
+// whipTrack is the reader of the RTP packets received on the tracks of a kind
+type whipTrack struct {
+	c       chan *server.Packet
+	done    <-chan struct{}
+	dropped uint64
+}
+
+// NewWHIPInbound creates a new instance of WHIPInbound
+func NewWHIPInbound(options *WHIPInboundOptions) (*WHIPInbound, error) {
+	if options.SDPServer.RootPath == "" {
+		options.SDPServer.RootPath = defaultWHIPRootPath
+	}
+	if options.BufferSize <= 0 {
+		options.BufferSize = defaultWHIPBufferSize
+	}
+	if len(options.Auth.Passphrases) > 0 {
+		return nil, errors.New("passphrases are only supported by SRT")
+	}
+	authorizer, err := auth.New(options.Auth)
+	if err != nil {
+		return nil, err
+	}
+	res := &WHIPInbound{
+		options: options,
+		tracks:  make(map[webrtc.RTPCodecType]*whipTrack),
+		auth:    authorizer,
+		logger:  log.New().WithFields(log.Fields{"module": "WHIPInbound"}),
+		done:    make(chan struct{}),
+	}
+	for _, kind := range whipKinds {
+		res.tracks[kind] = &whipTrack{c: make(chan *server.Packet, options.BufferSize), done: res.done}
+	}
+	return res, nil
+}
+
+// RegisterWHIPInbound registers a new instance to the server, along with a reader for each kind of track
+func RegisterWHIPInbound(server *server.Server, id string, options map[string]interface{}) (server.Inbound, error) {
+	opt := &WHIPInboundOptions{}
+	if err := mapstructure.Decode(options, opt); err != nil {
+		return nil, err
+	}
+	res, err := NewWHIPInbound(opt)
+	if err != nil {
+		return nil, err
+	}
+	res.id = id
+	res.server = server
+	for kind, t := range res.tracks {
+		server.AddPacketReader(id+":"+kind.String(), t)
+	}
+	return res, nil
+}
+
+// Init runs the HTTP server receiving the offers, or mounts its routes on the shared HTTP server
+func (w *WHIPInbound) Init() error {
+	root := w.options.SDPServer.RootPath
+	r := gin.Default()
+	middleware, err := web.CORS(w.options.SDPServer.CORS)
+	if err != nil {
+		return err
+	}
+	if middleware != nil {
+		r.Use(middleware)
+	}
+	api, err := rtc.New(w.options.ICE, w.logger)
+	if err != nil {
+		return err
+	}
+	w.api = api
+	r.POST(root, w.handleOffer)
+	r.OPTIONS(root, allowMethods(whipEndpointMethods))
+	r.PATCH(path.Join(root, ":session"), w.handleTrickle)
+	r.DELETE(path.Join(root, ":session"), w.handleDelete)
+	r.OPTIONS(path.Join(root, ":session"), allowMethods(whipSessionMethods))
+	if w.options.SDPServer.ListenAddress == "" {
+		shared, err := web.Shared(w.server)
+		if err == nil {
+			w.unmount, err = shared.Mount(root, r)
+		}
+		if err != nil {
+			w.api.Close()
+			return err
+		}
+		w.logger.WithField("path", root).Info("WHIP server is mounted")
+		return nil
+	}
+	w.srv = &http.Server{Addr: w.options.SDPServer.ListenAddress, Handler: r}
+	go w.serveHTTP()
+	return nil
+}
+
+func (w *WHIPInbound) serveHTTP() {
+	logger := w.logger.WithField("addr", w.options.SDPServer.ListenAddress)
+	logger.Info("WHIP server is listening")
+	err := w.srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		logger.Info("WHIP server closed")
+		return
+	}
+	logger.WithError(err).Error("WHIP server ended with error")
+}
+
+// handleOffer creates a session for the offer of a publisher, the answer is returned once the ICE candidates are
+// gathered so the publishers do not need to trickle them
+func (w *WHIPInbound) handleOffer(c *gin.Context) {
+	logger := w.logger.WithField("addr", c.Request.RemoteAddr)
+	if c.ContentType() != whip.ContentTypeSDP {
+		c.String(http.StatusUnsupportedMediaType, "the offer must be sent as application/sdp")
+		return
+	}
+	err := w.auth.Authorize(auth.Request{
+		ID:    w.id,
+		Addr:  c.Request.RemoteAddr,
+		Mode:  auth.ModePublish,
+		Token: whip.BearerToken(c.Request),
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Publisher rejected")
+		c.String(http.StatusUnauthorized, err.Error())
+		return
+	}
+	offer, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxSDPSize))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.mux.Lock()
+	if w.session != nil || w.negotiating {
+		w.mux.Unlock()
+		c.String(http.StatusConflict, "a publisher is already connected")
+		logger.Info("Publisher rejected, a publisher is already connected")
+		return
+	}
+	w.negotiating = true
+	w.mux.Unlock()
+	session, err := w.newSession(c.Request.RemoteAddr, string(offer))
+	w.mux.Lock()
+	w.negotiating = false
+	if err == nil {
+		select {
+		case <-w.done:
+			err = errors.New("the inbound is closed")
+			session.pc.Close()
+		default:
+			w.session = session
+		}
+	}
+	w.mux.Unlock()
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		logger.WithError(err).Info("failed to negotiate the session")
+		return
+	}
+	c.Header("Location", path.Join(w.options.SDPServer.RootPath, session.id))
+	c.Data(http.StatusCreated, whip.ContentTypeSDP, []byte(session.pc.LocalDescription().SDP))
+	logger.WithField("session", session.id).Info("Publisher connected")
+}
+
+// newSession answers an offer with a new peer connection receiving the video and the audio
+func (w *WHIPInbound) newSession(addr, offer string) (*whipSession, error) {
+	pc, err := w.api.NewPeerConnection(w.options.WebRTC)
+	if err != nil {
+		return nil, err
+	}
+	session := &whipSession{id: whip.NewSessionID(), addr: addr, since: time.Now(), pc: pc,
+		state: int64(webrtc.PeerConnectionStateNew), ssrcs: make(map[webrtc.RTPCodecType]webrtc.SSRC)}
+	for _, kind := range whipKinds {
+		_, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		if err != nil {
+			pc.Close()
+			return nil, err
+		}
+	}
+	// the handler runs in its own goroutine
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		w.readTrack(session, track)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		atomic.StoreInt64(&session.state, int64(state))
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			w.closeSession(session)
+		}
+	})
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	<-gatherComplete
+	return session, nil
+}
+
+// readTrack reads the RTP packets of a track until the session is closed
+func (w *WHIPInbound) readTrack(session *whipSession, track *webrtc.TrackRemote) {
+	t, ok := w.tracks[track.Kind()]
+	if !ok {
+		return
+	}
+	logger := w.logger.WithFields(log.Fields{"session": session.id, "kind": track.Kind().String()})
+	logger.WithField("codec", track.Codec().MimeType).Info("Track received")
+	session.mux.Lock()
+	session.kinds = append(session.kinds, track.Kind().String())
+	session.ssrcs[track.Kind()] = track.SSRC()
+	session.mux.Unlock()
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := track.Read(buf)
+		if err != nil {
+			logger.Info("Track ended")
+			return
+		}
+		pkt := server.NewPacket(n)
+		copy(pkt.Payload, buf[:n])
+		pkt.Time = time.Now()
+		pkt.Source = session.addr
+		t.push(pkt)
+	}
+}
+
+// the methods allowed on the endpoint and on the sessions, PATCH is left out so the publishers do not trickle
+const (
+	whipEndpointMethods = "POST, OPTIONS"
+	whipSessionMethods  = "DELETE, OPTIONS"
+)
+
+// allowMethods answers the OPTIONS requests with the allowed methods
+func allowMethods(methods string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Allow", methods)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// handleTrickle rejects the trickled candidates, all the candidates are sent in the answer
+func (w *WHIPInbound) handleTrickle(c *gin.Context) {
+	if w.lookup(c.Param("session")) == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Header("Allow", whipSessionMethods)
+	c.Status(http.StatusMethodNotAllowed)
+}
+
+// handleDelete closes the session of a publisher
+func (w *WHIPInbound) handleDelete(c *gin.Context) {
+	session := w.lookup(c.Param("session"))
+	if session == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	w.closeSession(session)
+	c.Status(http.StatusOK)
+	w.logger.WithFields(log.Fields{"addr": c.Request.RemoteAddr, "session": session.id}).Info("Session deleted")
+}
+
+func (w *WHIPInbound) lookup(id string) *whipSession {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.session == nil || w.session.id != id {
+		return nil
+	}
+	return w.session
+}
+
+// closeSession closes the peer connection of a session, the next publisher is accepted right away
+func (w *WHIPInbound) closeSession(session *whipSession) {
+	w.mux.Lock()
+	if w.session == session {
+		w.session = nil
+	}
+	w.mux.Unlock()
+	// the handler of the state change calls back with the closed state, Close does nothing the second time
+	go session.pc.Close()
+}
+
+// ReadPacket returns an error, the tracks must be read instead
+func (w *WHIPInbound) ReadPacket() (*server.Packet, error) {
+	return nil, errors.New("can not read directly from a WHIP inbound, change \"in\" to \"[inbound id]:video\" or \"[inbound id]:audio\" instead")
+}
+
+// WHIPInboundStatus describes the publisher of a WHIP inbound
+type WHIPInboundStatus struct {
+	Publisher *WHIPSessionStatus `json:"publisher,omitempty"`
+	// Dropped counts the packets dropped for each kind of track since the start
+	Dropped map[string]uint64 `json:"dropped"`
+}
+
+// WHIPSessionStatus describes the session of a publisher
+type WHIPSessionStatus struct {
+	ID     string    `json:"id"`
+	Addr   string    `json:"addr"`
+	Since  time.Time `json:"since"`
+	State  string    `json:"state"`
+	Tracks []string  `json:"tracks"`
+}
+
+// Status returns the connected publisher
+func (w *WHIPInbound) Status() interface{} {
+	res := WHIPInboundStatus{Dropped: make(map[string]uint64)}
+	for kind, t := range w.tracks {
+		res.Dropped[kind.String()] = atomic.LoadUint64(&t.dropped)
+	}
+	w.mux.Lock()
+	session := w.session
+	w.mux.Unlock()
+	if session != nil {
+		session.mux.Lock()
+		tracks := append([]string{}, session.kinds...)
+		session.mux.Unlock()
+		res.Publisher = &WHIPSessionStatus{
+			ID:     session.id,
+			Addr:   session.addr,
+			Since:  session.since,
+			State:  webrtc.PeerConnectionState(atomic.LoadInt64(&session.state)).String(),
+			Tracks: tracks,
+		}
+	}
+	return res
+}
+
+// RequestKeyframe asks the publisher for a keyframe of its video track with a PLI, track is "video" or empty
+func (w *WHIPInbound) RequestKeyframe(track string) error {
+	if track != "" && track != webrtc.RTPCodecTypeVideo.String() {
+		return errors.New("keyframes can only be requested for the video track")
+	}
+	w.mux.Lock()
+	session := w.session
+	w.mux.Unlock()
+	if session == nil {
+		return errors.New("no publisher")
+	}
+	session.mux.Lock()
+	ssrc, ok := session.ssrcs[webrtc.RTPCodecTypeVideo]
+	session.mux.Unlock()
+	if !ok {
+		return errors.New("the publisher sends no video")
+	}
+	return session.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}})
+}
+
+// Close stops the HTTP server and disconnects the publisher
+func (w *WHIPInbound) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+	})
+	w.mux.Lock()
+	session := w.session
+	w.session = nil
+	w.mux.Unlock()
+	if session != nil {
+		session.pc.Close()
+	}
+	if w.api != nil {
+		w.api.Close()
+	}
+	if w.unmount != nil {
+		w.unmount()
+	}
+	if w.srv == nil {
+		return nil
+	}
+	return w.srv.Close()
+}
+
+// push queues a packet, it is dropped if the buffer is full
+func (t *whipTrack) push(pkt *server.Packet) {
+	select {
+	case t.c <- pkt:
+	default:
+		pkt.Release()
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// ReadPacket blocks until an RTP packet is received, io.EOF is returned once the inbound is closed
+func (t *whipTrack) ReadPacket() (*server.Packet, error) {
+	select {
+	case pkt := <-t.c:
+		return pkt, nil
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
@@ -0,0 +1,107 @@
+package inbound
+
+import (
+	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/whip"
+	"github.com/pion/webrtc/v3"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWHIPMethods(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	in, err := RegisterWHIPInbound(server.New(), "whip-in", map[string]interface{}{
+		"sdpServer": map[string]interface{}{"listenAddress": addr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	url := "http://" + addr + defaultWHIPRootPath
+
+	do := func(method, url, contentType, body string) *http.Response {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		var res *http.Response
+		for i := 0; ; i++ {
+			if res, err = http.DefaultClient.Do(req); err == nil || i == 50 {
+				break
+			}
+			// the server may not be listening yet
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	expect := func(res *http.Response, status int, allow string) {
+		t.Helper()
+		if res.StatusCode != status || res.Header.Get("Allow") != allow {
+			t.Fatalf("%s %s: got %d with Allow %q, want %d with Allow %q", res.Request.Method, res.Request.URL,
+				res.StatusCode, res.Header.Get("Allow"), status, allow)
+		}
+	}
+
+	expect(do(http.MethodOptions, url, "", ""), http.StatusNoContent, "POST, OPTIONS")
+	expect(do(http.MethodOptions, url+"/session", "", ""), http.StatusNoContent, "DELETE, OPTIONS")
+	expect(do(http.MethodPatch, url+"/unknown", whip.ContentTypeTrickle, ""), http.StatusNotFound, "")
+
+	// a publisher offering the video and the audio
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	for _, kind := range whipKinds {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(pc.LocalDescription().SDP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", whip.ContentTypeSDP)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d for the offer, want %d", res.StatusCode, http.StatusCreated)
+	}
+	session := "http://" + addr + res.Header.Get("Location")
+
+	expect(do(http.MethodPatch, session, whip.ContentTypeTrickle, "a=end-of-candidates\r\n"), http.StatusMethodNotAllowed,
+		"DELETE, OPTIONS")
+	expect(do(http.MethodDelete, session, "", ""), http.StatusOK, "")
+}
@@ -1,86 +1,263 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/howyoungzhou/golive/api"
+	"github.com/howyoungzhou/golive/auth"
 	"github.com/howyoungzhou/golive/inbound"
+	"github.com/howyoungzhou/golive/metrics"
 	"github.com/howyoungzhou/golive/outbound"
 	"github.com/howyoungzhou/golive/process"
 	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/turn"
+	"github.com/howyoungzhou/golive/web"
+	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type Options struct {
-	Inbounds []struct {
-		Id      string `json:"id"`
-		Type    string `json:"type"`
-		Options map[string]interface{}
-	} `json:"inbounds"`
-	Outbounds []struct {
-		Id      string `json:"id"`
-		Type    string `json:"type"`
-		Options map[string]interface{}
-	} `json:"outbounds"`
-	Processes []struct {
-		Id      string `json:"id"`
-		Type    string `json:"type"`
-		Options map[string]interface{}
-	} `json:"processes"`
-	Pipes []struct {
-		In   string   `json:"in"`
-		Outs []string `json:"outs"`
-	} `json:"pipes"`
+	server.Config
+	API     *api.Options     `json:"api"`
+	Metrics *metrics.Options `json:"metrics"`
+	TURN    *turn.Options    `json:"turn"`
+	HTTP    *web.Options     `json:"http"`
 }
 
-func main() {
-	configPath := flag.String("config", "config.json", "path to the config file")
-	flag.Parse()
-	configData, err := ioutil.ReadFile(*configPath)
+func loadOptions(path string) (*Options, error) {
+	configData, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	options := Options{}
-	err = json.Unmarshal(configData, &options)
-	if err != nil {
-		panic(err)
+	options := &Options{}
+	if err := json.Unmarshal(configData, options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// watchConfig reloads the config on SIGHUP or when the modification time of the file changes, the file is not watched
+// if interval is 0
+func watchConfig(ctx context.Context, s *server.Server, path string, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	lastMod := time.Time{}
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.WithField("path", path).Info("SIGHUP received, reloading config")
+		case <-tick:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+			log.WithField("path", path).Info("config file changed, reloading config")
+		}
+		options, err := loadOptions(path)
+		if err != nil {
+			log.WithField("path", path).WithError(err).Error("failed to load config")
+			continue
+		}
+		if err := s.Reload(&options.Config); err != nil {
+			log.WithField("path", path).WithError(err).Error("config reloaded with errors")
+			continue
+		}
+		log.WithField("path", path).Info("config reloaded")
 	}
+}
 
+// newServer creates a server with all the component types registered
+func newServer() *server.Server {
 	s := server.New()
 	s.RegisterInbound("udp", inbound.RegisterUDPInbound)
 	s.RegisterInbound("tcp", inbound.RegisterTCPInbound)
 	s.RegisterInbound("srt", inbound.RegisterSRTInbound)
+	s.RegisterInbound("whip", inbound.RegisterWHIPInbound)
 	s.RegisterOutbound("webrtc", outbound.RegisterWebRTC)
 	s.RegisterOutbound("srt", outbound.RegisterSRTOutbound)
 	s.RegisterProcess("exec", process.RegisterExecProcess)
+	return s
+}
 
-	for _, i := range options.Inbounds {
-		err := s.AddInbound(i.Id, i.Type, i.Options)
-		if err != nil {
-			panic(err)
+// validate checks the config file without starting the server, the problems are printed to stderr
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "path to the config file")
+	flags.Parse(args)
+	options, err := loadOptions(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = newServer().Validate(&options.Config)
+	var verr *server.ValidationError
+	if errors.As(err, &verr) {
+		for _, e := range verr.Errors {
+			fmt.Fprintln(os.Stderr, e)
 		}
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
+	fmt.Println(*configPath + " is valid")
+	return 0
+}
 
-	for _, o := range options.Outbounds {
-		err := s.AddOutbound(o.Id, o.Type, o.Options)
-		if err != nil {
+// graph prints the pipe graph of the config file without starting the server
+func graph(args []string) int {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "path to the config file")
+	format := flags.String("format", "dot", "output format, dot or json")
+	flags.Parse(args)
+	options, err := loadOptions(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	s := newServer()
+	if err := s.Apply(&options.Config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	g := s.Graph()
+	switch *format {
+	case "dot":
+		fmt.Print(g.DOT())
+	case "json":
+		data, _ := json.MarshalIndent(g, "", "  ")
+		fmt.Println(string(data))
+	default:
+		fmt.Fprintln(os.Stderr, "unknown format: "+*format)
+		return 1
+	}
+	return 0
+}
+
+// token prints a token signed with the secret of an auth config
+func token(args []string) int {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	secret := flags.String("secret", "", "secret of the auth config")
+	stream := flags.String("stream", "", "name of the stream, empty for the component itself")
+	mode := flags.String("mode", string(auth.ModePublish), "publish or play")
+	ttl := flags.Duration("ttl", time.Hour, "validity of the token")
+	flags.Parse(args)
+	if *secret == "" {
+		fmt.Fprintln(os.Stderr, "missing secret")
+		return 1
+	}
+	switch auth.Mode(*mode) {
+	case auth.ModePublish, auth.ModePlay:
+	default:
+		fmt.Fprintln(os.Stderr, "unknown mode: "+*mode)
+		return 1
+	}
+	fmt.Println(auth.Sign(*secret, *stream, auth.Mode(*mode), time.Now().Add(*ttl)))
+	return 0
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validate(os.Args[2:]))
+		case "graph":
+			os.Exit(graph(os.Args[2:]))
+		case "token":
+			os.Exit(token(os.Args[2:]))
+		}
+	}
+	os.Exit(serve())
+}
+
+// serve runs the server until SIGINT or SIGTERM is received or it fails, the server is shut down either way
+func serve() int {
+	configPath := flag.String("config", "config.json", "path to the config file")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "time to wait for the pipes to drain on shutdown")
+	watchInterval := flag.Duration("watch-interval", time.Second, "interval of checking the config file for changes, 0 to reload on SIGHUP only")
+	flag.Parse()
+	options, err := loadOptions(*configPath)
+	if err != nil {
+		panic(err)
+	}
+
+	s := newServer()
+	// the HTTP and the TURN servers are shared by the components, they are started first
+	if options.HTTP != nil {
+		h := web.New(options.HTTP)
+		if err := h.Init(); err != nil {
 			panic(err)
 		}
+		defer h.Close()
+		s.AddService(web.ServiceName, h)
+	}
+	if options.TURN != nil {
+		t := turn.New(options.TURN)
+		if err := t.Init(); err != nil {
+			panic(err)
+		}
+		defer t.Close()
+		s.AddService(turn.ServiceName, t)
+	}
+	if err := s.Apply(&options.Config); err != nil {
+		panic(err)
 	}
 
-	for _, p := range options.Processes {
-		err := s.AddProcess(p.Id, p.Type, p.Options)
-		if err != nil {
+	if options.API != nil {
+		a := api.New(s, options.API)
+		if err := a.Init(); err != nil {
 			panic(err)
 		}
+		defer a.Close()
 	}
 
-	for _, pipe := range options.Pipes {
-		err := s.AddPipe(pipe.In, pipe.Outs)
-		if err != nil {
+	if options.Metrics != nil {
+		m := metrics.New(s, options.Metrics)
+		if err := m.Init(); err != nil {
 			panic(err)
 		}
+		defer m.Close()
 	}
-	s.Run()
-	for {
+
+	// run until SIGINT or SIGTERM is received
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go watchConfig(ctx, s, *configPath, *watchInterval)
+	code := 0
+	if err := s.Run(ctx); err != nil {
+		log.WithError(err).Error("server failed")
+		code = 1
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Error("failed to shut down the server")
+		code = 1
 	}
+	return code
 }
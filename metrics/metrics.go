@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/web"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultPath = "/metrics"
+
+// Options configures the metrics endpoint
+type Options struct {
+	ListenAddress string `json:"listenAddress"`
+	// Path is the path of the endpoint, "/metrics" by default
+	Path string `json:"path"`
+	// Shared mounts the endpoint on the shared HTTP server instead of listening on ListenAddress, its listeners may be
+	// public
+	Shared bool `json:"shared"`
+}
+
+// Metrics serves the counters of a server in the Prometheus text format
+type Metrics struct {
+	server  *server.Server
+	options *Options
+	srv     *http.Server
+	// unmount removes the endpoint from the shared HTTP server
+	unmount func()
+	logger  *log.Entry
+}
+
+// New creates a new instance of Metrics
+func New(server *server.Server, options *Options) *Metrics {
+	if options.Path == "" {
+		options.Path = defaultPath
+	}
+	return &Metrics{
+		server:  server,
+		options: options,
+		logger:  log.New().WithFields(log.Fields{"module": "Metrics"}),
+	}
+}
+
+// Init starts the HTTP server, or mounts the endpoint on the shared HTTP server
+func (m *Metrics) Init() error {
+	mux := http.NewServeMux()
+	mux.Handle(m.options.Path, m)
+	if m.options.Shared {
+		shared, err := web.Shared(m.server)
+		if err == nil {
+			m.unmount, err = shared.Mount(m.options.Path, mux)
+		}
+		if err != nil {
+			return err
+		}
+		m.logger.WithField("path", m.options.Path).Info("metrics endpoint is mounted")
+		return nil
+	}
+	ln, err := net.Listen("tcp", m.options.ListenAddress)
+	if err != nil {
+		return err
+	}
+	m.srv = &http.Server{Handler: mux}
+	m.logger.WithFields(log.Fields{"addr": ln.Addr(), "path": m.options.Path}).Info("metrics server is listening")
+	go func() {
+		err := m.srv.Serve(ln)
+		if err != http.ErrServerClosed {
+			m.logger.WithField("addr", m.options.ListenAddress).WithError(err).Error("metrics server ended with error")
+		}
+	}()
+	return nil
+}
+
+// Close stops the HTTP server or unmounts the endpoint
+func (m *Metrics) Close() error {
+	if m.unmount != nil {
+		m.unmount()
+	}
+	if m.srv == nil {
+		return nil
+	}
+	return m.srv.Close()
+}
+
+// ServeHTTP writes the current counters of the server
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	Write(bw, m.server.Stats(), time.Now())
+	bw.Flush()
+}
+
+// family is a metric with all of its samples
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+type sample struct {
+	labels []string // pairs of label name and value
+	value  float64
+}
+
+func (f *family) add(value float64, labels ...string) {
+	f.samples = append(f.samples, sample{labels, value})
+}
+
+// Write writes the stats in the Prometheus text format, now is used to compute the uptime
+func Write(w io.Writer, stats server.Stats, now time.Time) {
+	componentUp := &family{name: "golive_component_up", typ: "gauge",
+		help: "Whether the component is running."}
+	componentRestarts := &family{name: "golive_component_restarts_total", typ: "counter",
+		help: "Number of times the component is started again after the first start."}
+	componentUptime := &family{name: "golive_component_uptime_seconds", typ: "gauge",
+		help: "Time since the latest start of the running component."}
+	outboundClients := &family{name: "golive_outbound_clients", typ: "gauge",
+		help: "Number of clients connected to the outbound."}
+	outboundDropped := &family{name: "golive_outbound_dropped_packets_total", typ: "counter",
+		help: "Number of packets dropped by the outbound for slow clients."}
+	connRTT := &family{name: "golive_connection_rtt_seconds", typ: "gauge",
+		help: "Round trip time of the connection to the peer."}
+	connBandwidth := &family{name: "golive_connection_bandwidth_bits_per_second", typ: "gauge",
+		help: "Estimated bandwidth of the connection to the peer."}
+	connBytes := &family{name: "golive_connection_bytes_total", typ: "counter",
+		help: "Bytes sent to or received from the peer, including the retransmissions."}
+	connLost := &family{name: "golive_connection_lost_packets_total", typ: "counter",
+		help: "Packets lost on the way to or from the peer."}
+	connRetransmitted := &family{name: "golive_connection_retransmitted_packets_total", typ: "counter",
+		help: "Packets retransmitted to the peer."}
+	connDropped := &family{name: "golive_connection_dropped_packets_total", typ: "counter",
+		help: "Packets dropped for arriving or being sent too late."}
+	for _, c := range stats.Components {
+		labels := []string{"kind", string(c.Kind), "type", c.Type, "id", c.ID}
+		for _, conn := range c.Connections {
+			l := []string{"id", c.ID, "addr", conn.Addr, "stream", conn.Stream}
+			connRTT.add(conn.RTT/1000, l...)
+			connBandwidth.add(conn.Bandwidth*1e6, l...)
+			connBytes.add(float64(conn.BytesSent), append(l, "direction", "sent")...)
+			connBytes.add(float64(conn.BytesReceived), append(l, "direction", "received")...)
+			connLost.add(float64(conn.PacketsSendLost), append(l, "direction", "sent")...)
+			connLost.add(float64(conn.PacketsRecvLost), append(l, "direction", "received")...)
+			connRetransmitted.add(float64(conn.PacketsRetransmitted), l...)
+			connDropped.add(float64(conn.PacketsSendDropped), append(l, "direction", "sent")...)
+			connDropped.add(float64(conn.PacketsRecvDropped), append(l, "direction", "received")...)
+		}
+		up, uptime := 0.0, 0.0
+		if c.State == server.StateRunning {
+			up = 1
+			uptime = now.Sub(c.StartedAt).Seconds()
+		}
+		restarts := 0.0
+		if c.Starts > 1 {
+			restarts = float64(c.Starts - 1)
+		}
+		componentUp.add(up, labels...)
+		componentRestarts.add(restarts, labels...)
+		componentUptime.add(uptime, labels...)
+		if c.Clients != nil {
+			outboundClients.add(float64(*c.Clients), labels...)
+		}
+		if c.Dropped != nil {
+			outboundDropped.add(float64(*c.Dropped), labels...)
+		}
+	}
+
+	pipeUp := &family{name: "golive_pipe_up", typ: "gauge",
+		help: "Whether the pipe is running."}
+	readBytes := &family{name: "golive_pipe_read_bytes_total", typ: "counter",
+		help: "Bytes read from the input of the pipe."}
+	readPackets := &family{name: "golive_pipe_read_packets_total", typ: "counter",
+		help: "Packets read from the input of the pipe."}
+	readErrors := &family{name: "golive_pipe_read_errors_total", typ: "counter",
+		help: "Failed reads from the input of the pipe."}
+	writtenBytes := &family{name: "golive_pipe_written_bytes_total", typ: "counter",
+		help: "Bytes written to the output of the pipe."}
+	writtenPackets := &family{name: "golive_pipe_written_packets_total", typ: "counter",
+		help: "Packets written to the output of the pipe."}
+	droppedPackets := &family{name: "golive_pipe_dropped_packets_total", typ: "counter",
+		help: "Packets dropped for the output of the pipe."}
+	writeErrors := &family{name: "golive_pipe_write_errors_total", typ: "counter",
+		help: "Failed writes to the output of the pipe."}
+	channelDepth := &family{name: "golive_pipe_channel_depth", typ: "gauge",
+		help: "Packets waiting in the channel of the output of the pipe."}
+	channelCapacity := &family{name: "golive_pipe_channel_capacity", typ: "gauge",
+		help: "Capacity of the channel of the output of the pipe."}
+	for _, p := range stats.Pipes {
+		up := 0.0
+		if p.Running {
+			up = 1
+		}
+		pipeUp.add(up, "pipe", p.ID, "in", p.In)
+		readBytes.add(float64(p.BytesRead), "pipe", p.ID, "in", p.In)
+		readPackets.add(float64(p.PacketsRead), "pipe", p.ID, "in", p.In)
+		readErrors.add(float64(p.ReadErrors), "pipe", p.ID, "in", p.In)
+		for _, o := range p.Outputs {
+			writtenBytes.add(float64(o.BytesWritten), "pipe", p.ID, "out", o.ID)
+			writtenPackets.add(float64(o.PacketsWritten), "pipe", p.ID, "out", o.ID)
+			droppedPackets.add(float64(o.Dropped), "pipe", p.ID, "out", o.ID)
+			writeErrors.add(float64(o.WriteErrors), "pipe", p.ID, "out", o.ID)
+			channelDepth.add(float64(o.Depth), "pipe", p.ID, "out", o.ID)
+			channelCapacity.add(float64(o.Capacity), "pipe", p.ID, "out", o.ID)
+		}
+	}
+
+	for _, f := range []*family{
+		componentUp, componentRestarts, componentUptime, outboundClients, outboundDropped,
+		connRTT, connBandwidth, connBytes, connLost, connRetransmitted, connDropped,
+		pipeUp, readBytes, readPackets, readErrors,
+		writtenBytes, writtenPackets, droppedPackets, writeErrors, channelDepth, channelCapacity,
+	} {
+		f.write(w)
+	}
+}
+
+func (f *family) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+	for _, s := range f.samples {
+		var labels []string
+		for i := 0; i+1 < len(s.labels); i += 2 {
+			labels = append(labels, s.labels[i]+`="`+escape(s.labels[i+1])+`"`)
+		}
+		fmt.Fprintf(w, "%s{%s} %v\n", f.name, strings.Join(labels, ","), s.value)
+	}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(v string) string {
+	return escaper.Replace(v)
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/howyoungzhou/golive/server"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	clients, dropped := 2, uint64(7)
+	stats := server.Stats{
+		Components: []server.ComponentStats{
+			{
+				ID:        "srt",
+				Type:      "srt",
+				Kind:      server.KindInbound,
+				State:     server.StateRunning,
+				Starts:    3,
+				StartedAt: now.Add(-90 * time.Second),
+				Connections: []server.ConnectionStats{{
+					Addr:                 "192.0.2.1:50000",
+					Stream:               "live/\"cam\"\\1\n",
+					RTT:                  12.5,
+					Bandwidth:            2.5,
+					BytesSent:            1000,
+					BytesReceived:        2000,
+					PacketsSendLost:      1,
+					PacketsRecvLost:      2,
+					PacketsRetransmitted: 3,
+					PacketsSendDropped:   4,
+					PacketsRecvDropped:   5,
+				}},
+			},
+			{
+				ID:        "web",
+				Type:      "webrtc",
+				Kind:      server.KindOutbound,
+				State:     server.StateStopped,
+				Starts:    1,
+				StartedAt: now.Add(-time.Hour),
+				Clients:   &clients,
+				Dropped:   &dropped,
+			},
+		},
+		Pipes: []server.PipeStats{{
+			ID:          "srt",
+			In:          "srt",
+			Running:     true,
+			BytesRead:   1316,
+			PacketsRead: 7,
+			Outputs: []server.OutputStats{{
+				ID:             "web",
+				BytesWritten:   1128,
+				PacketsWritten: 6,
+				Dropped:        1,
+				Depth:          3,
+				Capacity:       64,
+			}},
+		}},
+	}
+	conn := `id="srt",addr="192.0.2.1:50000",stream="live/\"cam\"\\1\n"`
+	want := `# HELP golive_component_up Whether the component is running.
+# TYPE golive_component_up gauge
+golive_component_up{kind="inbound",type="srt",id="srt"} 1
+golive_component_up{kind="outbound",type="webrtc",id="web"} 0
+# HELP golive_component_restarts_total Number of times the component is started again after the first start.
+# TYPE golive_component_restarts_total counter
+golive_component_restarts_total{kind="inbound",type="srt",id="srt"} 2
+golive_component_restarts_total{kind="outbound",type="webrtc",id="web"} 0
+# HELP golive_component_uptime_seconds Time since the latest start of the running component.
+# TYPE golive_component_uptime_seconds gauge
+golive_component_uptime_seconds{kind="inbound",type="srt",id="srt"} 90
+golive_component_uptime_seconds{kind="outbound",type="webrtc",id="web"} 0
+# HELP golive_outbound_clients Number of clients connected to the outbound.
+# TYPE golive_outbound_clients gauge
+golive_outbound_clients{kind="outbound",type="webrtc",id="web"} 2
+# HELP golive_outbound_dropped_packets_total Number of packets dropped by the outbound for slow clients.
+# TYPE golive_outbound_dropped_packets_total counter
+golive_outbound_dropped_packets_total{kind="outbound",type="webrtc",id="web"} 7
+# HELP golive_connection_rtt_seconds Round trip time of the connection to the peer.
+# TYPE golive_connection_rtt_seconds gauge
+golive_connection_rtt_seconds{<conn>} 0.0125
+# HELP golive_connection_bandwidth_bits_per_second Estimated bandwidth of the connection to the peer.
+# TYPE golive_connection_bandwidth_bits_per_second gauge
+golive_connection_bandwidth_bits_per_second{<conn>} 2.5e+06
+# HELP golive_connection_bytes_total Bytes sent to or received from the peer, including the retransmissions.
+# TYPE golive_connection_bytes_total counter
+golive_connection_bytes_total{<conn>,direction="sent"} 1000
+golive_connection_bytes_total{<conn>,direction="received"} 2000
+# HELP golive_connection_lost_packets_total Packets lost on the way to or from the peer.
+# TYPE golive_connection_lost_packets_total counter
+golive_connection_lost_packets_total{<conn>,direction="sent"} 1
+golive_connection_lost_packets_total{<conn>,direction="received"} 2
+# HELP golive_connection_retransmitted_packets_total Packets retransmitted to the peer.
+# TYPE golive_connection_retransmitted_packets_total counter
+golive_connection_retransmitted_packets_total{<conn>} 3
+# HELP golive_connection_dropped_packets_total Packets dropped for arriving or being sent too late.
+# TYPE golive_connection_dropped_packets_total counter
+golive_connection_dropped_packets_total{<conn>,direction="sent"} 4
+golive_connection_dropped_packets_total{<conn>,direction="received"} 5
+# HELP golive_pipe_up Whether the pipe is running.
+# TYPE golive_pipe_up gauge
+golive_pipe_up{pipe="srt",in="srt"} 1
+# HELP golive_pipe_read_bytes_total Bytes read from the input of the pipe.
+# TYPE golive_pipe_read_bytes_total counter
+golive_pipe_read_bytes_total{pipe="srt",in="srt"} 1316
+# HELP golive_pipe_read_packets_total Packets read from the input of the pipe.
+# TYPE golive_pipe_read_packets_total counter
+golive_pipe_read_packets_total{pipe="srt",in="srt"} 7
+# HELP golive_pipe_read_errors_total Failed reads from the input of the pipe.
+# TYPE golive_pipe_read_errors_total counter
+golive_pipe_read_errors_total{pipe="srt",in="srt"} 0
+# HELP golive_pipe_written_bytes_total Bytes written to the output of the pipe.
+# TYPE golive_pipe_written_bytes_total counter
+golive_pipe_written_bytes_total{pipe="srt",out="web"} 1128
+# HELP golive_pipe_written_packets_total Packets written to the output of the pipe.
+# TYPE golive_pipe_written_packets_total counter
+golive_pipe_written_packets_total{pipe="srt",out="web"} 6
+# HELP golive_pipe_dropped_packets_total Packets dropped for the output of the pipe.
+# TYPE golive_pipe_dropped_packets_total counter
+golive_pipe_dropped_packets_total{pipe="srt",out="web"} 1
+# HELP golive_pipe_write_errors_total Failed writes to the output of the pipe.
+# TYPE golive_pipe_write_errors_total counter
+golive_pipe_write_errors_total{pipe="srt",out="web"} 0
+# HELP golive_pipe_channel_depth Packets waiting in the channel of the output of the pipe.
+# TYPE golive_pipe_channel_depth gauge
+golive_pipe_channel_depth{pipe="srt",out="web"} 3
+# HELP golive_pipe_channel_capacity Capacity of the channel of the output of the pipe.
+# TYPE golive_pipe_channel_capacity gauge
+golive_pipe_channel_capacity{pipe="srt",out="web"} 64
+`
+	want = strings.ReplaceAll(want, "<conn>", conn)
+
+	var b bytes.Buffer
+	Write(&b, stats, now)
+	if b.String() != want {
+		got, wanted := strings.Split(b.String(), "\n"), strings.Split(want, "\n")
+		for i := 0; i < len(got) && i < len(wanted); i++ {
+			if got[i] != wanted[i] {
+				t.Fatalf("line %d: got %s, want %s", i+1, got[i], wanted[i])
+			}
+		}
+		t.Fatalf("got %d lines, want %d", len(got), len(wanted))
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "live/cam1", want: "live/cam1"},
+		{value: `C:\live`, want: `C:\\live`},
+		{value: `"cam"`, want: `\"cam\"`},
+		{value: "a\nb", want: `a\nb`},
+		{value: "\\\"\n", want: `\\\"\n`},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.value); got != tt.want {
+			t.Errorf("escape(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	m := New(server.New(), &Options{})
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultPath, nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("got the content type %q", ct)
+	}
+	if !strings.HasPrefix(w.Body.String(), "# HELP golive_component_up ") {
+		t.Fatalf("got %q", w.Body)
+	}
+}
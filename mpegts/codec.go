@@ -0,0 +1,122 @@
+package mpegts
+
+import (
+	"time"
+)
+
+const (
+	nalTypeIDR = 5
+	nalTypeSPS = 7
+)
+
+// IsKeyframe reports whether an H.264 access unit in Annex B format holds an IDR picture
+func IsKeyframe(au []byte) bool {
+	found := false
+	ForEachNAL(au, func(nal []byte) bool {
+		if nal[0]&0x1f == nalTypeIDR {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// ForEachNAL calls f for each NAL unit of an H.264 byte stream in Annex B format until f returns false
+func ForEachNAL(b []byte, f func(nal []byte) bool) {
+	start := -1
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			end := i
+			// a four bytes start code
+			if end > start && b[end-1] == 0 {
+				end--
+			}
+			if end > start && !f(b[start:end]) {
+				return
+			}
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(b) {
+		f(b[start:])
+	}
+}
+
+// splitOpus splits the payload of an Opus PES packet into Opus packets, each one is prefixed by a control header as
+// defined by ETSI TS 102 366 Annex
+func splitOpus(b []byte) [][]byte {
+	var res [][]byte
+	for len(b) >= 2 {
+		if b[0] != 0x7f || b[1]&0xe0 != 0xe0 {
+			break
+		}
+		startTrim := b[1]&0x10 != 0
+		endTrim := b[1]&0x08 != 0
+		extension := b[1]&0x04 != 0
+		i := 2
+		size := 0
+		for i < len(b) {
+			size += int(b[i])
+			i++
+			if b[i-1] != 0xff {
+				break
+			}
+		}
+		if startTrim {
+			i += 2
+		}
+		if endTrim {
+			i += 2
+		}
+		if extension {
+			if i >= len(b) {
+				break
+			}
+			i += 1 + int(b[i])
+		}
+		if size == 0 || i+size > len(b) {
+			break
+		}
+		res = append(res, b[i:i+size])
+		b = b[i+size:]
+	}
+	return res
+}
+
+// opusFrameDurations are the durations of the frames of each configuration in microseconds, by the TOC byte
+var opusFrameDurations = [32]time.Duration{
+	// SILK
+	10000, 20000, 40000, 60000,
+	10000, 20000, 40000, 60000,
+	10000, 20000, 40000, 60000,
+	// Hybrid
+	10000, 20000, 10000, 20000,
+	// CELT
+	2500, 5000, 10000, 20000,
+	2500, 5000, 10000, 20000,
+	2500, 5000, 10000, 20000,
+	2500, 5000, 10000, 20000,
+}
+
+// OpusDuration returns the duration of an Opus packet from its TOC byte, 0 if the packet is malformed
+func OpusDuration(p []byte) time.Duration {
+	if len(p) == 0 {
+		return 0
+	}
+	frames := 1
+	switch p[0] & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(p) < 2 {
+			return 0
+		}
+		frames = int(p[1] & 0x3f)
+	}
+	return opusFrameDurations[p[0]>>3] * time.Duration(frames) * time.Microsecond
+}
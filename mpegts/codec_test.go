@@ -0,0 +1,125 @@
+package mpegts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestForEachNAL(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want [][]byte
+	}{
+		{name: "three bytes start codes", b: []byte{0, 0, 1, 9, 0xf0, 0, 0, 1, 0x65, 1, 2}, want: [][]byte{{9, 0xf0}, {0x65, 1, 2}}},
+		{name: "four bytes start codes", b: []byte{0, 0, 0, 1, 0x67, 1, 0, 0, 0, 1, 0x68, 2}, want: [][]byte{{0x67, 1}, {0x68, 2}}},
+		{name: "mixed start codes", b: []byte{0, 0, 0, 1, 0x67, 0, 0, 1, 0x65, 3}, want: [][]byte{{0x67}, {0x65, 3}}},
+		{name: "empty NAL units", b: []byte{0, 0, 1, 0, 0, 1, 0x41, 1}, want: [][]byte{{0x41, 1}}},
+		{name: "no start code", b: []byte{0x65, 1, 2}},
+		{name: "start code at the end", b: []byte{0, 0, 1, 0x41, 0, 0, 1}, want: [][]byte{{0x41}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]byte
+			ForEachNAL(tt.b, func(nal []byte) bool {
+				got = append(got, nal)
+				return true
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	n := 0
+	ForEachNAL([]byte{0, 0, 1, 0x67, 0, 0, 1, 0x65}, func([]byte) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("got %d calls after f returned false, want 1", n)
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	tests := []struct {
+		name string
+		au   []byte
+		want bool
+	}{
+		{name: "IDR", au: accessUnit(nalTypeIDR, 10), want: true},
+		{name: "IDR after the parameter sets", au: []byte{0, 0, 0, 1, 0x67, 1, 0, 0, 0, 1, 0x68, 2, 0, 0, 1, 0x65, 3}, want: true},
+		{name: "sequence parameter set only", au: accessUnit(nalTypeSPS, 10)},
+		{name: "slice", au: accessUnit(1, 10)},
+		{name: "empty", au: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsKeyframe(tt.au); got != tt.want {
+				t.Fatalf("IsKeyframe = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitOpus(t *testing.T) {
+	long := make([]byte, 300)
+	long[0] = 0xf8
+	tests := []struct {
+		name string
+		b    []byte
+		want [][]byte
+	}{
+		{name: "single packet", b: []byte{0x7f, 0xe0, 2, 0xf8, 1}, want: [][]byte{{0xf8, 1}}},
+		{name: "two packets", b: []byte{0x7f, 0xe0, 1, 0xf8, 0x7f, 0xe0, 2, 0xf9, 2}, want: [][]byte{{0xf8}, {0xf9, 2}}},
+		{name: "size over 255 bytes", b: append([]byte{0x7f, 0xe0, 0xff, 45}, long...), want: [][]byte{long}},
+		{name: "trims", b: []byte{0x7f, 0xf8, 1, 0, 10, 0, 20, 0xf8}, want: [][]byte{{0xf8}}},
+		{name: "extension", b: []byte{0x7f, 0xe4, 1, 2, 9, 9, 0xf8}, want: [][]byte{{0xf8}}},
+		{name: "truncated", b: []byte{0x7f, 0xe0, 1, 0xf8, 0x7f, 0xe0, 3, 0xf8}, want: [][]byte{{0xf8}}},
+		{name: "bad control header", b: []byte{0x7e, 0xe0, 1, 0xf8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitOpus(tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpusDuration(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		p    []byte
+		want time.Duration
+	}{
+		{name: "SILK 10 ms", p: []byte{0 << 3}, want: 10 * ms},
+		{name: "SILK 60 ms", p: []byte{11 << 3}, want: 60 * ms},
+		{name: "hybrid 20 ms", p: []byte{15 << 3}, want: 20 * ms},
+		{name: "CELT 2.5 ms", p: []byte{16 << 3}, want: 2500 * time.Microsecond},
+		{name: "CELT 20 ms", p: []byte{31 << 3}, want: 20 * ms},
+		{name: "two equal frames", p: []byte{31<<3 | 1}, want: 40 * ms},
+		{name: "two frames", p: []byte{31<<3 | 2}, want: 40 * ms},
+		{name: "arbitrary frames", p: []byte{31<<3 | 3, 0x83}, want: 60 * ms},
+		{name: "arbitrary frames truncated", p: []byte{31<<3 | 3}},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OpusDuration(tt.p); got != tt.want {
+				t.Fatalf("OpusDuration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTicks(t *testing.T) {
+	for _, tt := range []int64{0, 1, 3000, 3003, 90000, 1 << 33} {
+		// converting back to the 90 kHz clock and truncating gives the ticks again
+		if got := int64(ticks(tt) * 90000 / time.Second); got != tt {
+			t.Errorf("ticks(%d) converts back to %d", tt, got)
+		}
+	}
+}
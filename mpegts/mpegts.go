@@ -0,0 +1,363 @@
+// Package mpegts demuxes the H.264 and the Opus elementary streams of an MPEG-TS stream
+package mpegts
+
+import (
+	"bytes"
+	"time"
+)
+
+// PacketSize is the size of an MPEG-TS packet
+const PacketSize = 188
+
+const (
+	syncByte = 0x47
+	patPID   = 0
+
+	streamTypePrivate = 0x06
+	streamTypeH264    = 0x1b
+
+	descriptorRegistration = 0x05
+
+	// ptsMask wraps the 33-bit timestamps
+	ptsMask = 1<<33 - 1
+)
+
+// Codec is the codec of an elementary stream
+type Codec string
+
+const (
+	CodecH264 Codec = "h264"
+	CodecOpus Codec = "opus"
+)
+
+// Frame is an H.264 access unit in Annex B format or an Opus packet
+type Frame struct {
+	PID   uint16
+	Codec Codec
+	Data  []byte
+	// PTS is the presentation timestamp, 0 if the PES packet has none
+	PTS time.Duration
+	// Duration is the time until the next frame of the stream. If the next frame is unknown once the frame is complete,
+	// it is the time since the previous frame, and 0 if that is unknown too.
+	Duration time.Duration
+	// Keyframe is set if the access unit holds an IDR picture
+	Keyframe bool
+}
+
+// stream is the state of an elementary stream
+type stream struct {
+	codec Codec
+	// cc is the continuity counter of the last packet, -1 before the first one
+	cc int
+	// pes is the payload of the PES packet being assembled, nil when waiting for the start of the next one
+	pes []byte
+	// length is the expected size of the payload, 0 if the PES packet is unbounded
+	length int
+	// pts and dts are in 90 kHz units, dts is the presentation timestamp if the PES packet has no decoding timestamp
+	pts    int64
+	dts    int64
+	hasPTS bool
+	// delta is the latest difference between the decoding timestamps of two frames
+	delta int64
+}
+
+// Demuxer extracts the frames of the H.264 and the Opus streams of the first program, the other streams are ignored.
+// The PSI sections are expected to fit in a single packet.
+type Demuxer struct {
+	onFrame func(*Frame)
+	// onStream is called when a stream is found in the program map
+	onStream func(pid uint16, streamType byte, codec Codec)
+	// buf keeps the start of a packet split across two writes
+	buf     []byte
+	pmtPID  int
+	streams map[uint16]*stream
+	// types are the stream types of the program map by PID, each stream is reported once
+	types map[uint16]byte
+}
+
+// NewDemuxer creates a demuxer calling onFrame for each frame, the frame is owned by the callee
+func NewDemuxer(onFrame func(*Frame)) *Demuxer {
+	return &Demuxer{
+		onFrame: onFrame,
+		pmtPID:  -1,
+		streams: make(map[uint16]*stream),
+		types:   make(map[uint16]byte),
+	}
+}
+
+// OnStream sets a callback called for each stream of the program map, codec is empty if it is not supported
+func (d *Demuxer) OnStream(f func(pid uint16, streamType byte, codec Codec)) {
+	d.onStream = f
+}
+
+// Write demuxes a chunk of the stream, the packets may be split across the chunks and the demuxer resynchronizes on
+// the sync byte after garbage
+func (d *Demuxer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(d.buf) > 0 {
+		need := PacketSize - len(d.buf)
+		if len(p) < need {
+			d.buf = append(d.buf, p...)
+			return n, nil
+		}
+		d.buf = append(d.buf, p[:need]...)
+		p = p[need:]
+		d.packet(d.buf)
+		d.buf = d.buf[:0]
+	}
+	for len(p) > 0 {
+		if p[0] != syncByte {
+			i := bytes.IndexByte(p, syncByte)
+			if i < 0 {
+				break
+			}
+			p = p[i:]
+		}
+		if len(p) < PacketSize {
+			d.buf = append(d.buf, p...)
+			break
+		}
+		d.packet(p[:PacketSize])
+		p = p[PacketSize:]
+	}
+	return n, nil
+}
+
+// packet handles a single packet
+func (d *Demuxer) packet(p []byte) {
+	if p[1]&0x80 != 0 {
+		// transport error indicator
+		return
+	}
+	start := p[1]&0x40 != 0
+	pid := uint16(p[1]&0x1f)<<8 | uint16(p[2])
+	control := p[3] >> 4 & 3
+	cc := int(p[3] & 0x0f)
+	if control&1 == 0 {
+		// no payload
+		return
+	}
+	payload := p[4:]
+	if control&2 != 0 {
+		if int(payload[0]) >= len(payload) {
+			return
+		}
+		payload = payload[1+int(payload[0]):]
+	}
+
+	switch {
+	case pid == patPID:
+		d.parsePAT(start, payload)
+	case int(pid) == d.pmtPID:
+		d.parsePMT(start, payload)
+	default:
+		if s, ok := d.streams[pid]; ok {
+			d.parsePES(pid, s, start, cc, payload)
+		}
+	}
+}
+
+// section returns the PSI section of a payload, without its header up to the section length and without its CRC
+func section(start bool, payload []byte, tableID byte) []byte {
+	if !start || len(payload) == 0 {
+		return nil
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil
+	}
+	payload = payload[1+pointer:]
+	if payload[0] != tableID {
+		return nil
+	}
+	length := int(payload[1]&0x0f)<<8 | int(payload[2])
+	if length < 9 || 3+length > len(payload) {
+		return nil
+	}
+	return payload[3 : 3+length-4]
+}
+
+func (d *Demuxer) parsePAT(start bool, payload []byte) {
+	s := section(start, payload, 0x00)
+	if s == nil {
+		return
+	}
+	for i := 5; i+4 <= len(s); i += 4 {
+		program := uint16(s[i])<<8 | uint16(s[i+1])
+		if program == 0 {
+			// network information table
+			continue
+		}
+		pid := int(s[i+2]&0x1f)<<8 | int(s[i+3])
+		if pid != d.pmtPID {
+			d.pmtPID = pid
+			d.streams = make(map[uint16]*stream)
+			d.types = make(map[uint16]byte)
+		}
+		return
+	}
+}
+
+func (d *Demuxer) parsePMT(start bool, payload []byte) {
+	s := section(start, payload, 0x02)
+	if s == nil || len(s) < 9 {
+		return
+	}
+	infoLength := int(s[7]&0x0f)<<8 | int(s[8])
+	streams := make(map[uint16]*stream)
+	types := make(map[uint16]byte)
+	for i := 9 + infoLength; i+5 <= len(s); {
+		streamType := s[i]
+		pid := uint16(s[i+1]&0x1f)<<8 | uint16(s[i+2])
+		esLength := int(s[i+3]&0x0f)<<8 | int(s[i+4])
+		end := i + 5 + esLength
+		if end > len(s) {
+			break
+		}
+		codec := codecOf(streamType, s[i+5:end])
+		if codec != "" {
+			if old, ok := d.streams[pid]; ok && old.codec == codec {
+				streams[pid] = old
+			} else {
+				streams[pid] = &stream{codec: codec, cc: -1}
+			}
+		}
+		if t, ok := d.types[pid]; (!ok || t != streamType) && d.onStream != nil {
+			d.onStream(pid, streamType, codec)
+		}
+		types[pid] = streamType
+		i = end
+	}
+	d.streams = streams
+	d.types = types
+}
+
+// codecOf returns the codec of a stream from its type and its descriptors, empty if it is not supported
+func codecOf(streamType byte, descriptors []byte) Codec {
+	switch streamType {
+	case streamTypeH264:
+		return CodecH264
+	case streamTypePrivate:
+		for i := 0; i+2 <= len(descriptors); {
+			tag, length := descriptors[i], int(descriptors[i+1])
+			if i+2+length > len(descriptors) {
+				break
+			}
+			if tag == descriptorRegistration && string(descriptors[i+2:i+2+length]) == "Opus" {
+				return CodecOpus
+			}
+			i += 2 + length
+		}
+	}
+	return ""
+}
+
+func (d *Demuxer) parsePES(pid uint16, s *stream, start bool, cc int, payload []byte) {
+	if s.cc >= 0 {
+		if cc == s.cc {
+			// duplicate packet
+			return
+		}
+		if cc != (s.cc+1)&0x0f {
+			// a packet is lost, the PES packet being assembled is dropped
+			s.pes = nil
+		}
+	}
+	s.cc = cc
+
+	if !start {
+		if s.pes != nil {
+			s.pes = append(s.pes, payload...)
+			if s.length > 0 && len(s.pes) >= s.length {
+				d.flush(pid, s)
+			}
+		}
+		return
+	}
+
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		s.pes = nil
+		return
+	}
+	pesLength := int(payload[4])<<8 | int(payload[5])
+	flags := payload[7]
+	headerLength := int(payload[8])
+	if 9+headerLength > len(payload) {
+		s.pes = nil
+		return
+	}
+	var pts int64
+	hasPTS := false
+	if flags&0x80 != 0 && headerLength >= 5 {
+		pts = parseTimestamp(payload[9:])
+		hasPTS = true
+	}
+	dts := pts
+	if flags&0xc0 == 0xc0 && headerLength >= 10 {
+		dts = parseTimestamp(payload[14:])
+	}
+
+	if s.hasPTS && hasPTS {
+		// the timestamps wrap around, a negative difference is a discontinuity
+		if delta := (dts - s.dts) & ptsMask; delta < 1<<32 {
+			s.delta = delta
+		}
+	}
+	// an unbounded PES packet ends with the start of the next one, which tells its duration
+	if s.pes != nil {
+		d.flush(pid, s)
+	}
+
+	s.pes = append([]byte(nil), payload[9+headerLength:]...)
+	s.length = 0
+	if pesLength > 0 {
+		s.length = pesLength - 3 - headerLength
+	}
+	s.pts = pts
+	s.dts = dts
+	s.hasPTS = hasPTS
+	if s.length > 0 && len(s.pes) >= s.length {
+		d.flush(pid, s)
+	}
+}
+
+// flush emits the frames of the PES packet being assembled
+func (d *Demuxer) flush(pid uint16, s *stream) {
+	data := s.pes
+	s.pes = nil
+	if s.length > 0 && len(data) > s.length {
+		data = data[:s.length]
+	}
+	if len(data) == 0 {
+		return
+	}
+	switch s.codec {
+	case CodecH264:
+		d.onFrame(&Frame{
+			PID:      pid,
+			Codec:    CodecH264,
+			Data:     data,
+			PTS:      ticks(s.pts),
+			Duration: ticks(s.delta),
+			Keyframe: IsKeyframe(data),
+		})
+	case CodecOpus:
+		pts := ticks(s.pts)
+		for _, p := range splitOpus(data) {
+			f := &Frame{PID: pid, Codec: CodecOpus, Data: p, PTS: pts, Duration: OpusDuration(p)}
+			pts += f.Duration
+			d.onFrame(f)
+		}
+	}
+}
+
+// parseTimestamp parses a 33-bit timestamp of a PES header
+func parseTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// ticks converts a duration in 90 kHz units, it is rounded up so that converting it back to a clock rate and
+// truncating gives the ticks again
+func ticks(t int64) time.Duration {
+	return (time.Duration(t)*time.Second + 89999) / 90000
+}
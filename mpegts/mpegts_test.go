@@ -0,0 +1,300 @@
+package mpegts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tsPacket builds a packet, an adaptation field stuffs the packets whose payload is shorter than 184 bytes. rai sets
+// the random access indicator, and a nil payload builds a packet carrying only an adaptation field.
+func tsPacket(pid uint16, start bool, cc int, rai bool, payload []byte) []byte {
+	p := []byte{syncByte, byte(pid >> 8 & 0x1f), byte(pid), byte(cc & 0x0f)}
+	if start {
+		p[1] |= 0x40
+	}
+	if payload != nil {
+		p[3] |= 0x10
+	}
+	if rai || len(payload) < PacketSize-4 {
+		p[3] |= 0x20
+		length := PacketSize - 4 - 1 - len(payload)
+		p = append(p, byte(length))
+		if length > 0 {
+			flags := byte(0)
+			if rai {
+				flags = randomAccess
+			}
+			p = append(p, flags)
+			p = append(p, bytes.Repeat([]byte{0xff}, length-1)...)
+		}
+	}
+	return append(p, payload...)
+}
+
+// psi builds the payload of a packet starting a PSI section, the CRC is left empty since it is not checked
+func psi(tableID byte, body []byte) []byte {
+	length := 5 + len(body) + 4
+	s := []byte{0, tableID, 0xb0 | byte(length>>8), byte(length), 0, 1, 0xc1, 0, 0}
+	s = append(s, body...)
+	return append(s, 0, 0, 0, 0)
+}
+
+// pat builds a packet of a program association table listing a single program
+func pat(pmtPID uint16) []byte {
+	return tsPacket(patPID, true, 0, false, psi(0x00, []byte{0, 1, 0xe0 | byte(pmtPID>>8), byte(pmtPID)}))
+}
+
+// esInfo is a stream of a program map
+type esInfo struct {
+	streamType  byte
+	pid         uint16
+	descriptors []byte
+}
+
+// opusDescriptor is the registration descriptor of an Opus stream
+var opusDescriptor = []byte{descriptorRegistration, 4, 'O', 'p', 'u', 's'}
+
+// pmt builds a packet of a program map table
+func pmt(pmtPID uint16, streams ...esInfo) []byte {
+	body := []byte{0xe1, 0x00, 0xf0, 0}
+	for _, es := range streams {
+		body = append(body, es.streamType, 0xe0|byte(es.pid>>8), byte(es.pid),
+			0xf0|byte(len(es.descriptors)>>8), byte(len(es.descriptors)))
+		body = append(body, es.descriptors...)
+	}
+	return tsPacket(pmtPID, true, 0, false, psi(0x02, body))
+}
+
+// pes builds a PES packet with a presentation timestamp, its length is set if bounded
+func pes(streamID byte, pts int64, data []byte, bounded bool) []byte {
+	p := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5,
+		0x21 | byte(pts>>29&0x0e), byte(pts >> 22), byte(pts>>14) | 1, byte(pts >> 7), byte(pts<<1) | 1}
+	if bounded {
+		length := 3 + 5 + len(data)
+		p[4], p[5] = byte(length>>8), byte(length)
+	}
+	return append(p, data...)
+}
+
+// packetize splits a PES packet into packets, cc is the continuity counter of the previous packet of the PID
+func packetize(pid uint16, cc *int, pes []byte) [][]byte {
+	var res [][]byte
+	for start := true; start || len(pes) > 0; start = false {
+		n := len(pes)
+		if n > PacketSize-4 {
+			n = PacketSize - 4
+		}
+		*cc = (*cc + 1) & 0x0f
+		res = append(res, tsPacket(pid, start, *cc, false, pes[:n]))
+		pes = pes[n:]
+	}
+	return res
+}
+
+// accessUnit returns an H.264 access unit of a NAL type with a payload of n bytes
+func accessUnit(nalType byte, n int) []byte {
+	au := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 1, 0x60 | nalType}
+	for i := 0; i < n; i++ {
+		// no start code can appear in the payload
+		au = append(au, byte(i%200+1))
+	}
+	return au
+}
+
+func TestDemuxer(t *testing.T) {
+	const pmtPID, videoPID, audioPID = 0x1000, 0x100, 0x101
+	h264 := esInfo{streamType: streamTypeH264, pid: videoPID}
+	idr, slice := accessUnit(nalTypeIDR, 500), accessUnit(1, 100)
+	opus := []byte{0x7f, 0xe0, 4, 0xf8, 1, 2, 3, 0x7f, 0xe0, 3, 0xf8, 4, 5}
+	concat := func(packets ...[][]byte) [][]byte {
+		var res [][]byte
+		for _, p := range packets {
+			res = append(res, p...)
+		}
+		return res
+	}
+	one := func(p []byte) [][]byte { return [][]byte{p} }
+	video := func(cc *int, pts int64, au []byte, bounded bool) [][]byte {
+		return packetize(videoPID, cc, pes(0xe0, pts, au, bounded))
+	}
+
+	tests := []struct {
+		name    string
+		packets func() [][]byte
+		want    []Frame
+		// streams lists the streams reported by the program maps
+		streams []esInfo
+	}{
+		{
+			name: "PES spanning several packets",
+			packets: func() [][]byte {
+				cc := -1
+				return concat(one(pat(pmtPID)), one(pmt(pmtPID, h264)), video(&cc, 90000, idr, true))
+			},
+			want:    []Frame{{PID: videoPID, Codec: CodecH264, Data: idr, PTS: time.Second, Keyframe: true}},
+			streams: []esInfo{h264},
+		},
+		{
+			name: "unbounded PES ended by the next one",
+			packets: func() [][]byte {
+				cc := -1
+				return concat(one(pat(pmtPID)), one(pmt(pmtPID, h264)),
+					video(&cc, 90000, idr, false), video(&cc, 93600, slice, false), video(&cc, 97200, slice, false))
+			},
+			want: []Frame{
+				{PID: videoPID, Codec: CodecH264, Data: idr, PTS: time.Second, Duration: 40 * time.Millisecond, Keyframe: true},
+				{PID: videoPID, Codec: CodecH264, Data: slice, PTS: time.Second + 40*time.Millisecond, Duration: 40 * time.Millisecond},
+			},
+			streams: []esInfo{h264},
+		},
+		{
+			name: "adaptation field only",
+			packets: func() [][]byte {
+				cc := -1
+				packets := video(&cc, 90000, idr, true)
+				// the continuity counter is not incremented by a packet without payload
+				af := tsPacket(videoPID, false, 0, true, nil)
+				return concat(one(pat(pmtPID)), one(pmt(pmtPID, h264)), packets[:1], one(af), packets[1:])
+			},
+			want:    []Frame{{PID: videoPID, Codec: CodecH264, Data: idr, PTS: time.Second, Keyframe: true}},
+			streams: []esInfo{h264},
+		},
+		{
+			name: "continuity gap",
+			packets: func() [][]byte {
+				cc := -1
+				lost := video(&cc, 90000, idr, true)
+				return concat(one(pat(pmtPID)), one(pmt(pmtPID, h264)),
+					lost[:1], lost[2:], video(&cc, 93600, slice, true))
+			},
+			want: []Frame{
+				{PID: videoPID, Codec: CodecH264, Data: slice, PTS: time.Second + 40*time.Millisecond, Duration: 40 * time.Millisecond},
+			},
+			streams: []esInfo{h264},
+		},
+		{
+			name: "duplicate packet",
+			packets: func() [][]byte {
+				cc := -1
+				packets := video(&cc, 90000, idr, true)
+				return concat(one(pat(pmtPID)), one(pmt(pmtPID, h264)), packets[:2], packets[1:])
+			},
+			want:    []Frame{{PID: videoPID, Codec: CodecH264, Data: idr, PTS: time.Second, Keyframe: true}},
+			streams: []esInfo{h264},
+		},
+		{
+			name: "before the program map",
+			packets: func() [][]byte {
+				cc := -1
+				return concat(video(&cc, 90000, idr, true), one(pat(pmtPID)), one(pmt(pmtPID, h264)),
+					video(&cc, 93600, slice, true))
+			},
+			want:    []Frame{{PID: videoPID, Codec: CodecH264, Data: slice, PTS: time.Second + 40*time.Millisecond}},
+			streams: []esInfo{h264},
+		},
+		{
+			name: "opus",
+			packets: func() [][]byte {
+				cc := -1
+				return concat(one(pat(pmtPID)),
+					one(pmt(pmtPID, esInfo{streamType: streamTypePrivate, pid: audioPID, descriptors: opusDescriptor})),
+					packetize(audioPID, &cc, pes(0xbd, 90000, opus, true)))
+			},
+			want: []Frame{
+				{PID: audioPID, Codec: CodecOpus, Data: []byte{0xf8, 1, 2, 3}, PTS: time.Second, Duration: 20 * time.Millisecond},
+				{PID: audioPID, Codec: CodecOpus, Data: []byte{0xf8, 4, 5}, PTS: time.Second + 20*time.Millisecond, Duration: 20 * time.Millisecond},
+			},
+			streams: []esInfo{{streamType: streamTypePrivate, pid: audioPID}},
+		},
+		{
+			name: "program map extended",
+			packets: func() [][]byte {
+				cc := -1
+				packets := video(&cc, 90000, idr, true)
+				opusES := esInfo{streamType: streamTypePrivate, pid: audioPID, descriptors: opusDescriptor}
+				// the video stream keeps the PES packet being assembled
+				return concat(one(pat(pmtPID)), one(pmt(pmtPID, h264)), packets[:1],
+					one(pmt(pmtPID, h264, opusES)), packets[1:])
+			},
+			want:    []Frame{{PID: videoPID, Codec: CodecH264, Data: idr, PTS: time.Second, Keyframe: true}},
+			streams: []esInfo{h264, {streamType: streamTypePrivate, pid: audioPID}},
+		},
+		{
+			name: "program map changed",
+			packets: func() [][]byte {
+				videoCC, audioCC, newCC := -1, -1, -1
+				opusES := esInfo{streamType: streamTypePrivate, pid: audioPID, descriptors: opusDescriptor}
+				moved := esInfo{streamType: streamTypeH264, pid: 0x102}
+				return concat(one(pat(pmtPID)), one(pmt(pmtPID, h264)),
+					video(&videoCC, 90000, idr, true),
+					// the video moves to another PID along with an Opus stream
+					one(pmt(pmtPID, moved, opusES)),
+					video(&videoCC, 93600, slice, true),
+					packetize(0x102, &newCC, pes(0xe0, 97200, idr, true)),
+					packetize(audioPID, &audioCC, pes(0xbd, 97200, opus[:7], true)))
+			},
+			want: []Frame{
+				{PID: videoPID, Codec: CodecH264, Data: idr, PTS: time.Second, Keyframe: true},
+				{PID: 0x102, Codec: CodecH264, Data: idr, PTS: time.Second + 80*time.Millisecond, Keyframe: true},
+				{PID: audioPID, Codec: CodecOpus, Data: []byte{0xf8, 1, 2, 3}, PTS: time.Second + 80*time.Millisecond, Duration: 20 * time.Millisecond},
+			},
+			streams: []esInfo{h264, {streamType: streamTypeH264, pid: 0x102}, {streamType: streamTypePrivate, pid: audioPID}},
+		},
+		{
+			name: "unsupported stream",
+			packets: func() [][]byte {
+				cc := -1
+				return concat(one(pat(pmtPID)), one(pmt(pmtPID, esInfo{streamType: 0x0f, pid: audioPID})),
+					packetize(audioPID, &cc, pes(0xc0, 90000, []byte{1, 2, 3}, true)))
+			},
+			streams: []esInfo{{streamType: 0x0f, pid: audioPID}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream []byte
+			for _, p := range tt.packets() {
+				if len(p) != PacketSize {
+					t.Fatalf("built a packet of %d bytes", len(p))
+				}
+				stream = append(stream, p...)
+			}
+			// the stream is written whole, and in chunks splitting the packets after some garbage
+			for _, chunk := range []int{len(stream), 100} {
+				var got []Frame
+				var streams []esInfo
+				d := NewDemuxer(func(f *Frame) { got = append(got, *f) })
+				d.OnStream(func(pid uint16, streamType byte, codec Codec) {
+					streams = append(streams, esInfo{streamType: streamType, pid: pid})
+				})
+				d.Write([]byte{1, 2, 3})
+				for b := stream; len(b) > 0; {
+					n := chunk
+					if n > len(b) {
+						n = len(b)
+					}
+					if w, err := d.Write(b[:n]); w != n || err != nil {
+						t.Fatalf("Write = %d, %v, want %d", w, err, n)
+					}
+					b = b[n:]
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("chunks of %d bytes: got the frames %+v, want %+v", chunk, got, tt.want)
+				}
+				if !reflect.DeepEqual(streams, tt.streams) {
+					t.Fatalf("chunks of %d bytes: got the streams %+v, want %+v", chunk, streams, tt.streams)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for _, pts := range []int64{0, 1, 90000, 1<<32 + 12345, ptsMask} {
+		if got := parseTimestamp(pes(0xe0, pts, nil, false)[9:]); got != pts {
+			t.Errorf("parseTimestamp = %d, want %d", got, pts)
+		}
+	}
+}
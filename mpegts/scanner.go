@@ -0,0 +1,161 @@
+package mpegts
+
+import (
+	"bytes"
+)
+
+// randomAccess is the random access indicator of the adaptation field
+const randomAccess = 0x40
+
+// Scanner finds the H.264 keyframes of a stream without demuxing it, and keeps the latest PAT and PMT so they can
+// be sent to a client joining the stream
+type Scanner struct {
+	pmtPID int
+	// videoPID is the PID of the H.264 stream, -1 until the PMT lists one
+	videoPID int
+	pat      []byte
+	pmt      []byte
+	// partial is the start of the packet split across the chunks of a byte stream
+	partial []byte
+}
+
+// NewScanner creates a scanner
+func NewScanner() *Scanner {
+	return &Scanner{pmtPID: -1, videoPID: -1}
+}
+
+// Scan scans a chunk of whole packets and reports whether one of them starts a keyframe. A keyframe starts with a
+// PES packet of the H.264 stream carrying the random access indicator, an IDR picture or a sequence parameter set
+// in the same packet. The chunks not starting on a packet boundary are ignored.
+func (s *Scanner) Scan(chunk []byte) bool {
+	keyframe := false
+	for ; len(chunk) >= PacketSize && chunk[0] == syncByte; chunk = chunk[PacketSize:] {
+		p := chunk[:PacketSize]
+		start := p[1]&0x40 != 0
+		pid := int(p[1]&0x1f)<<8 | int(p[2])
+		control := p[3] >> 4 & 3
+		if control&1 == 0 {
+			continue
+		}
+		payload := p[4:]
+		rai := false
+		if control&2 != 0 {
+			length := int(payload[0])
+			if length >= len(payload) {
+				continue
+			}
+			rai = length > 0 && payload[1]&randomAccess != 0
+			payload = payload[1+length:]
+		}
+
+		switch {
+		case pid == patPID:
+			s.scanPAT(p, start, payload)
+		case pid == s.pmtPID:
+			s.scanPMT(p, start, payload)
+		case start && s.isVideo(pid, payload):
+			if rai || startsKeyframe(payload) {
+				keyframe = true
+			}
+		}
+	}
+	return keyframe
+}
+
+// ScanStream scans a chunk of a byte stream and reports whether a keyframe starts in it, the packets split across the
+// chunks are reassembled and the stream is resynchronized on the sync byte
+func (s *Scanner) ScanStream(chunk []byte) bool {
+	keyframe := false
+	if len(s.partial) > 0 {
+		need := PacketSize - len(s.partial)
+		if len(chunk) < need {
+			s.partial = append(s.partial, chunk...)
+			return false
+		}
+		s.partial = append(s.partial, chunk[:need]...)
+		keyframe = s.Scan(s.partial)
+		s.partial = s.partial[:0]
+		chunk = chunk[need:]
+	}
+	i := bytes.IndexByte(chunk, syncByte)
+	if i < 0 {
+		return keyframe
+	}
+	chunk = chunk[i:]
+	whole := len(chunk) / PacketSize * PacketSize
+	if s.Scan(chunk[:whole]) {
+		keyframe = true
+	}
+	s.partial = append(s.partial, chunk[whole:]...)
+	return keyframe
+}
+
+// Tables returns the latest PAT and PMT packets, nil until both are known
+func (s *Scanner) Tables() []byte {
+	if s.pat == nil || s.pmt == nil {
+		return nil
+	}
+	return append(append([]byte(nil), s.pat...), s.pmt...)
+}
+
+func (s *Scanner) scanPAT(p []byte, start bool, payload []byte) {
+	sec := section(start, payload, 0x00)
+	if sec == nil {
+		return
+	}
+	for i := 5; i+4 <= len(sec); i += 4 {
+		if sec[i] == 0 && sec[i+1] == 0 {
+			continue
+		}
+		if pid := int(sec[i+2]&0x1f)<<8 | int(sec[i+3]); pid != s.pmtPID {
+			s.pmtPID, s.videoPID, s.pmt = pid, -1, nil
+		}
+		s.pat = append(s.pat[:0], p...)
+		return
+	}
+}
+
+func (s *Scanner) scanPMT(p []byte, start bool, payload []byte) {
+	sec := section(start, payload, 0x02)
+	if sec == nil || len(sec) < 9 {
+		return
+	}
+	s.videoPID = -1
+	infoLength := int(sec[7]&0x0f)<<8 | int(sec[8])
+	for i := 9 + infoLength; i+5 <= len(sec); i += 5 + (int(sec[i+3]&0x0f)<<8 | int(sec[i+4])) {
+		if sec[i] == streamTypeH264 {
+			s.videoPID = int(sec[i+1]&0x1f)<<8 | int(sec[i+2])
+			break
+		}
+	}
+	s.pmt = append(s.pmt[:0], p...)
+}
+
+// isVideo reports whether the payload starts a PES packet of the H.264 stream, any video stream is accepted until the
+// PMT is known
+func (s *Scanner) isVideo(pid int, payload []byte) bool {
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return false
+	}
+	if s.videoPID >= 0 {
+		return pid == s.videoPID
+	}
+	return s.pmt == nil && payload[3]&0xf0 == 0xe0
+}
+
+// startsKeyframe reports whether the start of a PES packet holds an IDR picture or a sequence parameter set
+func startsKeyframe(payload []byte) bool {
+	header := 9 + int(payload[8])
+	if header > len(payload) {
+		return false
+	}
+	found := false
+	ForEachNAL(payload[header:], func(nal []byte) bool {
+		if t := nal[0] & 0x1f; t == nalTypeIDR || t == nalTypeSPS {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
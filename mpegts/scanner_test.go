@@ -0,0 +1,129 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	const pmtPID, videoPID, audioPID = 0x1000, 0x100, 0x101
+	tables := append(pat(pmtPID), pmt(pmtPID, esInfo{streamType: streamTypeH264, pid: videoPID},
+		esInfo{streamType: streamTypePrivate, pid: audioPID, descriptors: opusDescriptor})...)
+	start := func(pid uint16, streamID byte, rai bool, au []byte) []byte {
+		return tsPacket(pid, true, 0, rai, pes(streamID, 90000, au, false))
+	}
+	idr, slice, sps := accessUnit(nalTypeIDR, 20), accessUnit(1, 20), accessUnit(nalTypeSPS, 20)
+
+	tests := []struct {
+		name  string
+		chunk []byte
+		want  bool
+	}{
+		{name: "random access indicator", chunk: start(videoPID, 0xe0, true, slice), want: true},
+		{name: "IDR picture", chunk: start(videoPID, 0xe0, false, idr), want: true},
+		{name: "sequence parameter set", chunk: start(videoPID, 0xe0, false, sps), want: true},
+		{name: "slice", chunk: start(videoPID, 0xe0, false, slice)},
+		{name: "continuation", chunk: tsPacket(videoPID, false, 1, false, idr)},
+		{name: "adaptation field only", chunk: tsPacket(videoPID, false, 1, true, nil)},
+		{name: "other stream", chunk: start(audioPID, 0xe0, true, idr)},
+		{name: "keyframe after a slice", chunk: append(start(videoPID, 0xe0, false, slice), start(videoPID, 0xe0, false, idr)...), want: true},
+		{name: "not on a packet boundary", chunk: start(videoPID, 0xe0, true, idr)[1:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner()
+			s.Scan(tables)
+			if got := s.Scan(tt.chunk); got != tt.want {
+				t.Fatalf("Scan = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanBeforeProgramMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		streamID byte
+		want     bool
+	}{
+		{name: "video", streamID: 0xe0, want: true},
+		{name: "other video", streamID: 0xef, want: true},
+		{name: "audio", streamID: 0xc0},
+		{name: "private", streamID: 0xbd},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner()
+			if got := s.Scan(tsPacket(0x100, true, 0, false, pes(tt.streamID, 0, accessUnit(nalTypeIDR, 20), false))); got != tt.want {
+				t.Fatalf("Scan = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScannerTables(t *testing.T) {
+	h264 := func(pid uint16) esInfo { return esInfo{streamType: streamTypeH264, pid: pid} }
+	idr := func(pid uint16) []byte {
+		return tsPacket(pid, true, 0, false, pes(0xe0, 0, accessUnit(nalTypeIDR, 20), false))
+	}
+
+	s := NewScanner()
+	if s.Scan(pat(0x1000)); s.Tables() != nil {
+		t.Fatal("got the tables before the PMT")
+	}
+	s.Scan(pmt(0x1000, h264(0x100)))
+	if want := append(pat(0x1000), pmt(0x1000, h264(0x100))...); !bytes.Equal(s.Tables(), want) {
+		t.Fatal("got other tables than the latest PAT and PMT")
+	}
+
+	// the PMT changes mid-stream, the video moves to another PID
+	s.Scan(pmt(0x1000, h264(0x200)))
+	if want := append(pat(0x1000), pmt(0x1000, h264(0x200))...); !bytes.Equal(s.Tables(), want) {
+		t.Fatal("got the previous PMT")
+	}
+	if s.Scan(idr(0x100)) || !s.Scan(idr(0x200)) {
+		t.Fatal("the keyframes are not scanned on the new video PID")
+	}
+
+	// the PAT moves the PMT, the previous one is forgotten
+	s.Scan(pat(0x1001))
+	if s.Tables() != nil {
+		t.Fatal("got the tables with the PMT of the previous PAT")
+	}
+	if s.Scan(idr(0x200)); s.Scan(pmt(0x1000, h264(0x100))) || s.Tables() != nil {
+		t.Fatal("the PMT of the previous PAT is still scanned")
+	}
+	s.Scan(pmt(0x1001, h264(0x300)))
+	if want := append(pat(0x1001), pmt(0x1001, h264(0x300))...); !bytes.Equal(s.Tables(), want) {
+		t.Fatal("got other tables than the latest PAT and PMT")
+	}
+}
+
+func TestScanStream(t *testing.T) {
+	stream := append(pat(0x1000), pmt(0x1000, esInfo{streamType: streamTypeH264, pid: 0x100})...)
+	stream = append(stream, tsPacket(0x100, true, 0, false, pes(0xe0, 0, accessUnit(1, 20), false))...)
+	keyframe := len(stream)
+	stream = append(stream, tsPacket(0x100, true, 1, false, pes(0xe0, 0, accessUnit(nalTypeIDR, 20), false))...)
+
+	for _, size := range []int{1, 100, PacketSize, 500, len(stream)} {
+		s := NewScanner()
+		// garbage before the first packet
+		if s.ScanStream([]byte{1, 2, 3}) {
+			t.Fatal("got a keyframe in the garbage")
+		}
+		for i := 0; i < len(stream); i += size {
+			end := i + size
+			if end > len(stream) {
+				end = len(stream)
+			}
+			// the keyframe is reported by the chunk completing its first packet
+			want := end >= keyframe+PacketSize && (i < keyframe+PacketSize)
+			if got := s.ScanStream(stream[i:end]); got != want {
+				t.Fatalf("chunks of %d bytes: ScanStream(stream[%d:%d]) = %v, want %v", size, i, end, got, want)
+			}
+		}
+		if s.Tables() == nil {
+			t.Fatalf("chunks of %d bytes: got no tables", size)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package outbound
+
+import (
+	"github.com/howyoungzhou/golive/mpegts"
+	"github.com/howyoungzhou/golive/server"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultGOPCacheSize = 4096
+	// rtpMTU is the size of the RTP packets made from the samples, as used by pion
+	rtpMTU = 1200
+)
+
+// GOPCacheOptions caches the packets since the latest H.264 keyframe, they are sent to each new client before the
+// live packets so it can start decoding at once
+type GOPCacheOptions struct {
+	Enabled bool
+	// MaxPackets bounds the cache, once a group of pictures exceeds it nothing is cached until the next keyframe
+	// (4096 by default)
+	MaxPackets int
+}
+
+func (o GOPCacheOptions) maxPackets() int {
+	if o.MaxPackets <= 0 {
+		return defaultGOPCacheSize
+	}
+	return o.MaxPackets
+}
+
+// gopCache keeps the MPEG-TS packets since the latest keyframe of a stream, along with its latest PAT and PMT
+type gopCache struct {
+	max     int
+	scanner *mpegts.Scanner
+	packets []*server.Packet
+}
+
+func newGOPCache(options GOPCacheOptions) *gopCache {
+	return &gopCache{max: options.maxPackets(), scanner: mpegts.NewScanner()}
+}
+
+// add caches a packet, the cache starts over with the tables at each keyframe
+func (c *gopCache) add(pkt *server.Packet) {
+	if c.scanner.Scan(pkt.Payload) {
+		c.reset()
+		if tables := c.scanner.Tables(); tables != nil {
+			p := server.NewPacket(len(tables))
+			copy(p.Payload, tables)
+			c.packets = append(c.packets, p)
+		}
+	} else if len(c.packets) == 0 {
+		// waiting for a keyframe
+		return
+	}
+	if len(c.packets) >= c.max {
+		c.reset()
+		return
+	}
+	pkt.Retain()
+	c.packets = append(c.packets, pkt)
+}
+
+// snapshot returns the cached packets, each one holds a reference for the caller
+func (c *gopCache) snapshot() []*server.Packet {
+	res := make([]*server.Packet, len(c.packets))
+	for i, p := range c.packets {
+		p.Retain()
+		res[i] = p
+	}
+	return res
+}
+
+func (c *gopCache) reset() {
+	for _, p := range c.packets {
+		p.Release()
+	}
+	c.packets = nil
+}
+
+// h264Keyframe reports whether the payload of an RTP packet holds an IDR picture or a sequence parameter set, or
+// starts one of them
+func h264Keyframe(payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+	isKey := func(t byte) bool { return t == 5 || t == 7 }
+	switch t := payload[0] & 0x1f; t {
+	case 24:
+		// STAP-A
+		for i := 1; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			i += 2
+			if isKey(payload[i] & 0x1f) {
+				return true
+			}
+			i += size
+		}
+		return false
+	case 28:
+		// FU-A
+		return payload[1]&0x80 != 0 && isKey(payload[1]&0x1f)
+	default:
+		return isKey(t)
+	}
+}
+
+// gopTrack is an H.264 track replaying the packets since the latest keyframe to each new viewer before the live
+// packets. The static tracks of pion send the same packets to all the viewers, so a viewer joining between two
+// keyframes can not decode anything until the next one.
+type gopTrack struct {
+	codec        webrtc.RTPCodecCapability
+	id, streamID string
+	max          int
+	// packets are the packets since the latest keyframe
+	packets    []*rtp.Packet
+	bindings   []*gopBinding
+	packetizer rtp.Packetizer
+	mux        sync.Mutex
+}
+
+// gopBinding is a viewer of a gopTrack
+type gopBinding struct {
+	id          string
+	ssrc        webrtc.SSRC
+	payloadType webrtc.PayloadType
+	writer      webrtc.TrackLocalWriter
+	// ready is set once the packets are sent, the writer drops them until the connection is secured
+	ready bool
+	// offset shifts the sequence numbers of the live packets past the replayed ones, last is the latest sequence
+	// number sent
+	offset uint16
+	last   uint16
+}
+
+func newGOPTrack(codec webrtc.RTPCodecCapability, id, streamID string, options GOPCacheOptions) *gopTrack {
+	t := &gopTrack{codec: codec, id: id, streamID: streamID, max: options.maxPackets()}
+	// the SSRC and the payload type are set for each viewer
+	t.packetizer = rtp.NewPacketizer(rtpMTU, 0, 0, &codecs.H264Payloader{}, rtp.NewRandomSequencer(), t.clockRate())
+	return t
+}
+
+// Bind adds a viewer once its codecs are negotiated
+func (t *gopTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, ok := matchCodec(t.codec, ctx.CodecParameters())
+	if !ok {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+	t.mux.Lock()
+	t.bindings = append(t.bindings, &gopBinding{
+		id:          ctx.ID(),
+		ssrc:        ctx.SSRC(),
+		payloadType: codec.PayloadType,
+		writer:      ctx.WriteStream(),
+	})
+	t.mux.Unlock()
+	return codec, nil
+}
+
+// Unbind removes a viewer
+func (t *gopTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for i, b := range t.bindings {
+		if b.id == ctx.ID() {
+			t.bindings = append(t.bindings[:i], t.bindings[i+1:]...)
+			return nil
+		}
+	}
+	return webrtc.ErrUnbindFailed
+}
+
+func (t *gopTrack) ID() string { return t.id }
+
+func (t *gopTrack) StreamID() string { return t.streamID }
+
+func (t *gopTrack) Kind() webrtc.RTPCodecType { return webrtc.RTPCodecTypeVideo }
+
+func (t *gopTrack) Codec() webrtc.RTPCodecCapability { return t.codec }
+
+// Write writes an RTP packet
+func (t *gopTrack) Write(b []byte) (int, error) {
+	p := &rtp.Packet{}
+	// the packet is cached, so it must not share the buffer of the caller
+	if err := p.Unmarshal(append([]byte(nil), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), t.WriteRTP(p)
+}
+
+// WriteSample packetizes an access unit, as TrackLocalStaticSample does
+func (t *gopTrack) WriteSample(s media.Sample) error {
+	t.mux.Lock()
+	packets := t.packetizer.Packetize(s.Data, uint32(s.Duration.Seconds()*float64(t.clockRate())))
+	t.mux.Unlock()
+	for _, p := range packets {
+		if err := t.WriteRTP(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRTP caches a packet and sends it to the viewers, a new viewer receives the cached packets first. The packet
+// must not be modified afterwards.
+func (t *gopTrack) WriteRTP(p *rtp.Packet) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.cache(p)
+	var res error
+	for _, b := range t.bindings {
+		var err error
+		if b.ready {
+			_, err = b.write(p, p.SequenceNumber+b.offset)
+		} else {
+			err = t.replay(b, p)
+		}
+		if err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// replay sends the cached packets to a new viewer, nothing is sent until its connection is secured
+func (t *gopTrack) replay(b *gopBinding, p *rtp.Packet) error {
+	packets := t.packets
+	if len(packets) == 0 {
+		packets = []*rtp.Packet{p}
+	}
+	n, err := b.write(packets[0], packets[0].SequenceNumber)
+	if n == 0 || err != nil {
+		return err
+	}
+	b.ready = true
+	for _, p := range packets[1:] {
+		if _, err := b.write(p, p.SequenceNumber); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayTo sends the cached packets again to a viewer asking for a keyframe. Their sequence numbers follow the ones
+// the viewer received so they are not dropped as duplicates, the live packets are shifted past them.
+func (t *gopTrack) replayTo(ssrc webrtc.SSRC) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.packets) == 0 {
+		return
+	}
+	for _, b := range t.bindings {
+		if b.ssrc != ssrc || !b.ready {
+			continue
+		}
+		for _, p := range t.packets {
+			if _, err := b.write(p, b.last+1); err != nil {
+				break
+			}
+		}
+		// the latest cached packet is the latest live packet
+		b.offset = b.last - t.packets[len(t.packets)-1].SequenceNumber
+	}
+}
+
+// cache starts over at each keyframe, the packets of the access unit of the keyframe share its timestamp
+func (t *gopTrack) cache(p *rtp.Packet) {
+	if h264Keyframe(p.Payload) && (len(t.packets) == 0 || t.packets[0].Timestamp != p.Timestamp) {
+		t.packets = []*rtp.Packet{p}
+		return
+	}
+	if len(t.packets) == 0 {
+		return
+	}
+	if len(t.packets) >= t.max {
+		t.packets = nil
+		return
+	}
+	t.packets = append(t.packets, p)
+}
+
+func (t *gopTrack) clockRate() uint32 {
+	if t.codec.ClockRate == 0 {
+		return 90000
+	}
+	return t.codec.ClockRate
+}
+
+func (b *gopBinding) write(p *rtp.Packet, seq uint16) (int, error) {
+	header := p.Header
+	header.SSRC = uint32(b.ssrc)
+	header.PayloadType = uint8(b.payloadType)
+	header.SequenceNumber = seq
+	b.last = seq
+	return b.writer.WriteRTP(&header, p.Payload)
+}
+
+// matchCodec finds the negotiated parameters of a codec, matching its format parameters first as pion does
+func matchCodec(codec webrtc.RTPCodecCapability, params []webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, bool) {
+	for _, c := range params {
+		if strings.EqualFold(c.MimeType, codec.MimeType) && strings.EqualFold(c.SDPFmtpLine, codec.SDPFmtpLine) {
+			return c, true
+		}
+	}
+	for _, c := range params {
+		if strings.EqualFold(c.MimeType, codec.MimeType) {
+			return c, true
+		}
+	}
+	return webrtc.RTPCodecParameters{}, false
+}
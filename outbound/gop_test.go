@@ -0,0 +1,265 @@
+package outbound
+
+import (
+	"bytes"
+	"github.com/howyoungzhou/golive/server"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"reflect"
+	"testing"
+)
+
+func TestH264Keyframe(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    bool
+	}{
+		{name: "IDR", payload: []byte{0x65, 1}, want: true},
+		{name: "sequence parameter set", payload: []byte{0x67, 1}, want: true},
+		{name: "picture parameter set", payload: []byte{0x68, 1}},
+		{name: "slice", payload: []byte{0x41, 1}},
+		{name: "too short", payload: []byte{0x65}},
+		{name: "STAP-A with the parameter sets", payload: []byte{0x78, 0, 2, 0x67, 1, 0, 2, 0x68, 2}, want: true},
+		{name: "STAP-A with an IDR after a SEI", payload: []byte{0x78, 0, 2, 0x06, 1, 0, 2, 0x65, 2}, want: true},
+		{name: "STAP-A without keyframe", payload: []byte{0x78, 0, 2, 0x06, 1, 0, 2, 0x68, 2}},
+		{name: "FU-A start of an IDR", payload: []byte{0x7c, 0x85, 1}, want: true},
+		{name: "FU-A continuation of an IDR", payload: []byte{0x7c, 0x05, 1}},
+		{name: "FU-A start of a slice", payload: []byte{0x7c, 0x81, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h264Keyframe(tt.payload); got != tt.want {
+				t.Fatalf("h264Keyframe = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// tsPacket builds an MPEG-TS packet of the video PID 0x100, or of a PSI table if psi is set. A keyframe starts a PES
+// packet with the random access indicator.
+func tsPacket(start, keyframe bool, psi []byte) *server.Packet {
+	p := server.NewPacket(188)
+	b := p.Payload
+	for i := range b {
+		b[i] = 0xff
+	}
+	b[0], b[1], b[2], b[3] = 0x47, 0x01, 0x00, 0x10
+	if start {
+		b[1] |= 0x40
+	}
+	payload := b[4:]
+	if keyframe {
+		b[3] |= 0x20
+		payload[0], payload[1] = 7, 0x40
+		payload = payload[8:]
+	}
+	switch {
+	case psi != nil:
+		b[1], b[2] = 0x40|psi[0], psi[1]
+		copy(payload, psi[2:])
+	case start:
+		copy(payload, []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0, 0})
+	}
+	return p
+}
+
+var (
+	// pat lists the PMT on the PID 0x1000, the first two bytes are the PID of the table
+	pat = []byte{0x00, 0x00, 0, 0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00, 0, 0, 0, 0}
+	// pmt lists an H.264 stream on the PID 0x100
+	pmt = []byte{0x10, 0x00, 0, 0x02, 0xb0, 18, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0, 0x1b, 0xe1, 0x00, 0xf0, 0, 0, 0, 0, 0}
+)
+
+func TestGOPCache(t *testing.T) {
+	const (
+		P = iota
+		// K starts a keyframe
+		K
+		// T are the PAT and the PMT
+		T
+	)
+	tests := []struct {
+		name    string
+		max     int
+		packets []int
+		// want are the indexes of the packets of the snapshot, -1 for the tables prepended by the cache
+		want []int
+	}{
+		{name: "waiting for a keyframe", packets: []int{P, P}},
+		{name: "from the keyframe", packets: []int{P, K, P, P}, want: []int{1, 2, 3}},
+		{name: "reset at each keyframe", packets: []int{K, P, K, P}, want: []int{2, 3}},
+		{name: "tables", packets: []int{T, P, K, P, T, P}, want: []int{-1, 3, 4, 5, 6, 7}},
+		{name: "trimmed", max: 3, packets: []int{K, P, P, P, P}},
+		{name: "keyframe after the trim", max: 3, packets: []int{K, P, P, P, P, K, P}, want: []int{5, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newGOPCache(GOPCacheOptions{Enabled: true, MaxPackets: tt.max})
+			var packets []*server.Packet
+			for _, kind := range tt.packets {
+				switch kind {
+				case P:
+					packets = append(packets, tsPacket(false, false, nil))
+				case K:
+					packets = append(packets, tsPacket(true, true, nil))
+				case T:
+					packets = append(packets, tsPacket(true, false, pat), tsPacket(true, false, pmt))
+				}
+			}
+			for _, p := range packets {
+				c.add(p)
+			}
+			got := c.snapshot()
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d packets, want %d", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				if w < 0 {
+					if want := append(append([]byte(nil), packets[0].Payload...), packets[1].Payload...); !bytes.Equal(got[i].Payload, want) {
+						t.Errorf("packet %d is not the tables", i)
+					}
+				} else if got[i] != packets[w] {
+					t.Errorf("packet %d is not the packet %d", i, w)
+				}
+				got[i].Release()
+			}
+			c.reset()
+			if len(c.snapshot()) != 0 {
+				t.Fatal("got packets after the reset")
+			}
+		})
+	}
+}
+
+// fakeTrackWriter records the sequence numbers written to a viewer, nothing is written until it is secured
+type fakeTrackWriter struct {
+	secured bool
+	seqs    []uint16
+	first   []byte
+}
+
+func (w *fakeTrackWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	if !w.secured {
+		return 0, nil
+	}
+	if w.seqs == nil {
+		w.first = payload
+	}
+	w.seqs = append(w.seqs, header.SequenceNumber)
+	return len(payload), nil
+}
+
+func (w *fakeTrackWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+var (
+	sps      = []byte{0x78, 0, 2, 0x67, 1, 0, 2, 0x68, 2}
+	idrStart = []byte{0x7c, 0x85, 1}
+	idrEnd   = []byte{0x7c, 0x45, 2}
+	idr      = []byte{0x65, 1}
+	slice    = []byte{0x41, 1}
+)
+
+func rtpPacket(seq uint16, ts uint32, payload []byte) *rtp.Packet {
+	return &rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: ts}, Payload: payload}
+}
+
+func TestGOPTrack(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		// before are written before the viewer joins, and after once it joined
+		before []*rtp.Packet
+		after  []*rtp.Packet
+		// lost is the number of packets written after the viewer joined but before its connection is secured
+		lost int
+		want []uint16
+		// keyframe is set if the viewer starts on a keyframe
+		keyframe bool
+	}{
+		{
+			name: "late joiner",
+			before: []*rtp.Packet{
+				rtpPacket(99, 0, slice),
+				rtpPacket(100, 3000, sps), rtpPacket(101, 3000, idrStart), rtpPacket(102, 3000, idrEnd),
+				rtpPacket(103, 6000, slice),
+			},
+			after:    []*rtp.Packet{rtpPacket(104, 9000, slice), rtpPacket(105, 12000, slice), rtpPacket(106, 15000, slice)},
+			lost:     1,
+			want:     []uint16{100, 101, 102, 103, 104, 105, 106},
+			keyframe: true,
+		},
+		{
+			name:     "latest keyframe",
+			before:   []*rtp.Packet{rtpPacket(10, 0, idr), rtpPacket(11, 3000, slice), rtpPacket(12, 6000, idr), rtpPacket(13, 9000, slice)},
+			after:    []*rtp.Packet{rtpPacket(14, 12000, slice)},
+			want:     []uint16{12, 13, 14},
+			keyframe: true,
+		},
+		{
+			name:   "no keyframe",
+			before: []*rtp.Packet{rtpPacket(1, 0, slice)},
+			after:  []*rtp.Packet{rtpPacket(2, 3000, slice), rtpPacket(3, 6000, slice)},
+			want:   []uint16{2, 3},
+		},
+		{
+			name:   "over the max",
+			max:    2,
+			before: []*rtp.Packet{rtpPacket(10, 0, idr), rtpPacket(11, 3000, slice), rtpPacket(12, 6000, slice)},
+			after:  []*rtp.Packet{rtpPacket(13, 9000, slice)},
+			want:   []uint16{13},
+		},
+		{
+			name:     "wraparound",
+			before:   []*rtp.Packet{rtpPacket(65534, 0, idr), rtpPacket(65535, 3000, slice)},
+			after:    []*rtp.Packet{rtpPacket(0, 6000, slice), rtpPacket(1, 9000, slice)},
+			want:     []uint16{65534, 65535, 0, 1},
+			keyframe: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track := newGOPTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "live",
+				GOPCacheOptions{Enabled: true, MaxPackets: tt.max})
+			for _, p := range tt.before {
+				if err := track.WriteRTP(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w := &fakeTrackWriter{}
+			track.bindings = append(track.bindings, &gopBinding{id: "viewer", ssrc: 1234, payloadType: 102, writer: w})
+			for i, p := range tt.after {
+				w.secured = i >= tt.lost
+				if err := track.WriteRTP(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(w.seqs, tt.want) {
+				t.Fatalf("got the sequence numbers %v, want %v", w.seqs, tt.want)
+			}
+			if h264Keyframe(w.first) != tt.keyframe {
+				t.Fatalf("got the first packet %v, want a keyframe: %v", w.first, tt.keyframe)
+			}
+		})
+	}
+}
+
+func TestGOPTrackReplayTo(t *testing.T) {
+	track := newGOPTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "live", GOPCacheOptions{})
+	w, other := &fakeTrackWriter{secured: true}, &fakeTrackWriter{secured: true}
+	track.bindings = []*gopBinding{{id: "viewer", ssrc: 1, writer: w}, {id: "other", ssrc: 2, writer: other}}
+	for _, p := range []*rtp.Packet{rtpPacket(10, 0, idr), rtpPacket(11, 3000, slice)} {
+		track.WriteRTP(p)
+	}
+	// the viewer asks for a keyframe, the cached packets are sent again after the ones it received
+	track.replayTo(1)
+	track.WriteRTP(rtpPacket(12, 6000, slice))
+	track.replayTo(1)
+	track.WriteRTP(rtpPacket(13, 9000, slice))
+	if want := []uint16{10, 11, 12, 13, 14, 15, 16, 17, 18}; !reflect.DeepEqual(w.seqs, want) {
+		t.Fatalf("got the sequence numbers %v, want %v", w.seqs, want)
+	}
+	if want := []uint16{10, 11, 12, 13}; !reflect.DeepEqual(other.seqs, want) {
+		t.Fatalf("got the sequence numbers %v for the other viewer, want %v", other.seqs, want)
+	}
+}
@@ -0,0 +1,63 @@
+package outbound
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultKeyframeInterval = 1000
+
+// KeyframeRequestOptions handles the PLI and FIR sent by the viewers, the durations are in milliseconds
+type KeyframeRequestOptions struct {
+	// Interval is the minimum time between two requests forwarded upstream for a track, and between two replays of
+	// the GOP cache to a viewer (1000 by default). The requests received in between are aggregated into a single one
+	// handled at the end of the interval.
+	Interval int
+	// Upstream is the component asked for a keyframe of the video tracks, possibly one of its tracks, e.g.
+	// "whip-in:video". It must implement server.KeyframeRequester.
+	Upstream string
+}
+
+func (o KeyframeRequestOptions) interval() time.Duration {
+	if o.Interval <= 0 {
+		return defaultKeyframeInterval * time.Millisecond
+	}
+	return time.Duration(o.Interval) * time.Millisecond
+}
+
+// throttle runs an action at most once per interval, the calls in between are aggregated into a single run at the
+// end of the interval. The action runs in its own goroutine.
+type throttle struct {
+	interval time.Duration
+	action   func()
+	last     time.Time
+	// pending is set while a run is scheduled
+	pending bool
+	mux     sync.Mutex
+}
+
+func newThrottle(interval time.Duration, action func()) *throttle {
+	return &throttle{interval: interval, action: action}
+}
+
+func (t *throttle) call() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.pending {
+		return
+	}
+	wait := t.interval - time.Since(t.last)
+	if wait <= 0 {
+		t.last = time.Now()
+		go t.action()
+		return
+	}
+	t.pending = true
+	time.AfterFunc(wait, func() {
+		t.mux.Lock()
+		t.pending = false
+		t.last = time.Now()
+		t.mux.Unlock()
+		t.action()
+	})
+}
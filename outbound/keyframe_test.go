@@ -0,0 +1,69 @@
+package outbound
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		// calls are the times of the calls, want the times of the runs
+		calls []time.Duration
+		want  []time.Duration
+	}{
+		{name: "single", calls: []time.Duration{0}, want: []time.Duration{0}},
+		{name: "burst", calls: []time.Duration{0, 10 * ms, 20 * ms, 30 * ms}, want: []time.Duration{0, interval}},
+		{name: "spaced", calls: []time.Duration{0, 150 * ms}, want: []time.Duration{0, 150 * ms}},
+		{name: "after an aggregated run", calls: []time.Duration{0, 10 * ms, 120 * ms}, want: []time.Duration{0, interval, 2 * interval}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var runs []time.Duration
+			var mux sync.Mutex
+			start := time.Now()
+			th := newThrottle(interval, func() {
+				mux.Lock()
+				runs = append(runs, time.Since(start))
+				mux.Unlock()
+			})
+			for _, c := range tt.calls {
+				time.Sleep(time.Until(start.Add(c)))
+				th.call()
+			}
+			time.Sleep(time.Until(start.Add(tt.calls[len(tt.calls)-1] + 2*interval)))
+
+			mux.Lock()
+			defer mux.Unlock()
+			if len(runs) != len(tt.want) {
+				t.Fatalf("got the runs at %v, want %v", runs, tt.want)
+			}
+			for i, w := range tt.want {
+				if runs[i] < w-5*ms || runs[i] > w+50*ms {
+					t.Fatalf("got the runs at %v, want %v", runs, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyframeRequestInterval(t *testing.T) {
+	tests := []struct {
+		interval int
+		want     time.Duration
+	}{
+		{interval: 0, want: time.Second},
+		{interval: -1, want: time.Second},
+		{interval: 250, want: 250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := (KeyframeRequestOptions{Interval: tt.interval}).interval(); got != tt.want {
+			t.Errorf("interval() = %v for %d, want %v", got, tt.interval, tt.want)
+		}
+	}
+}
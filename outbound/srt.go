@@ -1,36 +1,97 @@
 package outbound
 
 import (
+	"errors"
 	"github.com/haivision/srtgo"
+	"github.com/howyoungzhou/golive/auth"
+	"github.com/howyoungzhou/golive/inbound"
+	"github.com/howyoungzhou/golive/reconnect"
 	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/srtsock"
+	"github.com/howyoungzhou/golive/srtstats"
+	"github.com/howyoungzhou/golive/streamid"
 	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
+	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 type SRTOutboundOptions struct {
-	Host       string
-	Port       uint16
+	// Mode is inbound.SRTModeListener by default
+	Mode inbound.SRTMode
+	Host string
+	Port uint16
+	// LocalPort is the local port in rendezvous mode, Port by default
+	LocalPort  uint16
 	Options    map[string]string
 	BufferSize int
 	Timeout    int
+	// RouteStreams lets the clients select a stream with the resource of their stream id, e.g. "live/cam1" for
+	// "#!::r=live/cam1,m=request". The stream is written to "<id>/<resource>", the clients without a stream id receive
+	// the data written to the outbound itself.
+	RouteStreams bool
+	// Reconnect sets the backoff between the connection attempts in caller and rendezvous mode
+	Reconnect reconnect.Options
+	// Stats sets the interval between the samples of the statistics of each connection
+	Stats srtstats.Options
+	// Auth authorizes the clients in listener mode and sets the passphrase of each stream, the token is read from the
+	// "token" key of the stream id
+	Auth auth.Options
+	// GOPCache replays the latest group of pictures of each stream to the new clients
+	GOPCache GOPCacheOptions
 }
 
 // SRTOutbound implements SRT protocol for output
 type SRTOutbound struct {
-	options     *SRTOutboundOptions
-	channels    map[string]chan []byte
+	// dropped is accessed atomically and kept first for alignment
+	dropped uint64
+	options *SRTOutboundOptions
+	// channels holds the channels of the clients by stream and by address, the clients without a stream are kept
+	// under ""
+	channels map[string]map[string]chan *server.Packet
+	// caches holds the GOP cache of each stream, guarded by channelsMux
+	caches      map[string]*gopCache
 	channelsMux sync.Mutex
 	logger      *log.Entry
+	sck         *srtgo.SrtSocket
+	// removeCallback removes the listen callback once the listener is closed
+	removeCallback func()
+	closed         chan struct{}
+	server         *server.Server
+	id             string
+	caller         *reconnect.Loop
+	samplers       *srtstats.Set
+	auth           *auth.Authorizer
 }
 
 // NewSRTOutbound creates a new instance of SRTOutbound
 func NewSRTOutbound(options *SRTOutboundOptions) (*SRTOutbound, error) {
+	switch options.Mode {
+	case "", inbound.SRTModeListener, inbound.SRTModeCaller, inbound.SRTModeRendezvous:
+	default:
+		return nil, errors.New("unknown SRT mode: " + string(options.Mode))
+	}
+	if options.LocalPort == 0 {
+		options.LocalPort = options.Port
+	}
+	if options.Mode != "" && options.Mode != inbound.SRTModeListener && options.RouteStreams {
+		return nil, errors.New("routeStreams is only supported in listener mode")
+	}
+	authorizer, err := auth.New(options.Auth)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New().WithFields(log.Fields{"module": "SRTOutbound"})
 	return &SRTOutbound{
-		options,
-		make(map[string]chan []byte),
-		sync.Mutex{},
-		log.New().WithFields(log.Fields{"module": "SRTOutbound"}),
+		options:  options,
+		channels: make(map[string]map[string]chan *server.Packet),
+		caches:   make(map[string]*gopCache),
+		logger:   logger,
+		closed:   make(chan struct{}),
+		samplers: srtstats.NewSet(options.Stats, logger),
+		auth:     authorizer,
 	}, nil
 }
 
@@ -40,61 +101,360 @@ func RegisterSRTOutbound(server *server.Server, id string, options map[string]in
 	if err := mapstructure.Decode(options, opt); err != nil {
 		return nil, err
 	}
-	return NewSRTOutbound(opt)
+	out, err := NewSRTOutbound(opt)
+	if err != nil {
+		return nil, err
+	}
+	out.server, out.id = server, id
+	return out, nil
 }
 
-// Init runs the server
+// Init runs the server, or connects to the remote peer in caller and rendezvous mode
 func (s *SRTOutbound) Init() error {
+	if s.options.Mode == inbound.SRTModeCaller || s.options.Mode == inbound.SRTModeRendezvous {
+		addr := net.JoinHostPort(s.options.Host, strconv.Itoa(int(s.options.Port)))
+		s.caller = reconnect.New(addr, s.dial, s.options.Reconnect, s.logger)
+		go s.caller.Run()
+		return nil
+	}
 	sck := srtgo.NewSrtSocket(s.options.Host, s.options.Port, s.options.Options)
-
-	err := sck.Listen(1)
+	if sck == nil {
+		return errors.New("failed to create SRT socket")
+	}
+	removeCallback, err := srtsock.SetListenCallback(sck, s.check)
 	if err != nil {
+		sck.Close()
 		return err
 	}
+	if err := sck.Listen(1); err != nil {
+		removeCallback()
+		return err
+	}
+	s.sck, s.removeCallback = sck, removeCallback
 	s.logger.WithFields(log.Fields{"host": s.options.Host, "port": s.options.Port}).Info("The server is listening")
 
 	go func() {
 		for {
 			cltSck, addr, err := sck.Accept()
 			if err != nil {
-				continue
+				select {
+				case <-s.closed:
+					return
+				default:
+					continue
+				}
 			}
 			s.logger.WithFields(log.Fields{"host": addr.IP, "port": addr.Port}).Info("Incoming connection")
-			// allocate a new data channel and add it to the map
-			var channel = make(chan []byte, s.options.BufferSize)
-			s.channelsMux.Lock()
-			s.channels[addr.String()] = channel
-			s.channelsMux.Unlock()
-			go func() {
-				for {
-					data := <-channel
-					_, err := cltSck.Write(data, s.options.Timeout)
-					if err != nil {
-						cltSck.Close()
-						// remove the channel from the map
-						s.channelsMux.Lock()
-						delete(s.channels, addr.String())
-						s.channelsMux.Unlock()
-						s.logger.WithFields(log.Fields{"host": addr.IP, "port": addr.Port}).Info("Connection closed")
-						return
-					}
-				}
-			}()
+			// the connections are handled concurrently so a slow auth callback does not hold the others
+			go s.accept(cltSck, addr.String())
 		}
 	}()
 	return nil
 }
 
-// Write send a packet to all channels
-func (s *SRTOutbound) Write(data []byte) (int, error) {
+// check rejects the clients in the listen callback, so they are told why, and sets the passphrase of their stream.
+// The auth callback is left to accept since it would block the receiving thread of libsrt.
+func (s *SRTOutbound) check(c *srtsock.Conn) error {
+	if !s.options.RouteStreams && s.auth == nil {
+		return nil
+	}
+	stream, id, err := s.route(c.StreamID)
+	if err == nil {
+		req := auth.Request{ID: s.id, Addr: c.Addr, Stream: stream, Mode: auth.ModePlay, User: id.User, Token: id.Keys["token"]}
+		err = inbound.CheckSRTPeer(s.auth, c, req)
+	}
+	if err != nil {
+		s.logger.WithField("addr", c.Addr).WithError(err).Warn("Connection rejected")
+	}
+	return err
+}
+
+// accept authorizes a new connection and adds it to the clients of its stream. The stream is selected again since it
+// may have stopped being fed since the listen callback.
+func (s *SRTOutbound) accept(sck *srtgo.SrtSocket, addr string) {
+	logger := s.logger.WithField("addr", addr)
+	id := &streamid.StreamID{}
+	stream := ""
+	if s.options.RouteStreams || s.auth != nil {
+		sid, err := sck.GetSockOptString(srtgo.SRTO_STREAMID)
+		if err == nil {
+			stream, id, err = s.route(sid)
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Connection rejected")
+			sck.Close()
+			return
+		}
+		if stream != "" {
+			logger = logger.WithField("stream", stream)
+		}
+	}
+	req := auth.Request{ID: s.id, Addr: addr, Stream: stream, Mode: auth.ModePlay, User: id.User, Token: id.Keys["token"]}
+	if err := s.auth.Authorize(req); err != nil {
+		logger.WithError(err).Warn("Connection rejected")
+		sck.Close()
+		return
+	}
+	s.addClient(sck, addr, stream)
+}
+
+// dial connects to the remote peer, the connection receives the data written to the outbound
+func (s *SRTOutbound) dial() (<-chan struct{}, error) {
+	sck, err := inbound.DialSRT(s.options.Mode, s.options.Host, s.options.Port, s.options.LocalPort, s.options.Options)
+	if err != nil {
+		return nil, err
+	}
+	done, ok := s.addClient(sck, s.caller.Status().Addr, "")
+	if !ok {
+		return nil, errors.New("outbound closed")
+	}
+	return done, nil
+}
+
+// addClient sends the packets of a stream to a connection until it fails or the outbound is closed, the returned
+// channel is closed once the connection is closed. false is returned if the outbound is already closed.
+func (s *SRTOutbound) addClient(sck *srtgo.SrtSocket, addr, stream string) (<-chan struct{}, bool) {
+	// allocate a new data channel and add it to the map
+	var channel = make(chan *server.Packet, s.options.BufferSize)
 	s.channelsMux.Lock()
-	for addr, c := range s.channels {
+	select {
+	case <-s.closed:
+		// the outbound is closed while accepting
+		s.channelsMux.Unlock()
+		sck.Close()
+		return nil, false
+	default:
+	}
+	if s.channels[stream] == nil {
+		s.channels[stream] = make(map[string]chan *server.Packet)
+	}
+	s.channels[stream][addr] = channel
+	// the cached packets are taken along with the channel, so the live packets follow them
+	var cached []*server.Packet
+	if c := s.caches[stream]; c != nil {
+		cached = c.snapshot()
+	}
+	s.channelsMux.Unlock()
+	done := make(chan struct{})
+	sampler := s.samplers.Start(sck, addr, stream)
+	go func() {
+		defer close(done)
+		defer sampler.Stop()
+		for i, pkt := range cached {
+			_, err := sck.Write(pkt.Payload, s.options.Timeout)
+			pkt.Release()
+			if err != nil {
+				for _, p := range cached[i+1:] {
+					p.Release()
+				}
+				s.removeClient(sck, addr, stream)
+				return
+			}
+		}
+		for {
+			pkt, ok := <-channel
+			if !ok {
+				// the channel is closed by Close
+				sck.Close()
+				return
+			}
+			_, err := sck.Write(pkt.Payload, s.options.Timeout)
+			pkt.Release()
+			if err != nil {
+				s.removeClient(sck, addr, stream)
+				return
+			}
+		}
+	}()
+	return done, true
+}
+
+// removeClient closes the connection of a failed client and removes its channel from the map
+func (s *SRTOutbound) removeClient(sck *srtgo.SrtSocket, addr, stream string) {
+	sck.Close()
+	s.channelsMux.Lock()
+	delete(s.channels[stream], addr)
+	if len(s.channels[stream]) == 0 {
+		delete(s.channels, stream)
+	}
+	s.channelsMux.Unlock()
+	s.logger.WithField("addr", addr).Info("Connection closed")
+}
+
+// route parses the stream id of a client and selects its stream, "" if the streams are not routed
+func (s *SRTOutbound) route(sid string) (string, *streamid.StreamID, error) {
+	id, err := streamid.Parse(sid)
+	if err != nil {
+		return "", nil, srtsock.Reject(srtsock.RejectBadRequest, err)
+	}
+	if !s.options.RouteStreams {
+		return "", id, nil
+	}
+	stream, err := s.selectStream(id)
+	return stream, id, err
+}
+
+// selectStream returns the stream requested by the stream id of a client, "" if it has no resource. The stream must
+// be fed by a pipe. The errors carry the reason of the rejection.
+func (s *SRTOutbound) selectStream(id *streamid.StreamID) (string, error) {
+	if id.Resource == "" {
+		return "", nil
+	}
+	if id.Mode != "" && id.Mode != streamid.ModeRequest {
+		return "", srtsock.Reject(srtsock.RejectBadMode, errors.New("the outbound only serves playback requests"))
+	}
+	if !streamid.ValidResource(id.Resource) {
+		return "", srtsock.Reject(srtsock.RejectBadRequest, errors.New("invalid resource: "+id.Resource))
+	}
+	if !s.server.Attached(s.id + "/" + id.Resource) {
+		return "", srtsock.Reject(srtsock.RejectNotFound, errors.New("unknown stream: "+id.Resource))
+	}
+	return id.Resource, nil
+}
+
+// WritePacket queues the packet for all the clients without a stream
+func (s *SRTOutbound) WritePacket(pkt *server.Packet) error {
+	return s.send("", pkt)
+}
+
+// send queues the packet for all the clients of a stream, each client holds a reference until the packet is sent
+func (s *SRTOutbound) send(stream string, pkt *server.Packet) error {
+	s.channelsMux.Lock()
+	if s.options.GOPCache.Enabled {
 		select {
-		case c <- data:
+		case <-s.closed:
+			// the caches are released by Close
 		default:
+			c := s.caches[stream]
+			if c == nil {
+				c = newGOPCache(s.options.GOPCache)
+				s.caches[stream] = c
+			}
+			c.add(pkt)
+		}
+	}
+	for addr, c := range s.channels[stream] {
+		pkt.Retain()
+		select {
+		case c <- pkt:
+		default:
+			pkt.Release()
+			atomic.AddUint64(&s.dropped, 1)
 			s.logger.WithFields(log.Fields{"addr": addr}).Warn("Connection blocked")
 		}
 	}
 	s.channelsMux.Unlock()
-	return len(data), nil
+	return nil
+}
+
+// Write copies the data into a packet and sends it to all channels
+func (s *SRTOutbound) Write(data []byte) (int, error) {
+	pkt := server.NewPacket(len(data))
+	copy(pkt.Payload, data)
+	err := s.WritePacket(pkt)
+	pkt.Release()
+	return len(data), err
+}
+
+// Close stops the server and disconnects all clients
+func (s *SRTOutbound) Close() error {
+	s.channelsMux.Lock()
+	select {
+	case <-s.closed:
+		s.channelsMux.Unlock()
+		return nil
+	default:
+	}
+	close(s.closed)
+	if s.caller != nil {
+		s.caller.Close()
+	}
+	for stream, channels := range s.channels {
+		for _, c := range channels {
+			close(c)
+		}
+		delete(s.channels, stream)
+	}
+	for stream, c := range s.caches {
+		c.reset()
+		delete(s.caches, stream)
+	}
+	s.channelsMux.Unlock()
+	if s.sck != nil {
+		s.sck.Close()
+		s.removeCallback()
+	}
+	return nil
+}
+
+// StreamWriter returns the writer of a stream, the data is sent to the clients requesting the stream
+func (s *SRTOutbound) StreamWriter(stream string) (server.PacketWriter, error) {
+	if !s.options.RouteStreams {
+		return nil, errors.New("routeStreams is not set")
+	}
+	if !streamid.ValidResource(stream) {
+		return nil, errors.New("invalid stream: " + stream)
+	}
+	return &srtStreamWriter{s, stream}, nil
+}
+
+// srtStreamWriter writes the packets of a stream to the clients requesting it
+type srtStreamWriter struct {
+	outbound *SRTOutbound
+	stream   string
+}
+
+func (w *srtStreamWriter) WritePacket(pkt *server.Packet) error {
+	return w.outbound.send(w.stream, pkt)
+}
+
+// Connections returns the statistics of the connected clients
+func (s *SRTOutbound) Connections() []server.ConnectionStats {
+	return s.samplers.List()
+}
+
+// Clients returns the number of connected clients
+func (s *SRTOutbound) Clients() int {
+	s.channelsMux.Lock()
+	defer s.channelsMux.Unlock()
+	n := 0
+	for _, channels := range s.channels {
+		n += len(channels)
+	}
+	return n
+}
+
+// SRTOutboundStatus counts the clients of an SRT outbound
+type SRTOutboundStatus struct {
+	// Clients is the number of clients without a stream
+	Clients int            `json:"clients"`
+	Streams map[string]int `json:"streams,omitempty"`
+	// Connection is the state of the connection in caller and rendezvous mode
+	Connection *reconnect.Status `json:"connection,omitempty"`
+}
+
+// Status returns the number of clients of each stream
+func (s *SRTOutbound) Status() interface{} {
+	s.channelsMux.Lock()
+	defer s.channelsMux.Unlock()
+	res := SRTOutboundStatus{Clients: len(s.channels[""])}
+	if s.caller != nil {
+		status := s.caller.Status()
+		res.Connection = &status
+	}
+	for stream, channels := range s.channels {
+		if stream == "" {
+			continue
+		}
+		if res.Streams == nil {
+			res.Streams = make(map[string]int)
+		}
+		res.Streams[stream] = len(channels)
+	}
+	return res
+}
+
+// Dropped returns the number of packets dropped for blocked clients
+func (s *SRTOutbound) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
 }
@@ -0,0 +1,186 @@
+package outbound
+
+import (
+	"bytes"
+	"github.com/howyoungzhou/golive/auth"
+	"github.com/howyoungzhou/golive/inbound"
+	"github.com/howyoungzhou/golive/reconnect"
+	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/srtsock"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// freePorts returns distinct UDP ports free on the loopback interface
+func freePorts(t *testing.T, n int) []uint16 {
+	var res []uint16
+	for i := 0; i < n; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		res = append(res, uint16(conn.LocalAddr().(*net.UDPAddr).Port))
+	}
+	return res
+}
+
+// TestSRTLoopback connects an inbound and an outbound in the same process, the outbound sends a packet to the inbound
+func TestSRTLoopback(t *testing.T) {
+	backoff := reconnect.Options{Backoff: 100, MaxBackoff: 100}
+	tests := []struct {
+		name    string
+		options func(p1, p2 uint16) (inbound.SRTInboundOptions, SRTOutboundOptions)
+		// inFirst initializes the inbound before the outbound, the listener is initialized first
+		inFirst bool
+	}{
+		{
+			name: "listener outbound",
+			options: func(p1, _ uint16) (inbound.SRTInboundOptions, SRTOutboundOptions) {
+				return inbound.SRTInboundOptions{Mode: inbound.SRTModeCaller, Host: "127.0.0.1", Port: p1, Reconnect: backoff},
+					SRTOutboundOptions{Host: "127.0.0.1", Port: p1}
+			},
+		},
+		{
+			name: "listener inbound",
+			options: func(p1, _ uint16) (inbound.SRTInboundOptions, SRTOutboundOptions) {
+				return inbound.SRTInboundOptions{Host: "127.0.0.1", Port: p1},
+					SRTOutboundOptions{Mode: inbound.SRTModeCaller, Host: "127.0.0.1", Port: p1, Reconnect: backoff}
+			},
+			inFirst: true,
+		},
+		{
+			name: "stream passphrase",
+			options: func(p1, _ uint16) (inbound.SRTInboundOptions, SRTOutboundOptions) {
+				return inbound.SRTInboundOptions{
+					Mode: inbound.SRTModeCaller, Host: "127.0.0.1", Port: p1, Reconnect: backoff,
+					Options: map[string]string{"passphrase": "loopback-passphrase"},
+				}, SRTOutboundOptions{
+					Host: "127.0.0.1", Port: p1, Auth: auth.Options{Passphrases: map[string]string{"": "loopback-passphrase"}},
+				}
+			},
+		},
+		{
+			name: "rendezvous",
+			options: func(p1, p2 uint16) (inbound.SRTInboundOptions, SRTOutboundOptions) {
+				return inbound.SRTInboundOptions{
+					Mode: inbound.SRTModeRendezvous, Host: "127.0.0.1", Port: p2, LocalPort: p1, Reconnect: backoff,
+				}, SRTOutboundOptions{
+					Mode: inbound.SRTModeRendezvous, Host: "127.0.0.1", Port: p1, LocalPort: p2, Reconnect: backoff,
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports := freePorts(t, 2)
+			inOptions, outOptions := tt.options(ports[0], ports[1])
+			for _, options := range []*map[string]string{&inOptions.Options, &outOptions.Options} {
+				if *options == nil {
+					*options = make(map[string]string)
+				}
+				(*options)["transtype"] = "live"
+			}
+			inOptions.Timeout, outOptions.Timeout, outOptions.BufferSize = 1000, 1000, 16
+			in, err := inbound.NewSrtpInbound(&inOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := NewSRTOutbound(&outOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out.logger.Logger.SetOutput(ioutil.Discard)
+			components := []interface {
+				Init() error
+				Close() error
+			}{out, in}
+			if tt.inFirst {
+				components[0], components[1] = in, out
+			}
+			for _, c := range components {
+				if err := c.Init(); err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close()
+			}
+
+			payload := bytes.Repeat([]byte{0x47}, 1316)
+			received := make(chan []byte, 1)
+			go func() {
+				for {
+					pkt, err := in.ReadPacket()
+					if err == io.EOF {
+						return
+					}
+					if err != nil || len(pkt.Payload) == 0 {
+						// a failed attempt or a read timing out
+						continue
+					}
+					received <- append([]byte(nil), pkt.Payload...)
+					pkt.Release()
+					return
+				}
+			}()
+			ticker := time.NewTicker(20 * time.Millisecond)
+			defer ticker.Stop()
+			timeout := time.After(10 * time.Second)
+			for {
+				select {
+				case got := <-received:
+					if !bytes.Equal(got, payload) {
+						t.Fatalf("got %d bytes, want the %d bytes sent", len(got), len(payload))
+					}
+					return
+				case <-ticker.C:
+					// the packets written before the connection is established are lost
+					out.Write(payload)
+				case <-timeout:
+					t.Fatalf("no packet received, the inbound status is %+v", in.Status())
+				}
+			}
+		})
+	}
+}
+
+func TestSRTOutboundCheck(t *testing.T) {
+	tests := []struct {
+		sid string
+		// reason is the reason of the rejection, 0 if the client is accepted
+		reason int
+	}{
+		{sid: ""},
+		{sid: "#!::u=alice"},
+		{sid: "#!::r=live/cam1,m=request", reason: srtsock.RejectNotFound},
+		{sid: "live/cam1", reason: srtsock.RejectNotFound},
+		{sid: "#!::r=live/cam1,m=publish", reason: srtsock.RejectBadMode},
+		{sid: "#!::r=live/cam1,m=bidirectional", reason: srtsock.RejectBadMode},
+		{sid: "#!::r=../live", reason: srtsock.RejectBadRequest},
+		{sid: "#!::r=live/cam1,m=watch", reason: srtsock.RejectBadRequest},
+		{sid: `#!::r=live\cam1`, reason: srtsock.RejectBadRequest},
+	}
+	out, err := NewSRTOutbound(&SRTOutboundOptions{RouteStreams: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.logger.Logger.SetOutput(ioutil.Discard)
+	out.server, out.id = server.New(), "srt-out"
+	for _, tt := range tests {
+		err := out.check(&srtsock.Conn{Addr: "127.0.0.1:1234", StreamID: tt.sid})
+		reason := 0
+		if err != nil {
+			rejectErr, ok := err.(*srtsock.RejectError)
+			if !ok {
+				t.Errorf("check(%q) = %v, want a *srtsock.RejectError", tt.sid, err)
+				continue
+			}
+			reason = rejectErr.Reason
+		}
+		if reason != tt.reason {
+			t.Errorf("check(%q) rejects with %d, want %d", tt.sid, reason, tt.reason)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/turn"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultIdleTimeout       = 30000
+	defaultDisconnectTimeout = 5000
+)
+
+var errTooManyViewers = errors.New("too many viewers")
+
+// ViewersOptions limits the viewers of an outbound and times them out, the durations are in milliseconds
+type ViewersOptions struct {
+	// Max limits the number of viewers, 0 means no limit
+	Max int
+	// IdleTimeout disconnects the viewers not sending any RTCP packet for the duration (30000 by default), a negative
+	// timeout disables it
+	IdleTimeout int
+	// DisconnectTimeout disconnects the viewers staying disconnected for the duration (5000 by default)
+	DisconnectTimeout int
+}
+
+// viewer is the peer connection of a viewer
+type viewer struct {
+	id       string
+	addr     string
+	protocol string
+	since    time.Time
+	pc       *webrtc.PeerConnection
+	// etag is the entity tag of a WHEP session
+	etag   string
+	tracks []*viewerTrack
+	// iceServers are the TURN credentials handed to the viewer, onClose revokes them
+	iceServers []turn.ICEServer
+	onClose    func()
+	// lastActivity is the Unix time in nanoseconds of the latest RTCP packet, accessed atomically
+	lastActivity int64
+	// state is the webrtc.PeerConnectionState of the peer connection, accessed atomically
+	state     int64
+	closeOnce sync.Once
+}
+
+// viewerTrack keeps the statistics of a track sent to a viewer
+type viewerTrack struct {
+	// clockRate converts the jitter to milliseconds
+	clockRate uint32
+	stats     server.ViewerTrackStats
+	mux       sync.Mutex
+}
+
+// viewers keeps the viewers of an outbound by id, and disconnects the failed and the idle ones
+type viewers struct {
+	options ViewersOptions
+	viewers map[string]*viewer
+	// pending counts the viewers being negotiated, they count towards the limit
+	pending int
+	// clients is the number of connected viewers, accessed atomically
+	clients int64
+	closed  bool
+	mux     sync.Mutex
+	done    chan struct{}
+	logger  *log.Entry
+}
+
+func newViewers(options ViewersOptions, logger *log.Entry) *viewers {
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = defaultIdleTimeout
+	}
+	if options.DisconnectTimeout <= 0 {
+		options.DisconnectTimeout = defaultDisconnectTimeout
+	}
+	return &viewers{
+		options: options,
+		viewers: make(map[string]*viewer),
+		done:    make(chan struct{}),
+		logger:  logger,
+	}
+}
+
+func newViewer(id, addr, protocol string) *viewer {
+	now := time.Now()
+	return &viewer{
+		id:           id,
+		addr:         addr,
+		protocol:     protocol,
+		since:        now,
+		lastActivity: now.UnixNano(),
+		state:        int64(webrtc.PeerConnectionStateNew),
+	}
+}
+
+// reserve counts a viewer being negotiated, errTooManyViewers is returned if the limit is reached
+func (vs *viewers) reserve() error {
+	vs.mux.Lock()
+	defer vs.mux.Unlock()
+	if vs.options.Max > 0 && len(vs.viewers)+vs.pending >= vs.options.Max {
+		return errTooManyViewers
+	}
+	vs.pending++
+	return nil
+}
+
+// release drops the reservation of a viewer whose negotiation failed
+func (vs *viewers) release() {
+	vs.mux.Lock()
+	vs.pending--
+	vs.mux.Unlock()
+}
+
+// add registers a negotiated viewer in place of its reservation, the caller closes the viewer on error
+func (vs *viewers) add(v *viewer) error {
+	vs.mux.Lock()
+	defer vs.mux.Unlock()
+	vs.pending--
+	if vs.closed {
+		return errors.New("the outbound is closed")
+	}
+	switch webrtc.PeerConnectionState(atomic.LoadInt64(&v.state)) {
+	case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+		return errors.New("the connection is closed")
+	}
+	vs.viewers[v.id] = v
+	return nil
+}
+
+func (vs *viewers) get(id string) *viewer {
+	vs.mux.Lock()
+	defer vs.mux.Unlock()
+	return vs.viewers[id]
+}
+
+// remove forgets a viewer and closes its peer connection
+func (vs *viewers) remove(v *viewer, reason string) {
+	vs.mux.Lock()
+	_, ok := vs.viewers[v.id]
+	delete(vs.viewers, v.id)
+	vs.mux.Unlock()
+	if ok {
+		vs.logger.WithFields(log.Fields{"addr": v.addr, "viewer": v.id, "reason": reason}).Info("viewer removed")
+	}
+	// the handler of the state change calls back with the closed state, close does nothing the second time
+	go v.close()
+}
+
+// watch removes the viewer once its connection fails, is closed or stays disconnected, and keeps the number of
+// connected viewers up to date
+func (vs *viewers) watch(v *viewer) {
+	// the handler may be called concurrently
+	var connected int32
+	v.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		atomic.StoreInt64(&v.state, int64(state))
+		if state == webrtc.PeerConnectionStateConnected {
+			if atomic.CompareAndSwapInt32(&connected, 0, 1) {
+				atomic.AddInt64(&vs.clients, 1)
+			}
+		} else if atomic.CompareAndSwapInt32(&connected, 1, 0) {
+			atomic.AddInt64(&vs.clients, -1)
+		}
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			vs.remove(v, state.String())
+		case webrtc.PeerConnectionStateDisconnected:
+			time.AfterFunc(time.Duration(vs.options.DisconnectTimeout)*time.Millisecond, func() {
+				if webrtc.PeerConnectionState(atomic.LoadInt64(&v.state)) == webrtc.PeerConnectionStateDisconnected {
+					vs.remove(v, state.String())
+				}
+			})
+		}
+	})
+}
+
+// run disconnects the idle viewers until the registry is closed
+func (vs *viewers) run() {
+	if vs.options.IdleTimeout < 0 {
+		return
+	}
+	timeout := time.Duration(vs.options.IdleTimeout) * time.Millisecond
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-vs.done:
+			return
+		}
+		vs.mux.Lock()
+		var idle []*viewer
+		for _, v := range vs.viewers {
+			if time.Since(time.Unix(0, atomic.LoadInt64(&v.lastActivity))) > timeout {
+				idle = append(idle, v)
+			}
+		}
+		vs.mux.Unlock()
+		for _, v := range idle {
+			vs.remove(v, "idle")
+		}
+	}
+}
+
+// close disconnects all the viewers, the viewers being negotiated are disconnected once they are added
+func (vs *viewers) close() {
+	vs.mux.Lock()
+	if !vs.closed {
+		vs.closed = true
+		close(vs.done)
+	}
+	all := vs.viewers
+	vs.viewers = make(map[string]*viewer)
+	vs.mux.Unlock()
+	for _, v := range all {
+		v.close()
+	}
+}
+
+// kick disconnects a viewer
+func (vs *viewers) kick(id string) error {
+	v := vs.get(id)
+	if v == nil {
+		return fmt.Errorf("viewer %s %w", id, server.ErrNotFound)
+	}
+	vs.remove(v, "kicked")
+	return nil
+}
+
+// list returns the statistics of the viewers sorted by the time they connected
+func (vs *viewers) list() []server.ViewerStats {
+	vs.mux.Lock()
+	all := make([]*viewer, 0, len(vs.viewers))
+	for _, v := range vs.viewers {
+		all = append(all, v)
+	}
+	vs.mux.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].since.Before(all[j].since) })
+	res := make([]server.ViewerStats, 0, len(all))
+	for _, v := range all {
+		res = append(res, v.stats())
+	}
+	return res
+}
+
+// connected returns the number of connected viewers
+func (vs *viewers) connected() int {
+	return int(atomic.LoadInt64(&vs.clients))
+}
+
+func (v *viewer) close() {
+	v.closeOnce.Do(func() {
+		v.pc.Close()
+		if v.onClose != nil {
+			v.onClose()
+		}
+	})
+}
+
+// addTrack keeps the statistics of a track sent to the viewer
+func (v *viewer) addTrack(track localTrack) *viewerTrack {
+	t := &viewerTrack{clockRate: track.Codec().ClockRate, stats: server.ViewerTrackStats{ID: track.ID()}}
+	if t.clockRate == 0 {
+		// the defaults of the codecs registered by pion
+		t.clockRate = 90000
+		if track.Kind() == webrtc.RTPCodecTypeAudio {
+			t.clockRate = 48000
+		}
+	}
+	v.tracks = append(v.tracks, t)
+	return t
+}
+
+// readRTCP reads the RTCP packets of a track until the peer connection is closed, they must be read for the
+// interceptors, such as NACK, to process them. onKeyframe is called for the PLI and the FIR if it is not nil.
+func (v *viewer) readRTCP(sender *webrtc.RTPSender, t *viewerTrack, onKeyframe func()) {
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		atomic.StoreInt64(&v.lastActivity, time.Now().UnixNano())
+		keyframe := false
+		t.mux.Lock()
+		for _, pkt := range pkts {
+			switch p := pkt.(type) {
+			case *rtcp.ReceiverReport:
+				for _, r := range p.Reports {
+					t.stats.PacketsLost = r.TotalLost
+					t.stats.FractionLost = float64(r.FractionLost) / 256
+					t.stats.Jitter = float64(r.Jitter) / float64(t.clockRate) * 1000
+				}
+			case *rtcp.TransportLayerNack:
+				t.stats.NACKs++
+			case *rtcp.PictureLossIndication:
+				t.stats.PLIs++
+				keyframe = true
+			case *rtcp.FullIntraRequest:
+				t.stats.FIRs++
+				keyframe = true
+			}
+		}
+		t.mux.Unlock()
+		if keyframe && onKeyframe != nil {
+			onKeyframe()
+		}
+	}
+}
+
+// stats returns the statistics of the viewer, the ICE statistics are taken from the nominated candidate pair
+func (v *viewer) stats() server.ViewerStats {
+	res := server.ViewerStats{
+		ID:           v.id,
+		Addr:         v.addr,
+		Protocol:     v.protocol,
+		State:        webrtc.PeerConnectionState(atomic.LoadInt64(&v.state)).String(),
+		Since:        v.since,
+		LastActivity: time.Unix(0, atomic.LoadInt64(&v.lastActivity)),
+		Tracks:       make([]server.ViewerTrackStats, 0, len(v.tracks)),
+	}
+	for _, t := range v.tracks {
+		t.mux.Lock()
+		res.Tracks = append(res.Tracks, t.stats)
+		t.mux.Unlock()
+	}
+
+	report := v.pc.GetStats()
+	candidates := make(map[string]string)
+	var pair *webrtc.ICECandidatePairStats
+	for _, s := range report {
+		switch s := s.(type) {
+		case webrtc.TransportStats:
+			res.BytesSent = s.BytesSent
+			res.BytesReceived = s.BytesReceived
+		case webrtc.ICECandidateStats:
+			candidates[s.ID] = s.CandidateType.String()
+		case webrtc.ICECandidatePairStats:
+			if s.Nominated && s.State == webrtc.StatsICECandidatePairStateSucceeded {
+				pair = &s
+			}
+		}
+	}
+	if pair != nil {
+		res.RTT = pair.CurrentRoundTripTime * 1000
+		res.LocalCandidate = candidates[pair.LocalCandidateID]
+		res.RemoteCandidate = candidates[pair.RemoteCandidateID]
+	}
+	return res
+}
@@ -4,50 +4,192 @@ import (
 	"errors"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/howyoungzhou/golive/mpegts"
+	"github.com/howyoungzhou/golive/rtc"
 	"github.com/howyoungzhou/golive/server"
+	"github.com/howyoungzhou/golive/turn"
+	"github.com/howyoungzhou/golive/web"
+	"github.com/howyoungzhou/golive/whip"
 	"github.com/mitchellh/mapstructure"
 	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 )
 
+// maxSDPSize limits the size of the offers and of the trickled candidates
+const maxSDPSize = 64 * 1024
+
+// WebRTCInput is the format of the data written to a WebRTC outbound
+type WebRTCInput string
+
+const (
+	// WebRTCInputRTP writes RTP packets to each track through "<id>:<track id>", it is the default
+	WebRTCInputRTP WebRTCInput = "rtp"
+	// WebRTCInputMPEGTS writes MPEG-TS to the outbound itself, the H.264 and the Opus streams are demuxed and sent
+	// to the tracks of the same codec
+	WebRTCInputMPEGTS WebRTCInput = "mpegts"
+)
+
+// maxSampleDuration bounds the duration of a sample, a longer one is a discontinuity of the timestamps
+const maxSampleDuration = time.Second
+
+// defaultSampleDurations are used when the duration of a sample is unknown
+var defaultSampleDurations = map[mpegts.Codec]time.Duration{
+	mpegts.CodecH264: time.Second / 30,
+	mpegts.CodecOpus: 20 * time.Millisecond,
+}
+
+// localTrack is a track sent to the viewers, the static tracks of pion implement it
+type localTrack interface {
+	webrtc.TrackLocal
+	Codec() webrtc.RTPCodecCapability
+}
+
 type WebRTCOutboundOptions struct {
 	WebRTC webrtc.Configuration
+	// ICE tunes the candidates and the ports of the peer connections, e.g. to serve them all on a single port
+	ICE    rtc.Options
 	Tracks []struct {
 		CodecCapability webrtc.RTPCodecCapability
 		ID              string
 		StreamID        string
 	}
+	// SDPServer receives the offers on RootPath. An offer sent as application/sdp is answered with WHEP, the
+	// sessions are then served under "<RootPath>/<session id>". An offer sent as JSON is answered with JSON, unless
+	// DisableJSON is set.
+	// The routes are mounted on the shared HTTP server of the config if ListenAddress is empty.
 	SDPServer struct {
 		CORS          cors.Config
 		ListenAddress string
 		RootPath      string
+		DisableJSON   bool
 	}
+	Viewers ViewersOptions
+	// Input is the format of the data written to the outbound, see WebRTCInput
+	Input WebRTCInput
+	// GOPCache replays the latest group of pictures of the H.264 tracks to the new viewers
+	GOPCache GOPCacheOptions
+	// KeyframeRequests handles the PLI and FIR sent by the viewers for the video tracks
+	KeyframeRequests KeyframeRequestOptions
+	// TURN hands out credentials of the TURN server of the config to each viewer along with the answer
+	TURN bool
 }
 
 // WebRTCOutbound implements WebRTC protocol for output
 type WebRTCOutbound struct {
 	options *WebRTCOutboundOptions
-	tracks  []*webrtc.TrackLocalStaticRTP
+	tracks  []localTrack
+	viewers *viewers
 	logger  *log.Entry
+	srv     *http.Server
+	// unmount removes the routes from the shared HTTP server, nil if they are served by srv
+	unmount func()
+	api     *rtc.API
+	// demuxer and samples are only set for the MPEG-TS input, samples are the tracks by codec
+	demuxer *mpegts.Demuxer
+	samples map[mpegts.Codec]*sampleTrack
+	// upstream forwards the keyframe requests of the viewers, nil if there is no upstream
+	upstream *throttle
+	// server provides the TURN server, which is set once started if the TURN option is set
+	server *server.Server
+	turn   *turn.Server
+}
+
+// jsonAnswer is the answer of the JSON signaling, along with the TURN server the viewer may relay through
+type jsonAnswer struct {
+	webrtc.SessionDescription
+	ICEServers []turn.ICEServer `json:"iceServers,omitempty"`
+}
+
+// sampleWriter is a track accepting samples
+type sampleWriter interface {
+	WriteSample(sample media.Sample) error
+}
+
+// sampleTrack writes the demuxed frames of a codec to a track
+type sampleTrack struct {
+	track sampleWriter
+	// duration is the latest valid duration of a sample
+	duration time.Duration
 }
 
 // NewWebRTCOutbound creates a new instance of WebRTCOutbound
 func NewWebRTCOutbound(options *WebRTCOutboundOptions) (*WebRTCOutbound, error) {
+	logger := log.New().WithFields(log.Fields{"module": "WebRTCOutbound"})
 	res := &WebRTCOutbound{
 		options: options,
-		logger:  log.New().WithFields(log.Fields{"module": "WebRTCOutbound"}),
+		viewers: newViewers(options.Viewers, logger),
+		logger:  logger,
 	}
-	for _, t := range options.Tracks {
-		track, err := webrtc.NewTrackLocalStaticRTP(t.CodecCapability, t.ID, t.StreamID)
-		if err != nil {
-			return nil, err
+	switch options.Input {
+	case "", WebRTCInputRTP:
+		for _, t := range options.Tracks {
+			if res.cached(t.CodecCapability) {
+				res.tracks = append(res.tracks, newGOPTrack(t.CodecCapability, t.ID, t.StreamID, options.GOPCache))
+				continue
+			}
+			track, err := webrtc.NewTrackLocalStaticRTP(t.CodecCapability, t.ID, t.StreamID)
+			if err != nil {
+				return nil, err
+			}
+			res.tracks = append(res.tracks, track)
 		}
-		res.tracks = append(res.tracks, track)
+	case WebRTCInputMPEGTS:
+		res.samples = make(map[mpegts.Codec]*sampleTrack)
+		for _, t := range options.Tracks {
+			codec, err := sampleCodec(t.CodecCapability.MimeType)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := res.samples[codec]; ok {
+				return nil, errors.New("more than one track of codec " + string(codec))
+			}
+			var track localTrack
+			if res.cached(t.CodecCapability) {
+				track = newGOPTrack(t.CodecCapability, t.ID, t.StreamID, options.GOPCache)
+			} else if track, err = webrtc.NewTrackLocalStaticSample(t.CodecCapability, t.ID, t.StreamID); err != nil {
+				return nil, err
+			}
+			res.tracks = append(res.tracks, track)
+			res.samples[codec] = &sampleTrack{track: track.(sampleWriter), duration: defaultSampleDurations[codec]}
+		}
+		res.demuxer = mpegts.NewDemuxer(res.writeFrame)
+		res.demuxer.OnStream(func(pid uint16, streamType byte, codec mpegts.Codec) {
+			entry := res.logger.WithFields(log.Fields{"pid": pid, "streamType": streamType})
+			if _, ok := res.samples[codec]; !ok {
+				entry.Warn("stream ignored, there is no track of its codec")
+				return
+			}
+			entry.WithField("codec", codec).Info("stream found")
+		})
+	default:
+		return nil, errors.New("unknown input " + string(options.Input))
 	}
 	return res, nil
 }
 
+// cached reports whether a track replays the latest group of pictures, only H.264 tracks do
+func (o *WebRTCOutbound) cached(codec webrtc.RTPCodecCapability) bool {
+	return o.options.GOPCache.Enabled && strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264)
+}
+
+// sampleCodec returns the codec demuxed for a track from its MIME type
+func sampleCodec(mimeType string) (mpegts.Codec, error) {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return mpegts.CodecH264, nil
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return mpegts.CodecOpus, nil
+	}
+	return "", errors.New("codec " + mimeType + " can not be demuxed from MPEG-TS, only H.264 and Opus are supported")
+}
+
 // RegisterWebRTC registers a new instance to the server, create a new sub-outbound for each track
 func RegisterWebRTC(server *server.Server, id string, options map[string]interface{}) (server.Outbound, error) {
 	opt := &WebRTCOutboundOptions{}
@@ -58,110 +200,367 @@ func RegisterWebRTC(server *server.Server, id string, options map[string]interfa
 	if err != nil {
 		return nil, err
 	}
-	for _, t := range res.tracks {
-		server.AddWriter(id+":"+t.ID(), &WebRtcTrackOutbound{t})
+	res.server = server
+	if res.demuxer == nil {
+		for _, t := range res.tracks {
+			server.AddWriter(id+":"+t.ID(), &WebRtcTrackOutbound{t.(io.Writer)})
+		}
+	}
+	if upstream := opt.KeyframeRequests.Upstream; upstream != "" {
+		res.upstream = newThrottle(opt.KeyframeRequests.interval(), func() {
+			logger := res.logger.WithField("upstream", upstream)
+			if err := server.RequestKeyframe(upstream); err != nil {
+				logger.WithError(err).Warn("failed to request a keyframe")
+				return
+			}
+			logger.Debug("keyframe requested")
+		})
 	}
 	return res, nil
 }
 
+// handleSDPRequest answers an offer with WHEP or with the legacy JSON signaling, depending on its content type
 func (o *WebRTCOutbound) handleSDPRequest(c *gin.Context) {
+	if c.ContentType() == whip.ContentTypeSDP {
+		o.handleWHEPOffer(c)
+		return
+	}
+	if o.options.SDPServer.DisableJSON {
+		c.String(http.StatusUnsupportedMediaType, "the offer must be sent as "+whip.ContentTypeSDP)
+		return
+	}
+	o.handleJSONOffer(c)
+}
+
+// handleJSONOffer answers an offer sent as a JSON session description, the answer is returned once the ICE candidates
+// are gathered
+func (o *WebRTCOutbound) handleJSONOffer(c *gin.Context) {
+	logger := o.logger.WithField("addr", c.Request.RemoteAddr)
 	offer := webrtc.SessionDescription{}
 	err := c.Bind(&offer)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
-		o.logger.WithField("addr", c.Request.RemoteAddr).Info("malformed SDP")
+		logger.Info("malformed SDP")
 		return
 	}
-	peerConnectionConfig := o.options.WebRTC
-	// Create a new PeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(peerConnectionConfig)
+	v := newViewer(whip.NewSessionID(), c.Request.RemoteAddr, "json")
+	status, err := o.answer(v, offer)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		o.logger.WithField("addr", c.Request.RemoteAddr).Error("failed to create peer connection")
+		c.AbortWithError(status, err)
+		logger.WithError(err).Info("failed to answer")
 		return
 	}
+	c.JSON(http.StatusOK, jsonAnswer{SessionDescription: *v.pc.LocalDescription(), ICEServers: v.iceServers})
+	logger.WithField("viewer", v.id).Info("connection established")
+}
+
+// handleWHEPOffer answers an offer sent as application/sdp and creates a WHEP session, the viewer may trickle its
+// candidates to the session
+func (o *WebRTCOutbound) handleWHEPOffer(c *gin.Context) {
+	logger := o.logger.WithField("addr", c.Request.RemoteAddr)
+	sdp, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxSDPSize))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	v := newViewer(whip.NewSessionID(), c.Request.RemoteAddr, "whep")
+	status, err := o.answer(v, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(sdp)})
+	if err != nil {
+		c.String(status, err.Error())
+		logger.WithError(err).Info("failed to answer")
+		return
+	}
+	answer := v.pc.LocalDescription().SDP
+	c.Header("Location", path.Join("/", o.options.SDPServer.RootPath, v.id))
+	c.Header("ETag", v.etag)
+	c.Header("Accept-Patch", whip.ContentTypeTrickle)
+	for _, s := range v.iceServers {
+		for _, url := range s.URLs {
+			c.Writer.Header().Add("Link", whip.ICEServerLink(url, s.Username, s.Credential))
+		}
+	}
+	c.Data(http.StatusCreated, whip.ContentTypeSDP, []byte(answer))
+	logger.WithField("viewer", v.id).Info("connection established")
+}
+
+// answer creates the peer connection of a viewer sending the tracks for an offer, the answer is set once the ICE
+// candidates are gathered and the viewer is registered. The HTTP status to report is returned along with the error,
+// the peer connection is then closed.
+func (o *WebRTCOutbound) answer(v *viewer, offer webrtc.SessionDescription) (int, error) {
+	if err := o.viewers.reserve(); err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	status, err := o.negotiate(v, offer)
+	if err != nil {
+		o.viewers.release()
+	} else if err = o.viewers.add(v); err != nil {
+		status = http.StatusServiceUnavailable
+	}
+	if err != nil {
+		if v.pc != nil {
+			v.close()
+		}
+		return status, err
+	}
+	return http.StatusOK, nil
+}
+
+func (o *WebRTCOutbound) negotiate(v *viewer, offer webrtc.SessionDescription) (int, error) {
+	config := o.options.WebRTC
+	if o.turn != nil {
+		// the peer connection relays through the TURN server too, so the answer holds a relayed candidate reachable
+		// by the viewers behind a symmetric NAT. The credentials are revoked once the viewer is closed.
+		credentials := o.turn.Credentials(v.id)
+		v.iceServers = []turn.ICEServer{credentials}
+		v.onClose = func() { o.turn.Revoke(v.id) }
+		config.ICEServers = append(append([]webrtc.ICEServer(nil), config.ICEServers...), webrtc.ICEServer{
+			URLs:       o.turn.LocalURLs(),
+			Username:   credentials.Username,
+			Credential: credentials.Credential,
+		})
+	}
+	peerConnection, err := o.api.NewPeerConnection(config)
+	if err != nil {
+		if v.onClose != nil {
+			v.onClose()
+		}
+		return http.StatusInternalServerError, err
+	}
+	v.pc = peerConnection
+	o.viewers.watch(v)
 
 	for _, track := range o.tracks {
 		rtpSender, err := peerConnection.AddTrack(track)
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			o.logger.WithField("addr", c.Request.RemoteAddr).Info("failed to add track")
-			return
+			return http.StatusInternalServerError, err
 		}
-
 		// Read incoming RTCP packets
 		// Before these packets are returned they are processed by interceptors. For things
 		// like NACK this needs to be called.
-		go func() {
-			rtcpBuf := make([]byte, 1500)
-			for {
-				if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-					return
-				}
-			}
-		}()
+		go v.readRTCP(rtpSender, v.addTrack(track), o.keyframeHandler(track, rtpSender))
 	}
-	// Set the remote SessionDescription
-	err = peerConnection.SetRemoteDescription(offer)
-	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
-		o.logger.WithField("addr", c.Request.RemoteAddr).Info("failed to set remote SDP")
-		return
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return http.StatusBadRequest, err
 	}
-
-	// Create answer
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		o.logger.WithField("addr", c.Request.RemoteAddr).Info("failed to create answer SDP")
-		return
+		return http.StatusInternalServerError, err
 	}
 
 	// Create channel that is blocked until ICE Gathering is complete
 	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
 
 	// Sets the LocalDescription, and starts our UDP listeners
-	err = peerConnection.SetLocalDescription(answer)
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	<-gatherComplete
+	v.etag = whip.ETag(peerConnection.LocalDescription().SDP)
+	return http.StatusOK, nil
+}
+
+// keyframeHandler returns the handler of the keyframe requests of a viewer for a track, nil if they are ignored. The
+// GOP cache is replayed to the viewer, and the request is forwarded upstream along with the ones of the other viewers.
+func (o *WebRTCOutbound) keyframeHandler(track localTrack, sender *webrtc.RTPSender) func() {
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		return nil
+	}
+	var replay *throttle
+	if t, ok := track.(*gopTrack); ok {
+		ssrc := sender.GetParameters().Encodings[0].SSRC
+		replay = newThrottle(o.options.KeyframeRequests.interval(), func() { t.replayTo(ssrc) })
+	}
+	if replay == nil && o.upstream == nil {
+		return nil
+	}
+	return func() {
+		if replay != nil {
+			replay.call()
+		}
+		if o.upstream != nil {
+			o.upstream.call()
+		}
+	}
+}
+
+// handleTrickle adds the candidates trickled by a WHEP viewer, the If-Match header must match the ETag of the session
+func (o *WebRTCOutbound) handleTrickle(c *gin.Context) {
+	v := o.viewers.get(c.Param("session"))
+	if v == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if c.ContentType() != whip.ContentTypeTrickle {
+		c.String(http.StatusUnsupportedMediaType, "the candidates must be sent as "+whip.ContentTypeTrickle)
+		return
+	}
+	if !whip.MatchETag(c.Request, v.etag) {
+		c.Status(http.StatusPreconditionFailed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxSDPSize))
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		o.logger.WithField("addr", c.Request.RemoteAddr).Info("failed to set local SDP")
+		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
+	frag, err := whip.ParseFrag(string(body))
+	if err == nil {
+		err = whip.Trickle(v.pc, frag)
+	}
+	if err == whip.ErrICERestart {
+		c.String(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
 
-	<-gatherComplete
+// handleDelete closes a WHEP session
+func (o *WebRTCOutbound) handleDelete(c *gin.Context) {
+	v := o.viewers.get(c.Param("session"))
+	if v == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	o.viewers.remove(v, "deleted")
+	c.Status(http.StatusOK)
+}
 
-	// Get the LocalDescription and take it to base64 so we can paste in browser
-	c.JSON(http.StatusOK, peerConnection.LocalDescription())
-	o.logger.WithField("addr", c.Request.RemoteAddr).Info("connection established")
+// routes registers the offers and the WHEP sessions under the root path
+func (o *WebRTCOutbound) routes(r gin.IRoutes) {
+	r.POST(o.options.SDPServer.RootPath, o.handleSDPRequest)
+	r.PATCH(path.Join(o.options.SDPServer.RootPath, ":session"), o.handleTrickle)
+	r.DELETE(path.Join(o.options.SDPServer.RootPath, ":session"), o.handleDelete)
 }
 
 func (o *WebRTCOutbound) serveHTTP() {
-	r := gin.Default()
-	r.Use(cors.New(o.options.SDPServer.CORS))
-	r.POST(o.options.SDPServer.RootPath, o.handleSDPRequest)
 	o.logger.WithField("addr", o.options.SDPServer.ListenAddress).Info("SDP server is listening")
-	err := r.Run(o.options.SDPServer.ListenAddress)
+	err := o.srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		o.logger.WithField("addr", o.options.SDPServer.ListenAddress).Info("SDP server closed")
+		return
+	}
 	o.logger.WithField("addr", o.options.SDPServer.ListenAddress).WithError(err).Error("SDP server ended with error")
 }
 
-// Init runs the HTTP SDP server
+// Clients returns the number of connected peers
+func (o *WebRTCOutbound) Clients() int {
+	return o.viewers.connected()
+}
+
+// Viewers returns the statistics of the viewers
+func (o *WebRTCOutbound) Viewers() []server.ViewerStats {
+	return o.viewers.list()
+}
+
+// Kick disconnects a viewer
+func (o *WebRTCOutbound) Kick(id string) error {
+	return o.viewers.kick(id)
+}
+
+// Init runs the HTTP SDP server, or mounts its routes on the shared HTTP server
 func (o *WebRTCOutbound) Init() error {
-	go o.serveHTTP()
+	if o.options.TURN {
+		if o.server == nil {
+			return errors.New("no TURN server is configured")
+		}
+		service, err := o.server.Service(turn.ServiceName)
+		if err != nil {
+			return err
+		}
+		o.turn = service.(*turn.Server)
+	}
+	api, err := rtc.New(o.options.ICE, o.logger)
+	if err != nil {
+		return err
+	}
+	o.api = api
+	r := gin.Default()
+	if o.options.SDPServer.ListenAddress != "" {
+		r.Use(cors.New(o.options.SDPServer.CORS))
+	} else {
+		// the shared HTTP server may handle CORS itself
+		middleware, err := web.CORS(o.options.SDPServer.CORS)
+		if err != nil {
+			o.api.Close()
+			return err
+		}
+		if middleware != nil {
+			r.Use(middleware)
+		}
+	}
+	o.routes(r)
+	if o.options.SDPServer.ListenAddress == "" {
+		shared, err := web.Shared(o.server)
+		if err == nil {
+			o.unmount, err = shared.Mount(o.options.SDPServer.RootPath, r)
+		}
+		if err != nil {
+			o.api.Close()
+			return err
+		}
+		o.logger.WithField("path", o.options.SDPServer.RootPath).Info("SDP server is mounted")
+	} else {
+		o.srv = &http.Server{Addr: o.options.SDPServer.ListenAddress, Handler: r}
+		go o.serveHTTP()
+	}
+	go o.viewers.run()
 	return nil
 }
 
+// Close stops the HTTP SDP server and disconnects the viewers
+func (o *WebRTCOutbound) Close() error {
+	o.viewers.close()
+	if o.api != nil {
+		o.api.Close()
+	}
+	if o.unmount != nil {
+		o.unmount()
+	}
+	if o.srv == nil {
+		return nil
+	}
+	return o.srv.Close()
+}
+
+// Write demuxes MPEG-TS to the tracks, the outbound can only be written directly with the MPEG-TS input
 func (o *WebRTCOutbound) Write(p []byte) (int, error) {
-	return 0, errors.New("can not write directly to a WebRTC outbound, change \"out\" to \"[outbound id]:[track id]\" instead")
+	if o.demuxer == nil {
+		return 0, errors.New("can not write directly to a WebRTC outbound, change \"out\" to \"[outbound id]:[track id]\" or set \"input\" to \"mpegts\" instead")
+	}
+	return o.demuxer.Write(p)
+}
+
+// writeFrame writes a demuxed frame to the track of its codec, the duration of the sample sets the timestamp of the
+// next one
+func (o *WebRTCOutbound) writeFrame(f *mpegts.Frame) {
+	t, ok := o.samples[f.Codec]
+	if !ok {
+		return
+	}
+	if f.Duration > 0 && f.Duration <= maxSampleDuration {
+		t.duration = f.Duration
+	}
+	if err := t.track.WriteSample(media.Sample{Data: f.Data, Duration: t.duration}); err != nil {
+		o.logger.WithError(err).Debug("failed to write a sample")
+	}
 }
 
 type WebRtcTrackOutbound struct {
-	track *webrtc.TrackLocalStaticRTP
+	track io.Writer
 }
 
 func (o *WebRtcTrackOutbound) Init() error {
 	return nil
 }
 
+func (o *WebRtcTrackOutbound) Close() error {
+	return nil
+}
+
 // Write writes packet to the track
 func (o *WebRtcTrackOutbound) Write(p []byte) (int, error) {
 	return o.track.Write(p)
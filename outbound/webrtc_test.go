@@ -0,0 +1,248 @@
+package outbound
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/howyoungzhou/golive/rtc"
+	"github.com/howyoungzhou/golive/whip"
+	"github.com/pion/webrtc/v3"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestWebRTCOutbound returns an outbound sending an H.264 track, without any SDP server
+func newTestWebRTCOutbound(t *testing.T, options *WebRTCOutboundOptions) *WebRTCOutbound {
+	options.Tracks = append(options.Tracks, struct {
+		CodecCapability webrtc.RTPCodecCapability
+		ID              string
+		StreamID        string
+	}{webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}, "video", "live"})
+	o, err := NewWebRTCOutbound(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.logger.Logger.SetOutput(ioutil.Discard)
+	if o.api, err = rtc.New(options.ICE, log.NewEntry(o.logger.Logger)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+	return o
+}
+
+// newOffer returns the offer of a viewer receiving the video, once its candidates are gathered
+func newOffer(t *testing.T) webrtc.SessionDescription {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	return *pc.LocalDescription()
+}
+
+func TestAnswerFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		closed bool
+		offer  func(t *testing.T) webrtc.SessionDescription
+		status int
+	}{
+		{
+			name: "malformed offer",
+			offer: func(*testing.T) webrtc.SessionDescription {
+				return webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0\r\n"}
+			},
+			status: http.StatusBadRequest,
+		},
+		{name: "closed outbound", closed: true, offer: newOffer, status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestWebRTCOutbound(t, &WebRTCOutboundOptions{})
+			if tt.closed {
+				o.viewers.close()
+			}
+			v := newViewer("viewer", "127.0.0.1:1234", "whep")
+			status, err := o.answer(v, tt.offer(t))
+			if err == nil || status != tt.status {
+				t.Fatalf("got %d with the error %v, want %d with an error", status, err, tt.status)
+			}
+			if v.pc == nil {
+				t.Fatal("no peer connection was created")
+			}
+			if state := v.pc.ConnectionState(); state != webrtc.PeerConnectionStateClosed {
+				t.Errorf("got the peer connection %s, want it closed", state)
+			}
+			// the viewer may be removed again by the handler of the closed state
+			o.viewers.mux.Lock()
+			pending, n := o.viewers.pending, len(o.viewers.viewers)
+			o.viewers.mux.Unlock()
+			if pending != 0 || n != 0 {
+				t.Errorf("got %d pending and %d viewers, want none", pending, n)
+			}
+		})
+	}
+}
+
+// serve sends a request to the routes of an outbound
+func serve(o *WebRTCOutbound, method, url, contentType, ifMatch, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	o.routes(r)
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestWHEPOffer(t *testing.T) {
+	o := newTestWebRTCOutbound(t, &WebRTCOutboundOptions{})
+	o.options.SDPServer.RootPath = "/whep"
+	offer := newOffer(t)
+	res := serve(o, http.MethodPost, "/whep", whip.ContentTypeSDP, "", offer.SDP)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("got %d for the offer, want %d: %s", res.Code, http.StatusCreated, res.Body)
+	}
+	if ct := res.Header().Get("Content-Type"); ct != whip.ContentTypeSDP {
+		t.Errorf("got the answer as %s, want %s", ct, whip.ContentTypeSDP)
+	}
+	answer := res.Body.String()
+	if ufrag := whip.ICEUfrag(answer); ufrag == "" || res.Header().Get("ETag") != `"`+ufrag+`"` {
+		t.Errorf("got the ETag %s for the answer with the ufrag %q", res.Header().Get("ETag"), ufrag)
+	}
+	if res.Header().Get("Accept-Patch") != whip.ContentTypeTrickle {
+		t.Errorf("got Accept-Patch %q, want %q", res.Header().Get("Accept-Patch"), whip.ContentTypeTrickle)
+	}
+	location := res.Header().Get("Location")
+	v := o.viewers.get(strings.TrimPrefix(location, "/whep/"))
+	if !strings.HasPrefix(location, "/whep/") || v == nil {
+		t.Fatalf("got the location %q, want the session of the viewer", location)
+	}
+	if v.protocol != "whep" || v.pc.LocalDescription().SDP != answer {
+		t.Fatalf("got a %s viewer with another answer than the one sent", v.protocol)
+	}
+}
+
+func TestJSONOffer(t *testing.T) {
+	offer := newOffer(t)
+	body, err := json.Marshal(offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		disableJSON bool
+		contentType string
+		body        string
+		status      int
+	}{
+		{name: "answered", contentType: "application/json", body: string(body), status: http.StatusOK},
+		{name: "malformed", contentType: "application/json", body: "{", status: http.StatusBadRequest},
+		{name: "disabled", disableJSON: true, contentType: "application/json", body: string(body), status: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestWebRTCOutbound(t, &WebRTCOutboundOptions{})
+			o.options.SDPServer.RootPath = "/sdp"
+			o.options.SDPServer.DisableJSON = tt.disableJSON
+			res := serve(o, http.MethodPost, "/sdp", tt.contentType, "", tt.body)
+			if res.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", res.Code, tt.status, res.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var answer jsonAnswer
+			if err := json.Unmarshal(res.Body.Bytes(), &answer); err != nil {
+				t.Fatal(err)
+			}
+			if answer.Type != webrtc.SDPTypeAnswer || whip.ICEUfrag(answer.SDP) == "" {
+				t.Fatalf("got the answer %+v", answer.SessionDescription)
+			}
+			if n := len(o.viewers.list()); n != 1 {
+				t.Fatalf("got %d viewers, want 1", n)
+			}
+		})
+	}
+}
+
+func TestWHEPSession(t *testing.T) {
+	o := newTestWebRTCOutbound(t, &WebRTCOutboundOptions{})
+	o.options.SDPServer.RootPath = "/whep"
+	offer := newOffer(t)
+	res := serve(o, http.MethodPost, "/whep", whip.ContentTypeSDP, "", offer.SDP)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("got %d for the offer, want %d", res.Code, http.StatusCreated)
+	}
+	session, etag := res.Header().Get("Location"), res.Header().Get("ETag")
+	v := o.viewers.get(strings.TrimPrefix(session, "/whep/"))
+	candidate := func(ufrag string) string {
+		return "a=ice-ufrag:" + ufrag + "\r\na=ice-pwd:password\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\na=mid:0\r\n" +
+			"a=candidate:1 1 udp 2130706431 192.0.2.1 50000 typ host\r\n"
+	}
+	ufrag := whip.ICEUfrag(offer.SDP)
+
+	tests := []struct {
+		name        string
+		session     string
+		contentType string
+		ifMatch     string
+		body        string
+		status      int
+	}{
+		{name: "candidate", session: session, contentType: whip.ContentTypeTrickle, ifMatch: etag, body: candidate(ufrag), status: http.StatusNoContent},
+		{name: "any entity", session: session, contentType: whip.ContentTypeTrickle, ifMatch: "*", body: candidate(ufrag), status: http.StatusNoContent},
+		{name: "end of candidates", session: session, contentType: whip.ContentTypeTrickle, body: "a=end-of-candidates\r\n", status: http.StatusNoContent},
+		{name: "unknown session", session: "/whep/unknown", contentType: whip.ContentTypeTrickle, body: candidate(ufrag), status: http.StatusNotFound},
+		{name: "content type", session: session, contentType: whip.ContentTypeSDP, body: candidate(ufrag), status: http.StatusUnsupportedMediaType},
+		{name: "entity mismatch", session: session, contentType: whip.ContentTypeTrickle, ifMatch: `"other"`, body: candidate(ufrag), status: http.StatusPreconditionFailed},
+		{name: "ICE restart", session: session, contentType: whip.ContentTypeTrickle, body: candidate("restarted"), status: http.StatusUnprocessableEntity},
+		{name: "malformed", session: session, contentType: whip.ContentTypeTrickle, body: "candidate\r\n", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := serve(o, http.MethodPatch, tt.session, tt.contentType, tt.ifMatch, tt.body); res.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", res.Code, tt.status, res.Body)
+			}
+		})
+	}
+
+	if res := serve(o, http.MethodDelete, session, "", "", ""); res.Code != http.StatusOK {
+		t.Fatalf("got %d for the deletion, want %d", res.Code, http.StatusOK)
+	}
+	if o.viewers.get(v.id) != nil {
+		t.Fatal("the viewer is kept once its session is deleted")
+	}
+	for deadline := time.Now().Add(time.Second); v.pc.ConnectionState() != webrtc.PeerConnectionStateClosed; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the peer connection is not closed once the session is deleted")
+		}
+	}
+	if res := serve(o, http.MethodDelete, session, "", "", ""); res.Code != http.StatusNotFound {
+		t.Fatalf("got %d for the deleted session, want %d", res.Code, http.StatusNotFound)
+	}
+}
@@ -2,39 +2,83 @@ package process
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"github.com/howyoungzhou/golive/server"
 	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"os"
 	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type ExecProcessOptions struct {
 	Path string
 	Args []string
+	// StopTimeout is the time in milliseconds to wait for the process to exit after its stdin is closed,
+	// the process is killed afterwards
+	StopTimeout int
+	// KeyframeRequest is how the process is asked for a keyframe, e.g. by an outbound forwarding the requests of its
+	// viewers
+	KeyframeRequest ExecKeyframeRequest
 }
 
+// ExecKeyframeRequest asks a process for a keyframe with a signal, a line written to its stdin, or both
+type ExecKeyframeRequest struct {
+	// Signal is the name of the signal sent to the process, e.g. "SIGUSR1"
+	Signal string
+	// Stdin is written to the stdin of the process followed by a newline, the stdin must not be written by a pipe
+	Stdin string
+}
+
+// signals are the signals a process can be asked for a keyframe with
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+const defaultStopTimeout = 5000
+
 // ExecProcess run a process
 type ExecProcess struct {
 	options *ExecProcessOptions
 	cmd     *exec.Cmd
 	stdin   io.WriteCloser
-	stdout  io.ReadCloser
-	logger  *log.Entry
+	// stdinMux keeps the keyframe requests from interleaving with the data written to the stdin
+	stdinMux sync.Mutex
+	stdout   io.ReadCloser
+	logger   *log.Entry
+	// stderrDone is closed once the stderr reaches EOF, stdoutDone once the stdout does
+	stderrDone chan struct{}
+	stdoutDone chan struct{}
+	stdoutEOF  sync.Once
 }
 
 // NewExecProcess creates a new instance of ExecProcess
 func NewExecProcess(options *ExecProcessOptions) (*ExecProcess, error) {
+	if s := options.KeyframeRequest.Signal; s != "" {
+		if _, ok := signals[s]; !ok {
+			return nil, errors.New("unsupported keyframe request signal: " + s)
+		}
+	}
 	return &ExecProcess{
-		options: options,
-		cmd:     exec.Command(options.Path, options.Args...),
-		logger:  log.New().WithFields(log.Fields{"module": "ExecProcess"}),
+		options:    options,
+		cmd:        exec.Command(options.Path, options.Args...),
+		logger:     log.New().WithFields(log.Fields{"module": "ExecProcess"}),
+		stderrDone: make(chan struct{}),
+		stdoutDone: make(chan struct{}),
 	}, nil
 }
 
 // RegisterExecProcess registers a new instance to the server
 func RegisterExecProcess(server *server.Server, id string, options map[string]interface{}) (server.Process, error) {
-	opt := &ExecProcessOptions{}
+	opt := &ExecProcessOptions{StopTimeout: defaultStopTimeout}
 	if err := mapstructure.Decode(options, opt); err != nil {
 		return nil, err
 	}
@@ -66,6 +110,7 @@ func (e *ExecProcess) Init() error {
 	e.logger.WithFields(log.Fields{"path": e.cmd.Path, "args": e.cmd.Args}).Info("process started")
 
 	go func() {
+		defer close(e.stderrDone)
 		in := bufio.NewScanner(stderr)
 
 		for in.Scan() {
@@ -81,10 +126,75 @@ func (e *ExecProcess) Init() error {
 
 // Read pipes the data from the stdout
 func (e *ExecProcess) Read(p []byte) (n int, err error) {
-	return e.stdout.Read(p)
+	n, err = e.stdout.Read(p)
+	if err == io.EOF {
+		e.stdoutEOF.Do(func() { close(e.stdoutDone) })
+	}
+	return n, err
 }
 
 // Write pipes the data to the stdin
 func (e *ExecProcess) Write(p []byte) (n int, err error) {
+	e.stdinMux.Lock()
+	defer e.stdinMux.Unlock()
 	return e.stdin.Write(p)
 }
+
+// RequestKeyframe asks the process for a keyframe as set by the KeyframeRequest option, the track is ignored
+func (e *ExecProcess) RequestKeyframe(track string) error {
+	req := e.options.KeyframeRequest
+	if req.Signal == "" && req.Stdin == "" {
+		return errors.New("keyframeRequest is not set")
+	}
+	if e.cmd.Process == nil {
+		return errors.New("the process is not started")
+	}
+	if req.Signal != "" {
+		if err := e.cmd.Process.Signal(signals[req.Signal]); err != nil {
+			return err
+		}
+	}
+	if req.Stdin != "" {
+		e.stdinMux.Lock()
+		defer e.stdinMux.Unlock()
+		if _, err := io.WriteString(e.stdin, req.Stdin+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the stdin and waits for the process to exit, the process is killed if it does not exit in time
+func (e *ExecProcess) Close() error {
+	if e.cmd.Process == nil {
+		// the process is not started
+		return nil
+	}
+	e.stdin.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(e.options.StopTimeout)*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		// Wait closes the pipes, so all the reads from stderr and stdout must be completed first. The stdout is read by
+		// a pipe, which may have stopped reading, so it is only waited for until the timeout.
+		<-e.stderrDone
+		select {
+		case <-e.stdoutDone:
+		case <-ctx.Done():
+		}
+		done <- e.cmd.Wait()
+	}()
+	select {
+	case <-done:
+		e.logger.WithField("state", e.cmd.ProcessState.String()).Info("process exited")
+		return nil
+	case <-ctx.Done():
+		e.logger.Warn("process did not exit in time, killing")
+		// the process may have exited while its stdout was waited for, and been waited for since the timeout
+		if err := e.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return err
+		}
+		<-done
+		return nil
+	}
+}
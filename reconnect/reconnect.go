@@ -0,0 +1,166 @@
+// Package reconnect keeps an outgoing connection up, reconnecting with exponential backoff
+package reconnect
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// State is the state of the connection
+type State string
+
+const (
+	StateConnecting State = "connecting"
+	StateConnected  State = "connected"
+	// StateWaiting is the state between a failed attempt and the next one
+	StateWaiting State = "waiting"
+	StateClosed  State = "closed"
+)
+
+const (
+	defaultBackoff    = 1000
+	defaultMaxBackoff = 30000
+)
+
+// Options of the reconnect loop, the delays are in milliseconds
+type Options struct {
+	// Backoff is the initial delay between attempts, doubled after each failed attempt (1000 by default)
+	Backoff int
+	// MaxBackoff is the upper bound of the delay between attempts (30000 by default)
+	MaxBackoff int
+}
+
+// Status describes the connection
+type Status struct {
+	State State  `json:"state"`
+	Addr  string `json:"addr"`
+	// Since is the time the connection entered the state
+	Since time.Time `json:"since"`
+	// Attempts counts the failed attempts since the last connection
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"lastError,omitempty"`
+	// Connects counts the successful connections
+	Connects uint64 `json:"connects"`
+}
+
+// DialFunc connects and returns a channel closed once the connection is lost
+type DialFunc func() (<-chan struct{}, error)
+
+// Loop connects with a DialFunc and connects again once the connection is lost
+type Loop struct {
+	dial    DialFunc
+	options Options
+	status  Status
+	mux     sync.Mutex
+	closed  chan struct{}
+	once    sync.Once
+	logger  *log.Entry
+	// after waits between the attempts, it is replaced by the tests
+	after func(d time.Duration) <-chan time.Time
+}
+
+// New creates a reconnect loop for the address, Run starts it
+func New(addr string, dial DialFunc, options Options, logger *log.Entry) *Loop {
+	if options.Backoff <= 0 {
+		options.Backoff = defaultBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultMaxBackoff
+	}
+	if options.MaxBackoff < options.Backoff {
+		options.MaxBackoff = options.Backoff
+	}
+	return &Loop{
+		dial:    dial,
+		options: options,
+		status:  Status{State: StateConnecting, Addr: addr, Since: time.Now()},
+		closed:  make(chan struct{}),
+		logger:  logger.WithField("addr", addr),
+		after:   time.After,
+	}
+}
+
+// Run connects until Close is called
+func (l *Loop) Run() {
+	backoff := time.Duration(l.options.Backoff) * time.Millisecond
+	maxBackoff := time.Duration(l.options.MaxBackoff) * time.Millisecond
+	delay := backoff
+	for {
+		// the wait below may pick the delay over Close when both are ready
+		select {
+		case <-l.closed:
+			return
+		default:
+		}
+		l.setState(StateConnecting, nil)
+		lost, err := l.dial()
+		if err == nil {
+			l.setState(StateConnected, nil)
+			l.logger.Info("Connected")
+			delay = backoff
+			select {
+			case <-lost:
+				l.setState(StateWaiting, nil)
+				l.logger.Info("Connection lost")
+			case <-l.closed:
+				return
+			}
+		} else {
+			l.setState(StateWaiting, err)
+			l.logger.WithError(err).WithField("retryIn", delay).Warn("Failed to connect")
+		}
+		select {
+		case <-l.after(delay):
+		case <-l.closed:
+			return
+		}
+		if err != nil {
+			delay *= 2
+			if delay > maxBackoff {
+				delay = maxBackoff
+			}
+		}
+	}
+}
+
+func (l *Loop) setState(state State, err error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	select {
+	case <-l.closed:
+		return
+	default:
+	}
+	switch {
+	case state == StateConnected:
+		l.status.Attempts = 0
+		l.status.LastError = ""
+		l.status.Connects++
+	case err != nil:
+		l.status.Attempts++
+		l.status.LastError = err.Error()
+	}
+	if l.status.State != state {
+		l.status.State = state
+		l.status.Since = time.Now()
+	}
+}
+
+// Close stops the loop, the current connection is left to the caller
+func (l *Loop) Close() {
+	l.once.Do(func() {
+		l.mux.Lock()
+		close(l.closed)
+		l.status.State = StateClosed
+		l.status.Since = time.Now()
+		l.mux.Unlock()
+	})
+}
+
+// Status returns the status of the connection
+func (l *Loop) Status() Status {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.status
+}
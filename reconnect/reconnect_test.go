@@ -0,0 +1,119 @@
+package reconnect
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name    string
+		options Options
+		// attempts lists the outcome of the attempts, true for a connection, which is lost at once
+		attempts []bool
+		want     []time.Duration
+	}{
+		{name: "defaults", attempts: []bool{false, false, false, false, false}, want: []time.Duration{s, 2 * s, 4 * s, 8 * s, 16 * s}},
+		{name: "max backoff", attempts: []bool{false, false, false, false, false, false, false}, want: []time.Duration{s, 2 * s, 4 * s, 8 * s, 16 * s, 30 * s, 30 * s}},
+		{name: "custom", options: Options{Backoff: 100, MaxBackoff: 300}, attempts: []bool{false, false, false, false}, want: []time.Duration{s / 10, s / 5, 3 * s / 10, 3 * s / 10}},
+		{name: "max below backoff", options: Options{Backoff: 5000, MaxBackoff: 1000}, attempts: []bool{false, false}, want: []time.Duration{5 * s, 5 * s}},
+		{name: "reset on connect", attempts: []bool{false, false, true, false, false}, want: []time.Duration{s, 2 * s, s, s, 2 * s}},
+		{name: "connected", attempts: []bool{true, true}, want: []time.Duration{s, s}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New()
+			logger.SetOutput(ioutil.Discard)
+			var l *Loop
+			attempts := tt.attempts
+			dial := func() (<-chan struct{}, error) {
+				if len(attempts) == 0 {
+					l.Close()
+					return nil, errors.New("closed")
+				}
+				ok := attempts[0]
+				attempts = attempts[1:]
+				if !ok {
+					return nil, errors.New("refused")
+				}
+				lost := make(chan struct{})
+				close(lost)
+				return lost, nil
+			}
+			l = New("127.0.0.1:9000", dial, tt.options, log.NewEntry(logger))
+			var delays []time.Duration
+			l.after = func(d time.Duration) <-chan time.Time {
+				select {
+				case <-l.closed:
+					// never fires so that the loop returns
+					return nil
+				default:
+				}
+				delays = append(delays, d)
+				c := make(chan time.Time, 1)
+				c <- time.Now()
+				return c
+			}
+			l.Run()
+			if !reflect.DeepEqual(delays, tt.want) {
+				t.Fatalf("got the delays %v, want %v", delays, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	var l *Loop
+	var statuses []Status
+	n := 0
+	dial := func() (<-chan struct{}, error) {
+		statuses = append(statuses, l.Status())
+		n++
+		switch n {
+		case 1, 2:
+			return nil, errors.New("refused")
+		case 3:
+			lost := make(chan struct{})
+			close(lost)
+			return lost, nil
+		}
+		l.Close()
+		return nil, errors.New("closed")
+	}
+	l = New("127.0.0.1:9000", dial, Options{}, log.NewEntry(logger))
+	l.after = func(time.Duration) <-chan time.Time {
+		select {
+		case <-l.closed:
+			return nil
+		default:
+		}
+		c := make(chan time.Time, 1)
+		c <- time.Now()
+		return c
+	}
+	l.Run()
+	want := []struct {
+		attempts  int
+		lastError string
+		connects  uint64
+	}{{0, "", 0}, {1, "refused", 0}, {2, "refused", 0}, {0, "", 1}}
+	if len(statuses) != len(want) {
+		t.Fatalf("got %d attempts, want %d", len(statuses), len(want))
+	}
+	for i, w := range want {
+		st := statuses[i]
+		if st.State != StateConnecting || st.Attempts != w.attempts || st.LastError != w.lastError || st.Connects != w.connects {
+			t.Errorf("attempt %d: got %+v, want %+v", i, st, w)
+		}
+	}
+	if st := l.Status(); st.State != StateClosed {
+		t.Fatalf("got the state %s after Close, want %s", st.State, StateClosed)
+	}
+}
@@ -0,0 +1,164 @@
+// Package rtc creates the peer connections of the WebRTC components with the ICE settings of their options
+package rtc
+
+import (
+	"errors"
+	"github.com/pion/ice/v2"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+)
+
+// tcpReadBufferSize is the number of packets buffered for each ICE-TCP connection
+const tcpReadBufferSize = 8
+
+// Options tunes the ICE agents through the setting engine of pion
+type Options struct {
+	// NAT1To1IPs are the public IPs of a 1:1 NAT. They replace the IPs of the host candidates, or are added as server
+	// reflexive candidates if NAT1To1CandidateType is "srflx".
+	NAT1To1IPs           []string
+	NAT1To1CandidateType string
+	// PortMin and PortMax limit the ephemeral UDP ports of the candidates
+	PortMin uint16
+	PortMax uint16
+	// UDPMux serves the ICE traffic of all the peer connections on a single UDP address, e.g. ":8443"
+	UDPMux string
+	// TCPMux accepts ICE over TCP on an address, e.g. ":8443", for the peers whose UDP is blocked
+	TCPMux string
+	// MDNS is the multicast DNS mode: "disabled", "queryOnly" (by default) or "queryAndGather"
+	MDNS string
+	// Interfaces only gathers the candidates of the listed network interfaces, ExcludeInterfaces skips the listed
+	// ones
+	Interfaces        []string
+	ExcludeInterfaces []string
+}
+
+var mdnsModes = map[string]ice.MulticastDNSMode{
+	"disabled":       ice.MulticastDNSModeDisabled,
+	"queryOnly":      ice.MulticastDNSModeQueryOnly,
+	"queryAndGather": ice.MulticastDNSModeQueryAndGather,
+}
+
+// API creates peer connections sharing the settings and the muxes built from the options
+type API struct {
+	settings webrtc.SettingEngine
+	closers  []io.Closer
+}
+
+// New builds the settings, the muxes listen until the API is closed
+func New(options Options, logger *log.Entry) (*API, error) {
+	res := &API{}
+	if err := res.configure(options, logger); err != nil {
+		res.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+func (a *API) configure(options Options, logger *log.Entry) error {
+	s := &a.settings
+	if len(options.NAT1To1IPs) > 0 {
+		switch options.NAT1To1CandidateType {
+		case "", "host":
+			s.SetNAT1To1IPs(options.NAT1To1IPs, webrtc.ICECandidateTypeHost)
+		case "srflx":
+			s.SetNAT1To1IPs(options.NAT1To1IPs, webrtc.ICECandidateTypeSrflx)
+		default:
+			return errors.New("unknown NAT 1:1 candidate type: " + options.NAT1To1CandidateType)
+		}
+	}
+	if options.PortMin != 0 || options.PortMax != 0 {
+		if err := s.SetEphemeralUDPPortRange(options.PortMin, options.PortMax); err != nil {
+			return err
+		}
+	}
+	if options.MDNS != "" {
+		mode, ok := mdnsModes[options.MDNS]
+		if !ok {
+			return errors.New("unknown mDNS mode: " + options.MDNS)
+		}
+		s.SetICEMulticastDNSMode(mode)
+	}
+	if len(options.Interfaces) > 0 || len(options.ExcludeInterfaces) > 0 {
+		s.SetInterfaceFilter(interfaceFilter(options.Interfaces, options.ExcludeInterfaces))
+	}
+	if options.UDPMux != "" {
+		addr, err := net.ResolveUDPAddr("udp", options.UDPMux)
+		if err != nil {
+			return err
+		}
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			return err
+		}
+		mux := ice.NewUDPMuxDefault(ice.UDPMuxParams{UDPConn: conn})
+		a.closers = append(a.closers, mux)
+		s.SetICEUDPMux(mux)
+		logger.WithField("addr", conn.LocalAddr().String()).Info("ICE UDP mux is listening")
+	}
+	if options.TCPMux != "" {
+		l, err := net.Listen("tcp", options.TCPMux)
+		if err != nil {
+			return err
+		}
+		mux := ice.NewTCPMuxDefault(ice.TCPMuxParams{Listener: l, ReadBufferSize: tcpReadBufferSize})
+		a.closers = append(a.closers, mux)
+		s.SetICETCPMux(mux)
+		// the TCP candidates are only gathered if their network types are enabled
+		s.SetNetworkTypes([]webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6, webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6,
+		})
+		logger.WithField("addr", l.Addr().String()).Info("ICE TCP mux is listening")
+	}
+	return nil
+}
+
+// interfaceFilter accepts the included interfaces, or all of them if none is, except the excluded ones
+func interfaceFilter(include, exclude []string) func(string) bool {
+	return func(name string) bool {
+		for _, n := range exclude {
+			if n == name {
+				return false
+			}
+		}
+		if len(include) == 0 {
+			return true
+		}
+		for _, n := range include {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NewPeerConnection creates a peer connection with the default codecs and interceptors, as webrtc.NewPeerConnection
+// does. Each peer connection has its own API since the interceptors built by an API are closed along with any of its
+// peer connections.
+func (a *API) NewPeerConnection(config webrtc.Configuration) (*webrtc.PeerConnection, error) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, err
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(a.settings))
+	return api.NewPeerConnection(config)
+}
+
+// Close stops the muxes, the peer connections using them must be closed first
+func (a *API) Close() error {
+	var res error
+	for _, c := range a.closers {
+		if err := c.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	a.closers = nil
+	return res
+}
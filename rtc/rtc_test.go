@@ -0,0 +1,105 @@
+package rtc
+
+import (
+	"github.com/mitchellh/mapstructure"
+	"github.com/pion/webrtc/v3"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		// muxes is the number of muxes listening
+		muxes   int
+		wantErr bool
+	}{
+		{name: "defaults", options: map[string]interface{}{}},
+		{
+			name:    "muxes",
+			options: map[string]interface{}{"udpMux": "127.0.0.1:0", "tcpMux": "127.0.0.1:0", "nat1To1IPs": []string{"203.0.113.1"}},
+			muxes:   2,
+		},
+		{name: "host candidates", options: map[string]interface{}{"nat1To1IPs": []string{"203.0.113.1"}, "nat1To1CandidateType": "host"}},
+		{name: "srflx candidates", options: map[string]interface{}{"nat1To1IPs": []string{"203.0.113.1"}, "nat1To1CandidateType": "srflx"}},
+		{name: "relay candidates", options: map[string]interface{}{"nat1To1IPs": []string{"203.0.113.1"}, "nat1To1CandidateType": "relay"}, wantErr: true},
+		{name: "port range", options: map[string]interface{}{"portMin": 10000, "portMax": 20000}},
+		{name: "inverted port range", options: map[string]interface{}{"portMin": 20000, "portMax": 10000}, wantErr: true},
+		{name: "mdns", options: map[string]interface{}{"mdns": "queryAndGather"}},
+		{name: "unknown mdns mode", options: map[string]interface{}{"mdns": "always"}, wantErr: true},
+		{name: "interfaces", options: map[string]interface{}{"interfaces": []string{"eth0"}, "excludeInterfaces": []string{"docker0"}}},
+		{name: "malformed UDP mux", options: map[string]interface{}{"udpMux": "nowhere"}, wantErr: true},
+		{name: "UDP mux in use", options: map[string]interface{}{"udpMux": udp.LocalAddr().String()}, wantErr: true},
+		{name: "TCP mux in use", options: map[string]interface{}{"udpMux": "127.0.0.1:0", "tcpMux": tcp.Addr().String()}, wantErr: true},
+	}
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var options Options
+			if err := mapstructure.Decode(tt.options, &options); err != nil {
+				t.Fatal(err)
+			}
+			api, err := New(options, log.NewEntry(logger))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got the error %v, want an error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(api.closers) != tt.muxes {
+				t.Errorf("got %d muxes, want %d", len(api.closers), tt.muxes)
+			}
+			pc, err := api.NewPeerConnection(webrtc.Configuration{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pc.Close()
+			if err := api.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestInterfaceFilter(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		accepted         []string
+		rejected         []string
+	}{
+		{name: "included", include: []string{"eth0"}, accepted: []string{"eth0"}, rejected: []string{"eth1", "lo"}},
+		{name: "excluded", exclude: []string{"docker0"}, accepted: []string{"eth0", "lo"}, rejected: []string{"docker0"}},
+		{name: "both", include: []string{"eth0", "docker0"}, exclude: []string{"docker0"}, accepted: []string{"eth0"}, rejected: []string{"docker0", "lo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := interfaceFilter(tt.include, tt.exclude)
+			for _, name := range tt.accepted {
+				if !filter(name) {
+					t.Errorf("%s rejected", name)
+				}
+			}
+			for _, name := range tt.rejected {
+				if filter(name) {
+					t.Errorf("%s accepted", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+// Config describes the components and the pipes of a server
+type Config struct {
+	Inbounds  []ComponentConfig `json:"inbounds"`
+	Outbounds []ComponentConfig `json:"outbounds"`
+	Processes []ComponentConfig `json:"processes"`
+	Pipes     []PipeConfig      `json:"pipes"`
+}
+
+// ComponentConfig describes an inbound, an outbound or a process
+type ComponentConfig struct {
+	ID      string                 `json:"id"`
+	Type    string                 `json:"type"`
+	Options map[string]interface{} `json:"options"`
+}
+
+// PipeConfig describes a pipe, the id defaults to the id of the input
+type PipeConfig struct {
+	ID   string   `json:"id,omitempty"`
+	In   string   `json:"in"`
+	Outs []string `json:"outs"`
+	PipeOptions
+}
+
+// Apply validates the config and adds all the components and the pipes in it to the server
+func (s *Server) Apply(config *Config) error {
+	if err := s.Validate(config); err != nil {
+		return err
+	}
+	for _, i := range config.Inbounds {
+		if err := s.AddInbound(i.ID, i.Type, i.Options); err != nil {
+			return err
+		}
+	}
+	for _, o := range config.Outbounds {
+		if err := s.AddOutbound(o.ID, o.Type, o.Options); err != nil {
+			return err
+		}
+	}
+	for _, p := range config.Processes {
+		if err := s.AddProcess(p.ID, p.Type, p.Options); err != nil {
+			return err
+		}
+	}
+	for _, p := range config.Pipes {
+		if err := s.AddPipe(p.ID, p.In, p.Outs, &p.PipeOptions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
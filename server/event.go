@@ -0,0 +1,78 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened in an Event
+type EventType string
+
+const (
+	// EventReadError is reported when the input of a pipe fails to read
+	EventReadError EventType = "read_error"
+	// EventWriteError is reported when an output of a pipe fails to write
+	EventWriteError EventType = "write_error"
+	// EventOutputDetached is reported when a failing output is detached from its pipe
+	EventOutputDetached EventType = "output_detached"
+	// EventPipeStopped is reported when a pipe is stopped because of an error
+	EventPipeStopped EventType = "pipe_stopped"
+	// EventComponentStarted is reported when a component is initialized
+	EventComponentStarted EventType = "component_started"
+	// EventComponentStopped is reported when a component is closed
+	EventComponentStopped EventType = "component_stopped"
+	// EventComponentFailed is reported when a component fails to start
+	EventComponentFailed EventType = "component_failed"
+)
+
+// Event reports a change in the server
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Pipe is the id of the pipe involved, if any
+	Pipe string `json:"pipe,omitempty"`
+	// Component is the id of the inbound, outbound, process or writer involved, if any
+	Component string `json:"component,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// eventBus delivers the events to all the subscribers without blocking the publisher
+type eventBus struct {
+	subscribers map[chan Event]struct{}
+	mux         sync.Mutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+func (b *eventBus) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mux.Lock()
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default:
+			// drop the event for slow subscribers
+		}
+	}
+	b.mux.Unlock()
+}
+
+func (b *eventBus) subscribe(size int) (<-chan Event, func()) {
+	c := make(chan Event, size)
+	b.mux.Lock()
+	b.subscribers[c] = struct{}{}
+	b.mux.Unlock()
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.mux.Lock()
+			delete(b.subscribers, c)
+			b.mux.Unlock()
+			close(c)
+		})
+	}
+}
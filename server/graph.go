@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NodeKind is the kind of a node of the pipe graph
+type NodeKind string
+
+const (
+	NodeInbound  NodeKind = "inbound"
+	NodeOutbound NodeKind = "outbound"
+	NodeProcess  NodeKind = "process"
+	// NodeSubWriter is a writer provided under the id of a component, e.g. "webrtc-out:video"
+	NodeSubWriter NodeKind = "subwriter"
+	// NodeSubReader is a reader provided under the id of a component, e.g. "whip-in:video"
+	NodeSubReader NodeKind = "subreader"
+	// NodeStream is the input of a stream provided by a component, e.g. "srt-in/live/cam1"
+	NodeStream NodeKind = "stream"
+)
+
+// Graph describes the components and the pipes connecting them
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Node is a reader or a writer of the graph
+type Node struct {
+	ID   string   `json:"id"`
+	Kind NodeKind `json:"kind"`
+	Type string   `json:"type,omitempty"`
+	// Owner is the id of the component providing a sub-writer, a sub-reader or a stream
+	Owner string `json:"owner,omitempty"`
+	// State is the state of the component, or of the owner of a sub-writer. It is empty for the readers and the
+	// writers added without a component.
+	State State `json:"state,omitempty"`
+}
+
+// Edge is an output of a pipe
+type Edge struct {
+	Pipe  string `json:"pipe"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	State State  `json:"state"`
+	// Attached reports whether the output is currently fed by the pipe
+	Attached bool `json:"attached"`
+}
+
+// Graph returns the pipe graph with the live status of the nodes and the edges, the pipe templates are represented by
+// their instances
+func (s *Server) Graph() Graph {
+	res := Graph{Nodes: []Node{}, Edges: []Edge{}}
+
+	s.mux.RLock()
+	for id, c := range s.components {
+		res.Nodes = append(res.Nodes, Node{ID: id, Kind: NodeKind(c.kind), Type: c.config.Type, State: c.state})
+	}
+	for id := range s.readers {
+		if _, ok := s.components[id]; ok {
+			continue
+		}
+		owner := s.ownerOfLocked(id)
+		if c, ok := s.components[owner]; ok {
+			kind := NodeStream
+			if strings.HasPrefix(id, owner+":") {
+				kind = NodeSubReader
+			}
+			res.Nodes = append(res.Nodes, Node{ID: id, Kind: kind, Type: c.config.Type, Owner: owner, State: c.state})
+		} else {
+			res.Nodes = append(res.Nodes, Node{ID: id, Kind: NodeInbound})
+		}
+	}
+	for id := range s.writers {
+		if _, ok := s.components[id]; ok {
+			continue
+		}
+		owner := s.ownerOfLocked(id)
+		if c, ok := s.components[owner]; ok {
+			res.Nodes = append(res.Nodes, Node{ID: id, Kind: NodeSubWriter, Type: c.config.Type, Owner: owner, State: c.state})
+		} else {
+			res.Nodes = append(res.Nodes, Node{ID: id, Kind: NodeOutbound})
+		}
+	}
+	s.mux.RUnlock()
+	sort.Slice(res.Nodes, func(i, j int) bool { return res.Nodes[i].ID < res.Nodes[j].ID })
+
+	for _, p := range s.Pipes() {
+		if isTemplate(p.PipeConfig) {
+			continue
+		}
+		for _, out := range p.Outs {
+			res.Edges = append(res.Edges, Edge{
+				Pipe:     p.ID,
+				From:     p.In,
+				To:       out,
+				State:    p.State,
+				Attached: contains(p.Attached, out),
+			})
+		}
+	}
+	return res
+}
+
+var nodeShapes = map[NodeKind]string{
+	NodeInbound:   "invhouse",
+	NodeOutbound:  "house",
+	NodeProcess:   "box",
+	NodeSubWriter: "ellipse",
+	NodeSubReader: "ellipse",
+	NodeStream:    "ellipse",
+}
+
+var stateColors = map[State]string{
+	StateRunning: "palegreen",
+	StateStopped: "lightgrey",
+	StateFailed:  "salmon",
+}
+
+// DOT renders the graph in the Graphviz DOT language, the sub-writers, the sub-readers and the streams are grouped with
+// their owner
+func (g Graph) DOT() string {
+	b := &strings.Builder{}
+	b.WriteString("digraph golive {\n\trankdir=LR;\n\tnode [style=filled, fillcolor=white];\n")
+
+	owned := make(map[string][]Node)
+	for _, n := range g.Nodes {
+		if n.Kind == NodeSubWriter || n.Kind == NodeSubReader || n.Kind == NodeStream {
+			owned[n.Owner] = append(owned[n.Owner], n)
+		}
+	}
+	for _, n := range g.Nodes {
+		switch {
+		case n.Kind == NodeSubWriter || n.Kind == NodeSubReader || n.Kind == NodeStream:
+		case len(owned[n.ID]) > 0:
+			fmt.Fprintf(b, "\tsubgraph %s {\n\t\tlabel=%s;\n", quote("cluster_"+n.ID), quote(n.ID))
+			writeNode(b, "\t\t", n)
+			for _, sub := range owned[n.ID] {
+				writeNode(b, "\t\t", sub)
+			}
+			b.WriteString("\t}\n")
+		default:
+			writeNode(b, "\t", n)
+		}
+	}
+	for _, e := range g.Edges {
+		style := "solid"
+		if !e.Attached {
+			style = "dashed"
+		}
+		fmt.Fprintf(b, "\t%s -> %s [label=%s, style=%s];\n", quote(e.From), quote(e.To), quote(e.Pipe), style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func writeNode(b *strings.Builder, indent string, n Node) {
+	label := n.ID
+	if n.Type != "" {
+		label += "\n" + n.Type
+	}
+	if n.State != "" {
+		label += "\n" + string(n.State)
+	}
+	color, ok := stateColors[n.State]
+	if !ok {
+		color = "white"
+	}
+	fmt.Fprintf(b, "%s%s [label=%s, shape=%s, fillcolor=%s];\n", indent, quote(n.ID), quote(label), nodeShapes[n.Kind], color)
+}
+
+// quote returns a quoted DOT id
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
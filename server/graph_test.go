@@ -0,0 +1,58 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{``, `""`},
+		{`srt-in`, `"srt-in"`},
+		{`srt-in/live/cam1`, `"srt-in/live/cam1"`},
+		{`webrtc-out:video`, `"webrtc-out:video"`},
+		{`a"b`, `"a\"b"`},
+		{`"`, `"\""`},
+		{`a\b`, `"a\\b"`},
+		{`a\`, `"a\\"`},
+		{`a\"b`, `"a\\\"b"`},
+		{"a\nb", `"a\nb"`},
+		{`a\nb`, `"a\\nb"`},
+		{`a;b} c`, `"a;b} c"`},
+	}
+	for _, tt := range tests {
+		if got := quote(tt.in); got != tt.want {
+			t.Errorf("quote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDOT(t *testing.T) {
+	g := Graph{
+		Nodes: []Node{
+			{ID: `in"1`, Kind: NodeInbound, Type: "srt", State: StateRunning},
+			{ID: `in"1/live/cam1`, Kind: NodeStream, Type: "srt", Owner: `in"1`, State: StateRunning},
+			{ID: `out\1`, Kind: NodeOutbound, Type: "srt", State: StateFailed},
+		},
+		Edges: []Edge{{Pipe: `p"1`, From: `in"1/live/cam1`, To: `out\1`, State: StateRunning, Attached: true}},
+	}
+	got := g.DOT()
+	for _, line := range []string{
+		"\tsubgraph \"cluster_in\\\"1\" {\n\t\tlabel=\"in\\\"1\";\n",
+		"\t\t\"in\\\"1\" [label=\"in\\\"1\\nsrt\\nrunning\", shape=invhouse, fillcolor=palegreen];\n",
+		"\t\t\"in\\\"1/live/cam1\" [label=\"in\\\"1/live/cam1\\nsrt\\nrunning\", shape=ellipse, fillcolor=palegreen];\n",
+		"\t\"out\\\\1\" [label=\"out\\\\1\\nsrt\\nfailed\", shape=house, fillcolor=salmon];\n",
+		"\t\"in\\\"1/live/cam1\" -> \"out\\\\1\" [label=\"p\\\"1\", style=solid];\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("missing %q in:\n%s", line, got)
+		}
+	}
+	// the stream is only rendered in the cluster of its owner
+	if n := strings.Count(got, "[label=\"in\\\"1/live/cam1\\n"); n != 1 {
+		t.Errorf("the stream is rendered %d times:\n%s", n, got)
+	}
+}
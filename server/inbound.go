@@ -1,8 +1,10 @@
 package server
 
+// Inbound is a source of data, it must implement PacketReader or io.Reader. A byte stream is read in packets of
+// DefaultReadSize bytes.
 type Inbound interface {
 	Init() error
-	Read(p []byte) (n int, err error)
+	Close() error
 }
 
 type InboundRegisterFunc func(server *Server, id string, options map[string]interface{}) (Inbound, error)
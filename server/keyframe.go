@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+)
+
+// KeyframeRequester is implemented by the components able to ask their source for a keyframe, e.g. the WHIP inbound
+// sending a PLI to its publisher
+type KeyframeRequester interface {
+	// RequestKeyframe asks for a keyframe of a track, track is empty if the request is not bound to a track
+	RequestKeyframe(track string) error
+}
+
+// RequestKeyframe asks a running component for a keyframe, id may name one of its tracks, e.g. "whip-in:video"
+func (s *Server) RequestKeyframe(id string) error {
+	owner := s.ownerOf(id)
+	track := ""
+	if owner != id {
+		track = id[len(owner)+1:]
+	}
+	c, err := s.component(owner)
+	if err != nil {
+		return err
+	}
+	s.mux.RLock()
+	kr, ok := c.instance.(KeyframeRequester)
+	running := c.state == StateRunning
+	s.mux.RUnlock()
+	if !ok {
+		return fmt.Errorf("component %s can not request keyframes", owner)
+	}
+	if !running {
+		return fmt.Errorf("component %s is not running", owner)
+	}
+	return kr.RequestKeyframe(track)
+}
@@ -1,8 +1,15 @@
 package server
 
+// Outbound is a destination of data, it must implement PacketWriter or io.Writer
 type Outbound interface {
 	Init() error
-	Write(p []byte) (int, error)
+	Close() error
+}
+
+// StreamWriterProvider is implemented by the outbounds serving each stream to its own clients. The writer of a stream
+// is named "<id>/<stream>" and is requested the first time a pipe writes to it.
+type StreamWriterProvider interface {
+	StreamWriter(stream string) (PacketWriter, error)
 }
 
 type OutboundRegisterFunc func(server *Server, id string, options map[string]interface{}) (Outbound, error)
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReadSize is the size of the buffers used to read from a byte stream, 7 MPEG-TS packets fit in it
+const DefaultReadSize = 1316
+
+// Packet is a unit of data moved through the pipes. A packet is shared by all the outputs of a pipe, so it must not
+// be modified once it is read.
+//
+// The packets created by NewPacket are reference counted and their buffer is reused once the last reference is
+// released. A PacketWriter must not use the packet after WritePacket returns unless it calls Retain, and Release once
+// it is done with it. A packet must not be copied.
+type Packet struct {
+	// refs is accessed atomically and kept first for alignment
+	refs    int32
+	pooled  bool
+	buf     []byte
+	Payload []byte
+	// Time is the time the packet is received
+	Time time.Time
+	// Source identifies the connection the packet is received from, e.g. the address of the peer. It is empty if
+	// unknown.
+	Source string
+	// PTS and DTS are the presentation and the decoding timestamps, they are only set if HasPTS and HasDTS are set
+	PTS    time.Duration
+	DTS    time.Duration
+	HasPTS bool
+	HasDTS bool
+	// Keyframe is set if the packet starts a keyframe
+	Keyframe bool
+}
+
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		return &Packet{buf: make([]byte, DefaultReadSize), pooled: true}
+	},
+}
+
+// NewPacket returns a packet holding a single reference with a payload of size bytes, the buffer is taken from a pool
+// if size does not exceed DefaultReadSize
+func NewPacket(size int) *Packet {
+	var p *Packet
+	if size <= DefaultReadSize {
+		p = packetPool.Get().(*Packet)
+		p.Payload = p.buf[:size]
+	} else {
+		p = &Packet{Payload: make([]byte, size)}
+	}
+	p.refs = 1
+	return p
+}
+
+// Retain adds a reference to the packet
+func (p *Packet) Retain() {
+	atomic.AddInt32(&p.refs, 1)
+}
+
+// Release drops a reference to the packet, the buffer is returned to the pool once the last reference is dropped. It
+// does nothing on the packets not created by NewPacket.
+func (p *Packet) Release() {
+	refs := atomic.AddInt32(&p.refs, -1)
+	if refs > 0 || !p.pooled {
+		return
+	}
+	if refs < 0 {
+		panic("golive: packet released too many times")
+	}
+	buf := p.buf
+	*p = Packet{buf: buf, pooled: true}
+	packetPool.Put(p)
+}
+
+// PacketReader is implemented by the inputs reading whole packets
+type PacketReader interface {
+	// ReadPacket blocks until a packet is received, the caller owns a reference to the returned packet. The packet is
+	// nil if the error is not nil.
+	ReadPacket() (*Packet, error)
+}
+
+// KeyframeFlagger is implemented by the packet readers setting the Keyframe flag of the packets, the outputs dropping
+// the packets until a keyframe only accept such inputs
+type KeyframeFlagger interface {
+	FlagsKeyframes() bool
+}
+
+// PacketWriter is implemented by the outputs writing whole packets
+type PacketWriter interface {
+	WritePacket(p *Packet) error
+}
+
+// streamReader reads packets from a byte stream, each read fills a packet
+type streamReader struct {
+	r    io.Reader
+	size int
+}
+
+// NewPacketReader reads packets of up to size bytes from a byte stream, r is returned as is if it implements
+// PacketReader
+func NewPacketReader(r io.Reader, size int) PacketReader {
+	if pr, ok := r.(PacketReader); ok {
+		return pr
+	}
+	if size <= 0 {
+		size = DefaultReadSize
+	}
+	return &streamReader{r, size}
+}
+
+func (s *streamReader) ReadPacket() (*Packet, error) {
+	p := NewPacket(s.size)
+	n, err := s.r.Read(p.Payload)
+	if err != nil {
+		p.Release()
+		return nil, err
+	}
+	p.Payload = p.Payload[:n]
+	p.Time = time.Now()
+	return p, nil
+}
+
+// streamWriter writes the payload of the packets to a byte stream
+type streamWriter struct {
+	w io.Writer
+}
+
+// NewPacketWriter writes the payload of the packets to a byte stream, w is returned as is if it implements
+// PacketWriter
+func NewPacketWriter(w io.Writer) PacketWriter {
+	if pw, ok := w.(PacketWriter); ok {
+		return pw
+	}
+	return &streamWriter{w}
+}
+
+func (s *streamWriter) WritePacket(p *Packet) error {
+	_, err := s.w.Write(p.Payload)
+	return err
+}
+
+// packetReaderOf returns the packet reader of a component implementing PacketReader or io.Reader
+func packetReaderOf(v interface{}) (PacketReader, bool) {
+	switch r := v.(type) {
+	case PacketReader:
+		return r, true
+	case io.Reader:
+		return NewPacketReader(r, DefaultReadSize), true
+	}
+	return nil, false
+}
+
+// packetWriterOf returns the packet writer of a component implementing PacketWriter or io.Writer
+func packetWriterOf(v interface{}) (PacketWriter, bool) {
+	switch w := v.(type) {
+	case PacketWriter:
+		return w, true
+	case io.Writer:
+		return NewPacketWriter(w), true
+	}
+	return nil, false
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestPacketRefs(t *testing.T) {
+	p := NewPacket(100)
+	if len(p.Payload) != 100 || !p.pooled {
+		t.Fatalf("got a payload of %d bytes, pooled %v", len(p.Payload), p.pooled)
+	}
+	p.Payload[0] = 1
+	p.Retain()
+	p.Retain()
+	p.Release()
+	p.Release()
+	if p.refs != 1 || p.Payload == nil {
+		t.Fatalf("packet reset with %d references left", p.refs)
+	}
+	p.Release()
+	if p.refs != 0 || p.Payload != nil || !p.pooled || len(p.buf) != DefaultReadSize {
+		t.Fatalf("released packet not reset: refs %d, payload %v", p.refs, p.Payload)
+	}
+}
+
+func TestPacketPool(t *testing.T) {
+	// sync.Pool may drop the items, e.g. with the race detector, so the buffer is expected back at least once
+	for i := 0; i < 100; i++ {
+		p := NewPacket(DefaultReadSize)
+		buf := &p.buf[0]
+		p.Release()
+		q := NewPacket(10)
+		reused := &q.buf[0] == buf
+		q.Release()
+		if reused {
+			return
+		}
+	}
+	t.Fatal("the buffers are never reused")
+}
+
+func TestPacketNotPooled(t *testing.T) {
+	p := NewPacket(DefaultReadSize + 1)
+	if p.pooled || len(p.Payload) != DefaultReadSize+1 {
+		t.Fatal("large packets must not be pooled")
+	}
+	p.Release()
+	if p.Payload == nil {
+		t.Fatal("the payload of a packet not pooled must be left to the garbage collector")
+	}
+}
+
+func TestPacketDoubleRelease(t *testing.T) {
+	p := NewPacket(10)
+	p.Release()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("releasing a packet twice must panic")
+		}
+	}()
+	p.Release()
+}
@@ -0,0 +1,531 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorPolicy decides what a pipe does when its input or one of its outputs fails
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyRetry retries the failed read or write with exponential backoff. Once MaxRetries is exceeded, a failing
+	// output is detached and a failing input stops the pipe.
+	ErrorPolicyRetry ErrorPolicy = "retry"
+	// ErrorPolicyDetach detaches a failing output from the pipe and leaves the other outputs running, read errors are
+	// retried as with ErrorPolicyRetry
+	ErrorPolicyDetach ErrorPolicy = "detach"
+	// ErrorPolicyStop stops the whole pipe on the first error
+	ErrorPolicyStop ErrorPolicy = "stop"
+)
+
+// OverflowPolicy decides what an output does with a new packet when its buffer is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits until the output has room for the packet, a slow output stalls the other outputs of the pipe
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropNewest drops the new packet
+	OverflowDropNewest OverflowPolicy = "dropNewest"
+	// OverflowDropOldest drops the oldest buffered packet to make room for the new one
+	OverflowDropOldest OverflowPolicy = "dropOldest"
+	// OverflowDropUntilKeyframe drops the new packet and all the following ones until a keyframe, so the output
+	// resumes on a decodable frame. It is only accepted on the inputs implementing KeyframeFlagger.
+	OverflowDropUntilKeyframe OverflowPolicy = "dropUntilKeyframe"
+)
+
+const (
+	defaultRetryBackoff    = 100
+	defaultMaxRetryBackoff = 10000
+	defaultBufferSize      = 102400
+)
+
+// OutputOptions configures the buffer of a pipe output
+type OutputOptions struct {
+	// BufferSize is the number of packets buffered for the output, 102400 by default
+	BufferSize int `json:"bufferSize"`
+	// Overflow is the overflow policy of the output, OverflowBlock by default
+	Overflow OverflowPolicy `json:"overflow"`
+}
+
+func (o *OutputOptions) validate() error {
+	switch o.Overflow {
+	case "":
+		o.Overflow = OverflowBlock
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest, OverflowDropUntilKeyframe:
+	default:
+		return errors.New("unknown overflow policy: " + string(o.Overflow))
+	}
+	if o.BufferSize < 0 {
+		return errors.New("bufferSize must not be negative")
+	}
+	if o.BufferSize == 0 {
+		o.BufferSize = defaultBufferSize
+	}
+	return nil
+}
+
+// PipeOptions configures the error handling and the output buffers of a pipe
+type PipeOptions struct {
+	// OnError is the error policy of the pipe, ErrorPolicyRetry by default
+	OnError ErrorPolicy `json:"onError"`
+	// MaxRetries is the number of consecutive retries before giving up, 0 means retrying forever
+	MaxRetries int `json:"maxRetries"`
+	// RetryBackoff is the initial delay between retries in milliseconds, doubled after each retry
+	RetryBackoff int `json:"retryBackoff"`
+	// MaxRetryBackoff is the upper bound of the delay between retries in milliseconds
+	MaxRetryBackoff int `json:"maxRetryBackoff"`
+	// OutputOptions are the default options of the outputs
+	OutputOptions
+	// Outputs overrides the options of the outputs by id, the fields left empty take the default options
+	Outputs map[string]OutputOptions `json:"outputs,omitempty"`
+}
+
+func (o *PipeOptions) validate() error {
+	switch o.OnError {
+	case "":
+		o.OnError = ErrorPolicyRetry
+	case ErrorPolicyRetry, ErrorPolicyDetach, ErrorPolicyStop:
+	default:
+		return errors.New("unknown error policy: " + string(o.OnError))
+	}
+	if o.MaxRetries < 0 {
+		return errors.New("maxRetries must not be negative")
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if o.MaxRetryBackoff < o.RetryBackoff {
+		o.MaxRetryBackoff = o.RetryBackoff
+	}
+	if err := o.OutputOptions.validate(); err != nil {
+		return err
+	}
+	for id := range o.Outputs {
+		if _, err := o.output(id); err != nil {
+			return fmt.Errorf("output %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// output returns the options of the output with the defaults filled in
+func (o *PipeOptions) output(id string) (OutputOptions, error) {
+	res := o.Outputs[id]
+	if res.BufferSize == 0 {
+		res.BufferSize = o.BufferSize
+	}
+	if res.Overflow == "" {
+		res.Overflow = o.Overflow
+	}
+	err := res.validate()
+	return res, err
+}
+
+// pipe feeds the data read from an input to all of its outputs, a new pipe is created each time a pipe is started
+type pipe struct {
+	id      string
+	in      string
+	reader  PacketReader
+	options PipeOptions
+	outs    []*pipeOutput
+	outsMux sync.Mutex
+	// finished is set once the read goroutine exits, no more outputs can be attached afterwards
+	finished bool
+	closing  <-chan struct{}
+	// stopping is closed when the pipe is stopped
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	counters *pipeCounters
+	events   *eventBus
+	logger   *log.Entry
+}
+
+// pipeOutput buffers the data of a pipe for a single output
+type pipeOutput struct {
+	id     string
+	writer PacketWriter
+	c      chan *Packet
+	// options is the buffer configuration of the output
+	options OutputOptions
+	// skipping is set while packets are dropped until the next keyframe, it is only used by the read goroutine
+	skipping bool
+	// detached is closed when the output stops accepting data
+	detached   chan struct{}
+	detachOnce sync.Once
+	done       chan struct{}
+	counters   *outputCounters
+}
+
+func newPipe(id, in string, reader PacketReader, options PipeOptions, closing <-chan struct{}, counters *pipeCounters, events *eventBus, logger *log.Entry) *pipe {
+	return &pipe{
+		id:       id,
+		in:       in,
+		reader:   reader,
+		options:  options,
+		closing:  closing,
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+		counters: counters,
+		events:   events,
+		logger:   logger.WithFields(log.Fields{"pipe": id, "in": in}),
+	}
+}
+
+// start runs the read goroutine
+func (p *pipe) start() {
+	go p.read()
+}
+
+// attach adds an output to the pipe and runs its write goroutine, false is returned if the pipe is finished
+func (p *pipe) attach(id string, writer PacketWriter) bool {
+	options, _ := p.options.output(id)
+	o := &pipeOutput{
+		id:       id,
+		writer:   writer,
+		c:        make(chan *Packet, options.BufferSize),
+		options:  options,
+		detached: make(chan struct{}),
+		done:     make(chan struct{}),
+		counters: p.counters.output(id),
+	}
+	p.outsMux.Lock()
+	defer p.outsMux.Unlock()
+	if p.finished {
+		return false
+	}
+	// the slice is copied on write so the snapshots returned by outputs stay untouched
+	p.outs = append(p.outs[:len(p.outs):len(p.outs)], o)
+	go p.write(o)
+	return true
+}
+
+// wait blocks until all the outputs are drained
+func (p *pipe) wait() {
+	<-p.done
+	for _, o := range p.outputs() {
+		<-o.done
+	}
+}
+
+// running reports whether the read goroutine is still running
+func (p *pipe) running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// outputs returns a snapshot of the attached outputs, it must not be modified
+func (p *pipe) outputs() []*pipeOutput {
+	p.outsMux.Lock()
+	defer p.outsMux.Unlock()
+	return p.outs
+}
+
+// outputIDs returns the ids of the attached outputs
+func (p *pipe) outputIDs() []string {
+	var res []string
+	for _, o := range p.outputs() {
+		res = append(res, o.id)
+	}
+	return res
+}
+
+// detach removes the output from the pipe, the packets buffered for the output are released
+func (p *pipe) detach(o *pipeOutput) {
+	o.detachOnce.Do(func() {
+		close(o.detached)
+	})
+	o.drain()
+	p.outsMux.Lock()
+	for i, out := range p.outs {
+		if out == o {
+			p.outs = append(p.outs[:i:i], p.outs[i+1:]...)
+			break
+		}
+	}
+	p.outsMux.Unlock()
+}
+
+// detachID detaches the output with the id if it is attached
+func (p *pipe) detachID(id string) {
+	for _, o := range p.outputs() {
+		if o.id == id {
+			p.detach(o)
+		}
+	}
+}
+
+// stop makes the read goroutine exit after the pending read, the outputs drain the buffered data afterwards
+func (p *pipe) stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopping)
+	})
+}
+
+func (p *pipe) read() {
+	defer func() {
+		// let the outputs drain the buffered data and exit
+		p.outsMux.Lock()
+		p.finished = true
+		for _, o := range p.outs {
+			close(o.c)
+		}
+		p.outsMux.Unlock()
+		close(p.done)
+	}()
+	b := newBackoff(&p.options)
+	for {
+		// read from inbound
+		pkt, err := p.reader.ReadPacket()
+		if err != nil && p.isClosing() {
+			// the input is closed by the shutdown
+			return
+		}
+		if p.isStopping() {
+			if pkt != nil {
+				pkt.Release()
+			}
+			return
+		}
+		if err != nil {
+			atomic.AddUint64(&p.counters.readErrors, 1)
+			p.logger.WithError(err).Warn("failed to read")
+			p.events.publish(Event{Type: EventReadError, Pipe: p.id, Component: p.in, Error: err.Error()})
+			if p.options.OnError == ErrorPolicyStop || !b.wait(p.closing, p.stopping) {
+				if p.isClosing() || p.isStopping() {
+					return
+				}
+				p.logger.Error("pipe stopped")
+				p.events.publish(Event{Type: EventPipeStopped, Pipe: p.id, Component: p.in, Error: err.Error()})
+				return
+			}
+			continue
+		}
+		if pkt == nil {
+			continue
+		}
+		if len(pkt.Payload) == 0 {
+			pkt.Release()
+			continue
+		}
+		b.reset()
+		atomic.AddUint64(&p.counters.bytesRead, uint64(len(pkt.Payload)))
+		atomic.AddUint64(&p.counters.packetsRead, 1)
+
+		// feed the packet to all channels, each output holds a reference until the packet is written
+		for _, o := range p.outputs() {
+			o.push(pkt)
+		}
+		pkt.Release()
+	}
+}
+
+// push queues a reference to the packet for the output according to its overflow policy
+func (o *pipeOutput) push(pkt *Packet) {
+	pkt.Retain()
+	switch o.options.Overflow {
+	case OverflowBlock:
+		select {
+		case o.c <- pkt:
+			o.drainDetached()
+		case <-o.detached:
+			pkt.Release()
+		}
+		return
+	case OverflowDropUntilKeyframe:
+		if o.skipping && !pkt.Keyframe {
+			o.drop(pkt)
+			return
+		}
+		o.skipping = false
+	}
+	for {
+		select {
+		case o.c <- pkt:
+			o.drainDetached()
+			return
+		case <-o.detached:
+			pkt.Release()
+			return
+		default:
+		}
+		// the buffer is full
+		switch o.options.Overflow {
+		case OverflowDropOldest:
+			select {
+			case old := <-o.c:
+				o.drop(old)
+			default:
+			}
+		case OverflowDropUntilKeyframe:
+			o.skipping = true
+			o.drop(pkt)
+			return
+		default:
+			o.drop(pkt)
+			return
+		}
+	}
+}
+
+// drainDetached drains the buffer if the output is detached, the packet just queued may be sent after the writer
+// drained it
+func (o *pipeOutput) drainDetached() {
+	select {
+	case <-o.detached:
+		o.drain()
+	default:
+	}
+}
+
+// drain releases the packets buffered for a detached output, it may run concurrently with the writer
+func (o *pipeOutput) drain() {
+	for {
+		select {
+		case pkt, ok := <-o.c:
+			if !ok {
+				return
+			}
+			o.drop(pkt)
+		default:
+			return
+		}
+	}
+}
+
+// drop releases a packet the output gives up on
+func (o *pipeOutput) drop(pkt *Packet) {
+	pkt.Release()
+	atomic.AddUint64(&o.counters.dropped, 1)
+}
+
+func (p *pipe) isClosing() bool {
+	select {
+	case <-p.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pipe) isStopping() bool {
+	select {
+	case <-p.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pipe) write(o *pipeOutput) {
+	defer close(o.done)
+	logger := p.logger.WithField("out", o.id)
+	b := newBackoff(&p.options)
+	// fetch packets from the channel and write to the outbound until the channel is closed or the output is detached
+	for {
+		var pkt *Packet
+		select {
+		case data, ok := <-o.c:
+			if !ok {
+				return
+			}
+			pkt = data
+		case <-o.detached:
+			o.drain()
+			return
+		}
+		for {
+			err := o.writer.WritePacket(pkt)
+			if err == nil {
+				b.reset()
+				atomic.AddUint64(&o.counters.bytesWritten, uint64(len(pkt.Payload)))
+				atomic.AddUint64(&o.counters.packetsWritten, 1)
+				pkt.Release()
+				break
+			}
+			atomic.AddUint64(&o.counters.writeErrors, 1)
+			logger.WithError(err).Warn("failed to write")
+			p.events.publish(Event{Type: EventWriteError, Pipe: p.id, Component: o.id, Error: err.Error()})
+			if p.options.OnError == ErrorPolicyStop {
+				logger.Error("pipe stopped")
+				p.events.publish(Event{Type: EventPipeStopped, Pipe: p.id, Component: o.id, Error: err.Error()})
+				atomic.AddUint64(&o.counters.dropped, 1)
+				pkt.Release()
+				p.stop()
+				p.detach(o)
+				return
+			}
+			if p.options.OnError == ErrorPolicyDetach || !b.wait(p.closing, o.detached) {
+				select {
+				case <-o.detached:
+					// detached while waiting for the retry, e.g. its component is stopped
+					atomic.AddUint64(&o.counters.dropped, 1)
+					pkt.Release()
+					o.drain()
+					return
+				default:
+				}
+				logger.Error("output detached")
+				p.events.publish(Event{Type: EventOutputDetached, Pipe: p.id, Component: o.id, Error: err.Error()})
+				atomic.AddUint64(&o.counters.dropped, 1)
+				pkt.Release()
+				p.detach(o)
+				// nothing is left to feed
+				if len(p.outputs()) == 0 {
+					p.stop()
+				}
+				return
+			}
+		}
+	}
+}
+
+// backoff implements exponential backoff between retries
+type backoff struct {
+	options *PipeOptions
+	delay   time.Duration
+	retries int
+}
+
+func newBackoff(options *PipeOptions) *backoff {
+	b := &backoff{options: options}
+	b.reset()
+	return b
+}
+
+func (b *backoff) reset() {
+	b.delay = time.Duration(b.options.RetryBackoff) * time.Millisecond
+	b.retries = 0
+}
+
+// wait sleeps before the next retry, false is returned if no more retries are allowed or closing or cancel is closed
+func (b *backoff) wait(closing, cancel <-chan struct{}) bool {
+	if b.options.MaxRetries > 0 && b.retries >= b.options.MaxRetries {
+		return false
+	}
+	b.retries++
+	select {
+	case <-time.After(b.delay):
+	case <-closing:
+		return false
+	case <-cancel:
+		return false
+	}
+	b.delay *= 2
+	if max := time.Duration(b.options.MaxRetryBackoff) * time.Millisecond; b.delay > max {
+		b.delay = max
+	}
+	return true
+}
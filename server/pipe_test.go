@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingReader reads n packets of size bytes, the pipe is stopped after the last one
+type countingReader struct {
+	n    int
+	size int
+	stop func()
+}
+
+func (r *countingReader) ReadPacket() (*Packet, error) {
+	if r.n == 0 {
+		r.stop()
+		return nil, errors.New("done")
+	}
+	r.n--
+	p := NewPacket(r.size)
+	p.Payload[0] = byte(r.n)
+	return p, nil
+}
+
+// recordingReader keeps the packets read from a reader
+type recordingReader struct {
+	PacketReader
+	packets []*Packet
+}
+
+func (r *recordingReader) ReadPacket() (*Packet, error) {
+	p, err := r.PacketReader.ReadPacket()
+	if p != nil {
+		r.packets = append(r.packets, p)
+	}
+	return p, err
+}
+
+// blockingReader reads n packets, then blocks until released and fails every read afterwards
+type blockingReader struct {
+	n       int
+	release chan struct{}
+}
+
+func (r *blockingReader) ReadPacket() (*Packet, error) {
+	if r.n == 0 {
+		<-r.release
+		return nil, errors.New("failed")
+	}
+	r.n--
+	return NewPacket(DefaultReadSize), nil
+}
+
+// failingWriter fails every write once released
+type failingWriter struct {
+	release chan struct{}
+}
+
+func (w *failingWriter) WritePacket(p *Packet) error {
+	<-w.release
+	return errors.New("failed")
+}
+
+// countingWriter counts the packets written to it
+type countingWriter struct {
+	packets int64
+}
+
+func (w *countingWriter) WritePacket(p *Packet) error {
+	atomic.AddInt64(&w.packets, 1)
+	return nil
+}
+
+func newTestPipe(n int, options PipeOptions) *pipe {
+	if err := options.validate(); err != nil {
+		panic(err)
+	}
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	r := &countingReader{n: n, size: DefaultReadSize}
+	p := newPipe("test", "in", r, options, make(chan struct{}), newPipeCounters(), newEventBus(), log.NewEntry(logger))
+	r.stop = p.stop
+	return p
+}
+
+func TestPipeFanOut(t *testing.T) {
+	p := newTestPipe(1000, PipeOptions{})
+	writers := make([]*countingWriter, 4)
+	for i := range writers {
+		writers[i] = &countingWriter{}
+		p.attach(fmt.Sprintf("out%d", i), writers[i])
+	}
+	p.start()
+	p.wait()
+	for i, w := range writers {
+		if w.packets != 1000 {
+			t.Errorf("output %d got %d packets", i, w.packets)
+		}
+	}
+}
+
+func TestPipeDetachReleases(t *testing.T) {
+	p := newTestPipe(100, PipeOptions{OnError: ErrorPolicyDetach})
+	r := &recordingReader{PacketReader: p.reader}
+	p.reader = r
+	failing := &failingWriter{release: make(chan struct{})}
+	p.attach("failing", failing)
+	p.attach("counting", &countingWriter{})
+	p.start()
+	<-p.done
+	// the failing output is detached with the packets left in its buffer
+	close(failing.release)
+	p.wait()
+	if dropped := p.counters.output("failing").dropped; dropped != 100 {
+		t.Errorf("%d packets dropped", dropped)
+	}
+	for i, pkt := range r.packets {
+		if refs := atomic.LoadInt32(&pkt.refs); refs != 0 {
+			t.Fatalf("packet %d still has %d references", i, refs)
+		}
+	}
+}
+
+// waitFor polls cond until it holds or fails the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for " + what)
+		}
+	}
+}
+
+func TestPipeRetryDetached(t *testing.T) {
+	// the write is retried forever with a delay long enough for the test to time out
+	p := newTestPipe(0, PipeOptions{RetryBackoff: 60000})
+	reader := &blockingReader{n: 10, release: make(chan struct{})}
+	defer close(reader.release)
+	p.reader = reader
+	failing := &failingWriter{release: make(chan struct{})}
+	close(failing.release)
+	p.attach("failing", failing)
+	o := p.outputs()[0]
+	p.start()
+	waitFor(t, "the first write error", func() bool { return atomic.LoadUint64(&o.counters.writeErrors) > 0 })
+	p.detachID("failing")
+	select {
+	case <-o.done:
+	case <-time.After(time.Second):
+		t.Fatal("the output is still retrying after it was detached")
+	}
+	if errs := atomic.LoadUint64(&o.counters.writeErrors); errs != 1 {
+		t.Errorf("the write was attempted %d times", errs)
+	}
+}
+
+func TestPipeRetryStopped(t *testing.T) {
+	p := newTestPipe(0, PipeOptions{RetryBackoff: 60000})
+	reader := &blockingReader{release: make(chan struct{})}
+	close(reader.release)
+	p.reader = reader
+	p.attach("counting", &countingWriter{})
+	p.start()
+	waitFor(t, "the first read error", func() bool { return atomic.LoadUint64(&p.counters.readErrors) > 0 })
+	p.stop()
+	select {
+	case <-p.done:
+	case <-time.After(time.Second):
+		t.Fatal("the input is still retrying after the pipe was stopped")
+	}
+}
+
+func BenchmarkPipeFanOut(b *testing.B) {
+	for _, outputs := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("outputs=%d", outputs), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(DefaultReadSize)
+			p := newTestPipe(b.N, PipeOptions{OutputOptions: OutputOptions{BufferSize: 1024}})
+			for i := 0; i < outputs; i++ {
+				p.attach(fmt.Sprintf("out%d", i), &countingWriter{})
+			}
+			b.ResetTimer()
+			p.start()
+			p.wait()
+		})
+	}
+}
@@ -1,9 +1,10 @@
 package server
 
+// Process is both a destination and a source of data, it must implement PacketWriter or io.Writer, and PacketReader or
+// io.Reader
 type Process interface {
 	Init() error
-	Read(p []byte) (int, error)
-	Write(p []byte) (int, error)
+	Close() error
 }
 
 type ProcessRegisterFunc func(server *Server, id string, options map[string]interface{}) (Process, error)
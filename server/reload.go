@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"strings"
+)
+
+// Reload reconciles the components and the pipes of the server with the config. The components and the pipes missing
+// from the config are removed, the changed ones are re-created and the new ones are added, everything else keeps
+// running untouched. A pipe whose outputs are the only change keeps running and only the changed outputs are attached
+// or detached, the instances of a changed template are re-created though. The config is validated first and nothing
+// is changed if it is invalid, otherwise Reload goes on after a failed change and returns all the errors at the end.
+func (s *Server) Reload(config *Config) error {
+	if err := s.Validate(config); err != nil {
+		return err
+	}
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	var errs []string
+	fail := func(err error) {
+		s.logger.WithError(err).Error("failed to reload")
+		errs = append(errs, err.Error())
+	}
+
+	type wantedComponent struct {
+		kind   Kind
+		config ComponentConfig
+	}
+	wanted := make(map[string]wantedComponent)
+	lists := map[Kind][]ComponentConfig{
+		KindInbound:  config.Inbounds,
+		KindOutbound: config.Outbounds,
+		KindProcess:  config.Processes,
+	}
+	for _, kind := range kinds {
+		for _, c := range lists[kind] {
+			wanted[c.ID] = wantedComponent{kind, c}
+		}
+	}
+
+	// removing a component drops it from the outputs of the pipes, so the pipes are compared with their config as it
+	// was before. The configs are copied by value, since remove and setOutputs replace their slices and maps.
+	configs := make(map[string]PipeConfig)
+	s.mux.RLock()
+	for id, e := range s.pipes {
+		configs[id] = e.config
+	}
+	s.mux.RUnlock()
+
+	// remove the stale and the changed components first, the pipes reading from them are removed as well
+	for _, kind := range kinds {
+		for _, c := range s.componentsOf(kind) {
+			w, ok := wanted[c.config.ID]
+			if ok && w.kind == c.kind && reflect.DeepEqual(w.config, c.config) {
+				continue
+			}
+			s.remove(c)
+			if ok {
+				s.logger.WithField(string(c.kind), c.config.ID).Info(string(c.kind) + " changed, re-creating")
+			} else {
+				s.logger.WithField(string(c.kind), c.config.ID).Info(string(c.kind) + " removed")
+			}
+		}
+	}
+	for _, kind := range kinds {
+		for _, c := range lists[kind] {
+			if _, err := s.component(c.ID); err == nil {
+				continue
+			}
+			if err := s.add(kind, c); err != nil {
+				fail(fmt.Errorf("failed to add %s %s: %w", kind, c.ID, err))
+				continue
+			}
+			s.logger.WithFields(log.Fields{string(kind): c.ID, "type": c.Type}).Info(string(kind) + " added")
+		}
+	}
+
+	wantedPipes := make(map[string]PipeConfig)
+	for _, p := range config.Pipes {
+		if p.ID == "" {
+			p.ID = p.In
+		}
+		// fill in the defaults to compare with the running pipes, the options are already validated
+		p.PipeOptions.validate()
+		wantedPipes[p.ID] = p
+	}
+	for _, e := range s.pipeEntries() {
+		if e.template != "" {
+			// the instances follow their template
+			continue
+		}
+		s.mux.RLock()
+		current := e.config
+		s.mux.RUnlock()
+		previous := configs[current.ID]
+		w, ok := wantedPipes[current.ID]
+		switch {
+		case !ok:
+			s.removePipe(e)
+			s.logger.WithField("pipe", current.ID).Info("pipe removed")
+		case w.In != previous.In || !reflect.DeepEqual(w.PipeOptions, previous.PipeOptions) ||
+			isTemplate(current) && !equal(w.Outs, current.Outs):
+			s.removePipe(e)
+			s.logger.WithField("pipe", current.ID).Info("pipe changed, re-creating")
+		case !equal(w.Outs, current.Outs) || !reflect.DeepEqual(w.Outputs, current.Outputs):
+			if err := s.setOutputs(e, w.Outs, w.Outputs); err != nil {
+				fail(fmt.Errorf("failed to update pipe %s: %w", current.ID, err))
+				continue
+			}
+			s.logger.WithFields(log.Fields{"pipe": current.ID, "outs": w.Outs}).Info("pipe outputs changed")
+		}
+	}
+	for _, p := range config.Pipes {
+		id := p.ID
+		if id == "" {
+			id = p.In
+		}
+		if _, err := s.pipeEntry(id); err == nil {
+			continue
+		}
+		if err := s.addPipe(id, p.In, p.Outs, &p.PipeOptions); err != nil {
+			fail(fmt.Errorf("failed to add pipe %s: %w", id, err))
+			continue
+		}
+		s.logger.WithFields(log.Fields{"pipe": id, "in": p.In, "outs": p.Outs}).Info("pipe added")
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// setOutputs replaces the outputs of a pipe and their options, the outputs kept are not interrupted. opMux must be
+// held.
+func (s *Server) setOutputs(e *pipeEntry, outs []string, outputs map[string]OutputOptions) error {
+	s.mux.Lock()
+	for _, out := range outs {
+		if !s.hasWriterLocked(out) {
+			s.mux.Unlock()
+			return errors.New("unknown outbound: " + out)
+		}
+	}
+	old := e.config.Outs
+	e.config.Outs = append([]string{}, outs...)
+	e.config.Outputs = outputs
+	p, stopped := e.pipe, e.stopped
+	s.mux.Unlock()
+
+	if p == nil || !p.running() {
+		if !stopped && s.running {
+			s.startPipe(e)
+		}
+		return nil
+	}
+	// attach the new outputs before detaching the old ones so the pipe is never left without outputs
+	for _, out := range outs {
+		if contains(old, out) || !s.isRunning(out) {
+			continue
+		}
+		if w, ok := s.writer(out); ok {
+			p.attach(out, w)
+		}
+	}
+	for _, out := range old {
+		if !contains(outs, out) {
+			p.detachID(out)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fakeComponent is an inbound and an outbound doing nothing
+type fakeComponent struct{}
+
+func (fakeComponent) Init() error                 { return nil }
+func (fakeComponent) Close() error                { return nil }
+func (fakeComponent) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (fakeComponent) Write(p []byte) (int, error) { return len(p), nil }
+
+// keyframeComponent is an inbound flagging the keyframes
+type keyframeComponent struct{ fakeComponent }
+
+func (keyframeComponent) ReadPacket() (*Packet, error) { return nil, io.EOF }
+func (keyframeComponent) FlagsKeyframes() bool         { return true }
+
+// newFakeServer returns a server with the "fake" inbound, outbound and process types. The "tracks" types provide the
+// "video" and "audio" tracks, and the "keyframes" inbound flags the keyframes.
+func newFakeServer() *Server {
+	s := New()
+	s.logger.Logger.SetOutput(ioutil.Discard)
+	s.RegisterInbound("fake", func(*Server, string, map[string]interface{}) (Inbound, error) {
+		return fakeComponent{}, nil
+	})
+	s.RegisterOutbound("fake", func(*Server, string, map[string]interface{}) (Outbound, error) {
+		return fakeComponent{}, nil
+	})
+	s.RegisterProcess("fake", func(*Server, string, map[string]interface{}) (Process, error) {
+		return fakeComponent{}, nil
+	})
+	s.RegisterInbound("keyframes", func(*Server, string, map[string]interface{}) (Inbound, error) {
+		return keyframeComponent{}, nil
+	})
+	s.RegisterInbound("tracks", func(s *Server, id string, _ map[string]interface{}) (Inbound, error) {
+		s.AddReader(id+":video", fakeComponent{})
+		s.AddReader(id+":audio", fakeComponent{})
+		return fakeComponent{}, nil
+	})
+	s.RegisterOutbound("tracks", func(s *Server, id string, _ map[string]interface{}) (Outbound, error) {
+		s.AddWriter(id+":video", fakeComponent{})
+		s.AddWriter(id+":audio", fakeComponent{})
+		return fakeComponent{}, nil
+	})
+	return s
+}
+
+func newReloadServer(t *testing.T, config *Config) *Server {
+	s := newFakeServer()
+	if err := s.Apply(config); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func reloadConfig() *Config {
+	fake := func(id string) ComponentConfig {
+		return ComponentConfig{ID: id, Type: "fake", Options: map[string]interface{}{"v": 1}}
+	}
+	return &Config{
+		Inbounds:  []ComponentConfig{fake("in1"), fake("in2")},
+		Outbounds: []ComponentConfig{fake("a"), fake("b"), fake("c")},
+		Pipes: []PipeConfig{
+			{In: "in1", Outs: []string{"a", "b"}, PipeOptions: PipeOptions{Outputs: map[string]OutputOptions{"b": {BufferSize: 10}}}},
+			{ID: "p2", In: "in2", Outs: []string{"c"}},
+		},
+	}
+}
+
+func TestReloadDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		// kept lists the pipes left running untouched
+		kept []string
+	}{
+		{name: "unchanged", change: func(c *Config) {}, kept: []string{"in1", "p2"}},
+		{name: "output with options changed", change: func(c *Config) { c.Outbounds[1].Options["v"] = 2 }, kept: []string{"in1", "p2"}},
+		{name: "output changed", change: func(c *Config) { c.Outbounds[0].Options["v"] = 2 }, kept: []string{"in1", "p2"}},
+		{
+			name: "output removed",
+			change: func(c *Config) {
+				c.Outbounds = c.Outbounds[1:]
+				c.Pipes[0].Outs = []string{"b"}
+			},
+			kept: []string{"in1", "p2"},
+		},
+		{
+			name: "output added",
+			change: func(c *Config) {
+				c.Outbounds = append(c.Outbounds, ComponentConfig{ID: "d", Type: "fake"})
+				c.Pipes[0].Outs = []string{"a", "b", "d"}
+			},
+			kept: []string{"in1", "p2"},
+		},
+		{name: "outs reordered", change: func(c *Config) { c.Pipes[0].Outs = []string{"b", "a"} }, kept: []string{"in1", "p2"}},
+		{name: "output options changed", change: func(c *Config) { c.Pipes[0].Outputs["b"] = OutputOptions{BufferSize: 20} }, kept: []string{"p2"}},
+		{name: "options changed", change: func(c *Config) { c.Pipes[0].MaxRetries = 3 }, kept: []string{"p2"}},
+		{name: "input changed", change: func(c *Config) { c.Inbounds[0].Options["v"] = 2 }, kept: []string{"p2"}},
+		{
+			name: "input switched",
+			change: func(c *Config) {
+				c.Inbounds[1].ID = "in3"
+				c.Pipes[1].In = "in3"
+			},
+			kept: []string{"in1"},
+		},
+		{
+			name: "pipe removed",
+			change: func(c *Config) {
+				c.Inbounds = c.Inbounds[:1]
+				c.Outbounds = c.Outbounds[:2]
+				c.Pipes = c.Pipes[:1]
+			},
+			kept: []string{"in1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newReloadServer(t, reloadConfig())
+			before := make(map[string]*pipeEntry)
+			for id, e := range s.pipes {
+				before[id] = e
+			}
+			config := reloadConfig()
+			tt.change(config)
+			if err := s.Reload(config); err != nil {
+				t.Fatal(err)
+			}
+
+			var kept []string
+			for id, e := range s.pipes {
+				if before[id] == e {
+					kept = append(kept, id)
+				}
+			}
+			sort.Strings(kept)
+			if !reflect.DeepEqual(kept, tt.kept) {
+				t.Errorf("kept the pipes %v, want %v", kept, tt.kept)
+			}
+			if len(s.pipes) != len(config.Pipes) {
+				t.Errorf("got %d pipes, want %d", len(s.pipes), len(config.Pipes))
+			}
+			for _, want := range config.Pipes {
+				if want.ID == "" {
+					want.ID = want.In
+				}
+				want.PipeOptions.validate()
+				e, ok := s.pipes[want.ID]
+				if !ok {
+					t.Errorf("pipe %s is missing", want.ID)
+					continue
+				}
+				if !reflect.DeepEqual(e.config, want) {
+					t.Errorf("pipe %s: got the config %+v, want %+v", want.ID, e.config, want)
+				}
+			}
+		})
+	}
+}
@@ -1,33 +1,133 @@
 package server
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
 	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Kind is the kind of a component
+type Kind string
+
+const (
+	KindInbound  Kind = "inbound"
+	KindOutbound Kind = "outbound"
+	KindProcess  Kind = "process"
+)
+
+// kinds lists the kinds in the order the components are started
+var kinds = []Kind{KindInbound, KindOutbound, KindProcess}
+
+// State is the running state of a component or a pipe
+type State string
+
+const (
+	StateStopped State = "stopped"
+	StateRunning State = "running"
+	// StateFailed means the component failed to start, or the pipe is stopped by its error policy
+	StateFailed State = "failed"
+)
+
+var (
+	// ErrNotFound is returned when a component or a pipe does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when the id of a new component or pipe is already used
+	ErrExists = errors.New("already exists")
+	// ErrServerClosed is returned by the operations performed after Shutdown
+	ErrServerClosed = errors.New("server closed")
+)
+
+// instance is the common part of Inbound, Outbound and Process
+type instance interface {
+	Init() error
+	Close() error
+}
+
+// component keeps the config and the instance of an inbound, an outbound or a process
+type component struct {
+	kind     Kind
+	config   ComponentConfig
+	instance instance
+	state    State
+	err      error
+	// closed is set once the instance is closed, a new instance is created to start the component again
+	closed    bool
+	starts    uint64
+	startedAt time.Time
+}
+
+// pipeEntry keeps the config of a pipe and the pipe started from it
+type pipeEntry struct {
+	config PipeConfig
+	pipe   *pipe
+	// stopped is set when the pipe is stopped explicitly, it is not started with its input any more
+	stopped  bool
+	counters *pipeCounters
+	// template is the id of the template the pipe is instantiated from for a stream
+	template string
+}
+
+// ComponentStatus describes a component
+type ComponentStatus struct {
+	ComponentConfig
+	Kind  Kind   `json:"kind"`
+	State State  `json:"state"`
+	Error string `json:"error,omitempty"`
+	// Details is the status reported by a running component implementing StatusReporter
+	Details interface{} `json:"details,omitempty"`
+}
+
+// PipeStatus describes a pipe
+type PipeStatus struct {
+	PipeConfig
+	State State `json:"state"`
+	// Attached lists the outputs currently fed by the pipe
+	Attached []string `json:"attached"`
+	// Template is the id of the template the pipe is instantiated from
+	Template string `json:"template,omitempty"`
+}
+
 type Server struct {
 	registeredInbound  map[string]InboundRegisterFunc
 	registeredOutbound map[string]OutboundRegisterFunc
 	registeredProcess  map[string]ProcessRegisterFunc
-	inbounds           map[string]Inbound
-	outbounds          map[string]Outbound
-	processes          map[string]Process
-	readers            map[string]io.Reader
-	writers            map[string]io.Writer
-	pipes              map[io.Reader][]chan []byte
+	components         map[string]*component
+	readers            map[string]PacketReader
+	writers            map[string]PacketWriter
+	pipes              map[string]*pipeEntry
+	services           map[string]interface{}
+	// mux guards the maps and the components, opMux serializes the operations adding, removing, starting and stopping
+	// the components and the pipes
+	mux          sync.RWMutex
+	opMux        sync.Mutex
+	running      bool
+	closed       bool
+	closing      chan struct{}
+	shutdownOnce sync.Once
+	shutdownErr  error
+	events       *eventBus
+	logger       *log.Entry
 }
 
 func New() *Server {
 	return &Server{
-		make(map[string]InboundRegisterFunc),
-		make(map[string]OutboundRegisterFunc),
-		make(map[string]ProcessRegisterFunc),
-		make(map[string]Inbound),
-		make(map[string]Outbound),
-		make(map[string]Process),
-		make(map[string]io.Reader),
-		make(map[string]io.Writer),
-		make(map[io.Reader][]chan []byte),
+		registeredInbound:  make(map[string]InboundRegisterFunc),
+		registeredOutbound: make(map[string]OutboundRegisterFunc),
+		registeredProcess:  make(map[string]ProcessRegisterFunc),
+		components:         make(map[string]*component),
+		readers:            make(map[string]PacketReader),
+		writers:            make(map[string]PacketWriter),
+		pipes:              make(map[string]*pipeEntry),
+		services:           make(map[string]interface{}),
+		closing:            make(chan struct{}),
+		events:             newEventBus(),
+		logger:             log.New().WithFields(log.Fields{"module": "Server"}),
 	}
 }
 
@@ -35,140 +135,800 @@ func (s *Server) RegisterInbound(name string, regFunc InboundRegisterFunc) {
 	s.registeredInbound[name] = regFunc
 }
 
+// AddReader adds a byte stream input, it is read in packets of DefaultReadSize bytes
 func (s *Server) AddReader(id string, o io.Reader) {
+	s.AddPacketReader(id, NewPacketReader(o, DefaultReadSize))
+}
+
+// AddPacketReader adds an input reading whole packets
+func (s *Server) AddPacketReader(id string, o PacketReader) {
+	s.mux.Lock()
 	s.readers[id] = o
+	s.mux.Unlock()
 }
 
+// AddInbound adds a new inbound, it is started immediately if the server is running
 func (s *Server) AddInbound(id, typ string, options map[string]interface{}) error {
-	f, ok := s.registeredInbound[typ]
-	if !ok {
-		return errors.New("unknown inbound: " + typ)
-	}
-	o, err := f(s, id, options)
-	if err != nil {
-		return err
-	}
-	s.inbounds[id] = o
-	s.AddReader(id, o)
-	return nil
+	return s.addComponent(KindInbound, ComponentConfig{ID: id, Type: typ, Options: options})
 }
 
 func (s *Server) RegisterOutbound(name string, regFunc OutboundRegisterFunc) {
 	s.registeredOutbound[name] = regFunc
 }
 
+// AddWriter adds a byte stream output, the payload of each packet is written to it
 func (s *Server) AddWriter(id string, o io.Writer) {
+	s.AddPacketWriter(id, NewPacketWriter(o))
+}
+
+// AddPacketWriter adds an output writing whole packets
+func (s *Server) AddPacketWriter(id string, o PacketWriter) {
+	s.mux.Lock()
 	s.writers[id] = o
+	s.mux.Unlock()
 }
 
+// AddOutbound adds a new outbound, it is started immediately if the server is running
 func (s *Server) AddOutbound(id, typ string, options map[string]interface{}) error {
-	f, ok := s.registeredOutbound[typ]
-	if !ok {
-		return errors.New("unknown outbound: " + typ)
+	return s.addComponent(KindOutbound, ComponentConfig{ID: id, Type: typ, Options: options})
+}
+
+func (s *Server) RegisterProcess(name string, regFunc ProcessRegisterFunc) {
+	s.registeredProcess[name] = regFunc
+}
+
+// AddProcess adds a new process, it is started immediately if the server is running
+func (s *Server) AddProcess(id, typ string, options map[string]interface{}) error {
+	return s.addComponent(KindProcess, ComponentConfig{ID: id, Type: typ, Options: options})
+}
+
+func (s *Server) addComponent(kind Kind, config ComponentConfig) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
 	}
-	o, err := f(s, id, options)
-	if err != nil {
+	return s.add(kind, config)
+}
+
+// add creates a new component and starts it if the server is running, opMux must be held
+func (s *Server) add(kind Kind, config ComponentConfig) error {
+	if config.ID == "" {
+		return errors.New("missing " + string(kind) + " id")
+	}
+	s.mux.RLock()
+	_, exists := s.components[config.ID]
+	s.mux.RUnlock()
+	if exists {
+		return fmt.Errorf("component %s %w", config.ID, ErrExists)
+	}
+
+	c := &component{kind: kind, config: config, state: StateStopped}
+	if err := s.create(c); err != nil {
 		return err
 	}
-	s.outbounds[id] = o
-	s.AddWriter(id, o)
+	s.mux.Lock()
+	s.components[config.ID] = c
+	s.mux.Unlock()
+	if s.running {
+		return s.startComponent(c)
+	}
 	return nil
 }
 
-func (s *Server) RegisterProcess(name string, regFunc ProcessRegisterFunc) {
-	s.registeredProcess[name] = regFunc
+// create creates a new instance of the component with its register function
+func (s *Server) create(c *component) error {
+	id, typ, options := c.config.ID, c.config.Type, c.config.Options
+	var inst instance
+	switch c.kind {
+	case KindInbound:
+		f, ok := s.registeredInbound[typ]
+		if !ok {
+			return errors.New("unknown inbound: " + typ)
+		}
+		i, err := f(s, id, options)
+		if err != nil {
+			return err
+		}
+		r, ok := packetReaderOf(i)
+		if !ok {
+			return errors.New("inbound " + typ + " implements neither PacketReader nor io.Reader")
+		}
+		s.AddPacketReader(id, r)
+		inst = i
+	case KindOutbound:
+		f, ok := s.registeredOutbound[typ]
+		if !ok {
+			return errors.New("unknown outbound: " + typ)
+		}
+		o, err := f(s, id, options)
+		if err != nil {
+			return err
+		}
+		w, ok := packetWriterOf(o)
+		if !ok {
+			return errors.New("outbound " + typ + " implements neither PacketWriter nor io.Writer")
+		}
+		s.AddPacketWriter(id, w)
+		inst = o
+	case KindProcess:
+		f, ok := s.registeredProcess[typ]
+		if !ok {
+			return errors.New("unknown process: " + typ)
+		}
+		p, err := f(s, id, options)
+		if err != nil {
+			return err
+		}
+		r, ok := packetReaderOf(p)
+		if !ok {
+			return errors.New("process " + typ + " implements neither PacketReader nor io.Reader")
+		}
+		w, ok := packetWriterOf(p)
+		if !ok {
+			return errors.New("process " + typ + " implements neither PacketWriter nor io.Writer")
+		}
+		s.AddPacketReader(id, r)
+		s.AddPacketWriter(id, w)
+		inst = p
+	}
+	s.mux.Lock()
+	c.instance = inst
+	c.closed = false
+	s.mux.Unlock()
+	return nil
 }
 
-func (s *Server) AddProcess(id, typ string, options map[string]interface{}) error {
-	f, ok := s.registeredProcess[typ]
-	if !ok {
-		return errors.New("unknown process: " + typ)
+func (s *Server) setState(c *component, state State, err error) {
+	s.mux.Lock()
+	c.state = state
+	c.err = err
+	s.mux.Unlock()
+}
+
+// startComponent initializes the component and connects it to its pipes
+func (s *Server) startComponent(c *component) error {
+	if c.state == StateRunning {
+		return nil
+	}
+	logger := s.logger.WithFields(log.Fields{string(c.kind): c.config.ID})
+	if c.closed {
+		if err := s.create(c); err != nil {
+			s.setState(c, StateFailed, err)
+			logger.WithError(err).Error("failed to create " + string(c.kind))
+			s.events.publish(Event{Type: EventComponentFailed, Component: c.config.ID, Error: err.Error()})
+			return err
+		}
+	}
+	if err := c.instance.Init(); err != nil {
+		s.setState(c, StateFailed, err)
+		// release whatever is initialized, a new instance is created on the next start
+		c.instance.Close()
+		s.mux.Lock()
+		c.closed = true
+		s.mux.Unlock()
+		logger.WithError(err).Error("failed to start " + string(c.kind))
+		s.events.publish(Event{Type: EventComponentFailed, Component: c.config.ID, Error: err.Error()})
+		return err
+	}
+	s.mux.Lock()
+	c.state = StateRunning
+	c.err = nil
+	c.starts++
+	c.startedAt = time.Now()
+	s.mux.Unlock()
+	s.events.publish(Event{Type: EventComponentStarted, Component: c.config.ID})
+	if s.running {
+		s.connect(c.config.ID)
+	}
+	return nil
+}
+
+// stopComponent disconnects the component from its pipes and closes it
+func (s *Server) stopComponent(c *component) error {
+	if c.state != StateRunning {
+		s.setState(c, StateStopped, nil)
+		return nil
+	}
+	inputs := s.disconnect(c.config.ID)
+	err := c.instance.Close()
+	s.mux.Lock()
+	c.closed = true
+	s.mux.Unlock()
+	// the pipes reading from the component exit once the pending read fails
+	for _, p := range inputs {
+		<-p.done
+	}
+	s.removeStreams(c.config.ID)
+	s.setState(c, StateStopped, nil)
+	s.events.publish(Event{Type: EventComponentStopped, Component: c.config.ID})
+	return err
+}
+
+// ownerOf returns the id of the component providing the reader or the writer, e.g. "webrtc-out" for "webrtc-out:video"
+// and "srt-in" for "srt-in/live/cam1"
+func (s *Server) ownerOf(id string) string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.ownerOfLocked(id)
+}
+
+func (s *Server) ownerOfLocked(id string) string {
+	if _, ok := s.components[id]; ok {
+		return id
+	}
+	if i := strings.IndexAny(id, ":/"); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// isRunning reports whether the component providing the reader or the writer is running
+func (s *Server) isRunning(id string) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	c, ok := s.components[s.ownerOfLocked(id)]
+	return ok && c.state == StateRunning
+}
+
+// connect starts the pipes reading from the component and attaches the component to the running pipes
+func (s *Server) connect(id string) {
+	for _, e := range s.pipeEntries() {
+		if e.stopped {
+			continue
+		}
+		if s.ownerOf(e.config.In) == id {
+			s.startPipe(e)
+			continue
+		}
+		if e.pipe == nil || !e.pipe.running() {
+			continue
+		}
+		for _, out := range e.config.Outs {
+			if s.ownerOf(out) != id {
+				continue
+			}
+			if w, ok := s.writer(out); ok {
+				e.pipe.attach(out, w)
+			}
+		}
+	}
+}
+
+// disconnect stops the pipes reading from the component and detaches the component from the other pipes, the stopped
+// pipes are returned
+func (s *Server) disconnect(id string) []*pipe {
+	var inputs []*pipe
+	for _, e := range s.pipeEntries() {
+		if e.pipe == nil {
+			continue
+		}
+		if s.ownerOf(e.config.In) == id {
+			s.stopPipe(e)
+			inputs = append(inputs, e.pipe)
+			continue
+		}
+		for _, out := range e.config.Outs {
+			if s.ownerOf(out) == id {
+				e.pipe.detachID(out)
+			}
+		}
+	}
+	return inputs
+}
+
+// StartComponent starts a stopped or failed component
+func (s *Server) StartComponent(id string) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	c, err := s.component(id)
+	if err != nil {
+		return err
+	}
+	if !s.running {
+		return errors.New("server is not running")
+	}
+	return s.startComponent(c)
+}
+
+// StopComponent stops a component, the pipes reading from it are stopped and it is detached from the other pipes
+func (s *Server) StopComponent(id string) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	c, err := s.component(id)
+	if err != nil {
+		return err
+	}
+	return s.stopComponent(c)
+}
+
+// RemoveComponent stops and removes a component, the pipes reading from it are removed and it is removed from the
+// outputs of the other pipes
+func (s *Server) RemoveComponent(id string) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
 	}
-	p, err := f(s, id, options)
+	c, err := s.component(id)
 	if err != nil {
 		return err
 	}
-	s.processes[id] = p
-	s.AddReader(id, p)
-	s.AddWriter(id, p)
+	s.remove(c)
 	return nil
 }
 
-func (s *Server) AddPipe(in string, outs []string) error {
-	i, ok := s.readers[in]
+// remove stops and removes a component, opMux must be held
+func (s *Server) remove(c *component) {
+	id := c.config.ID
+	if err := s.stopComponent(c); err != nil {
+		s.logger.WithError(err).WithFields(log.Fields{string(c.kind): id}).Warn("failed to close " + string(c.kind))
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for pid, e := range s.pipes {
+		if s.ownerOfLocked(e.config.In) == id {
+			delete(s.pipes, pid)
+			continue
+		}
+		outs := []string{}
+		outputs := make(map[string]OutputOptions)
+		for _, out := range e.config.Outs {
+			if s.ownerOfLocked(out) != id {
+				outs = append(outs, out)
+				if options, ok := e.config.Outputs[out]; ok {
+					outputs[out] = options
+				}
+			}
+		}
+		e.config.Outs = outs
+		if e.config.Outputs != nil {
+			e.config.Outputs = outputs
+		}
+	}
+	for r := range s.readers {
+		if r == id || strings.HasPrefix(r, id+":") {
+			delete(s.readers, r)
+		}
+	}
+	for w := range s.writers {
+		if w == id || strings.HasPrefix(w, id+":") || strings.HasPrefix(w, id+"/") {
+			delete(s.writers, w)
+		}
+	}
+	delete(s.components, id)
+}
+
+func (s *Server) component(id string) (*component, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	c, ok := s.components[id]
 	if !ok {
+		return nil, fmt.Errorf("component %s %w", id, ErrNotFound)
+	}
+	return c, nil
+}
+
+// Component returns the status of a component
+func (s *Server) Component(id string) (ComponentStatus, error) {
+	c, err := s.component(id)
+	if err != nil {
+		return ComponentStatus{}, err
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return c.status(), nil
+}
+
+// Components returns the status of all the components of the kind, sorted by id
+func (s *Server) Components(kind Kind) []ComponentStatus {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	res := []ComponentStatus{}
+	for _, c := range s.components {
+		if c.kind == kind {
+			res = append(res, c.status())
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (c *component) status() ComponentStatus {
+	res := ComponentStatus{ComponentConfig: c.config, Kind: c.kind, State: c.state}
+	if c.err != nil {
+		res.Error = c.err.Error()
+	}
+	if r, ok := c.instance.(StatusReporter); ok && c.state == StateRunning {
+		res.Details = r.Status()
+	}
+	return res
+}
+
+// AddPipe feeds the data read from in to all the outs, the id defaults to in and the default options are used if
+// options is nil. The pipe is started immediately if the server is running.
+func (s *Server) AddPipe(id, in string, outs []string, options *PipeOptions) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	return s.addPipe(id, in, outs, options)
+}
+
+// addPipe creates a new pipe and starts it if the server is running, opMux must be held
+func (s *Server) addPipe(id, in string, outs []string, options *PipeOptions) error {
+	if id == "" {
+		id = in
+	}
+	opt := PipeOptions{}
+	if options != nil {
+		opt = *options
+	}
+	if err := opt.validate(); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	if _, ok := s.pipes[id]; ok {
+		s.mux.Unlock()
+		return fmt.Errorf("pipe %s %w", id, ErrExists)
+	}
+	// the inputs and the outputs of the templates are checked with the graph
+	if _, ok := s.readers[in]; !ok && !strings.Contains(in, StreamPlaceholder) {
+		s.mux.Unlock()
 		return errors.New("unknown inbound: " + in)
 	}
-	var outChannels []chan []byte
 	for _, out := range outs {
-		o, ok := s.writers[out]
-		if !ok {
+		if !s.hasWriterLocked(out) && !strings.Contains(out, StreamPlaceholder) {
+			s.mux.Unlock()
 			return errors.New("unknown outbound: " + out)
 		}
-		// allocate a new channel and start a pipe goroutine
-		c := make(chan []byte, 102400)
-		go pipeWrite(o, c)
-		outChannels = append(outChannels, c)
 	}
-	s.pipes[i] = outChannels
+	config := PipeConfig{
+		ID:          id,
+		In:          in,
+		Outs:        append([]string(nil), outs...),
+		PipeOptions: opt,
+	}
+	pipes := []PipeConfig{config}
+	for _, e := range s.pipes {
+		// the instances are checked through their template
+		if e.template == "" {
+			pipes = append(pipes, e.config)
+		}
+	}
+	s.mux.Unlock()
+	if errs := s.checkGraph(pipes, false); len(errs) > 0 {
+		return &ValidationError{errs}
+	}
+
+	e := &pipeEntry{config: config, counters: newPipeCounters()}
+	s.mux.Lock()
+	s.pipes[id] = e
+	s.mux.Unlock()
+
+	if isTemplate(config) {
+		s.instantiateAll(e)
+	} else if s.running {
+		s.startPipe(e)
+	}
 	return nil
 }
 
-func (s *Server) Run() error {
-	for _, i := range s.inbounds {
-		err := i.Init()
-		if err != nil {
-			return err
+// flagsKeyframes reports whether a reader sets the Keyframe flag of its packets
+func flagsKeyframes(r PacketReader) bool {
+	f, ok := r.(KeyframeFlagger)
+	return ok && f.FlagsKeyframes()
+}
+
+// startPipe starts the pipe if its input is running, the running outputs are attached. The templates are never
+// started, their instances are.
+func (s *Server) startPipe(e *pipeEntry) {
+	if e.pipe != nil && e.pipe.running() {
+		return
+	}
+	if isTemplate(e.config) || !s.isRunning(e.config.In) {
+		return
+	}
+	s.mux.RLock()
+	reader, ok := s.readers[e.config.In]
+	s.mux.RUnlock()
+	if !ok {
+		return
+	}
+	p := newPipe(e.config.ID, e.config.In, reader, e.config.PipeOptions, s.closing, e.counters, s.events, s.logger)
+	for _, out := range e.config.Outs {
+		if !s.isRunning(out) {
+			continue
+		}
+		if w, ok := s.writer(out); ok {
+			p.attach(out, w)
 		}
 	}
+	p.start()
+	s.mux.Lock()
+	e.pipe = p
+	s.mux.Unlock()
+}
 
-	for _, o := range s.outbounds {
-		err := o.Init()
-		if err != nil {
-			return err
-		}
+// stopPipe detaches all the outputs and makes the read goroutine exit after the pending read
+func (s *Server) stopPipe(e *pipeEntry) {
+	if e.pipe == nil {
+		return
+	}
+	e.pipe.stop()
+	for _, o := range e.pipe.outputs() {
+		e.pipe.detach(o)
 	}
+}
 
-	for _, p := range s.processes {
-		err := p.Init()
-		if err != nil {
-			return err
-		}
+// StartPipe starts a stopped pipe, it is started again along with its input from now on
+func (s *Server) StartPipe(id string) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	e, err := s.pipeEntry(id)
+	if err != nil {
+		return err
+	}
+	if !s.running {
+		return errors.New("server is not running")
 	}
+	s.mux.Lock()
+	e.stopped = false
+	s.mux.Unlock()
+	if !s.isRunning(e.config.In) {
+		return errors.New("input " + e.config.In + " is not running")
+	}
+	s.startPipe(e)
+	return nil
+}
 
-	for in, channels := range s.pipes {
-		go pipeRead(in, channels)
+// StopPipe stops a pipe, it is not started again along with its input until StartPipe is called
+func (s *Server) StopPipe(id string) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	e, err := s.pipeEntry(id)
+	if err != nil {
+		return err
 	}
+	s.mux.Lock()
+	e.stopped = true
+	s.mux.Unlock()
+	s.stopPipe(e)
+	return nil
+}
 
+// RemovePipe stops and removes a pipe
+func (s *Server) RemovePipe(id string) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	e, err := s.pipeEntry(id)
+	if err != nil {
+		return err
+	}
+	s.removePipe(e)
 	return nil
 }
 
-func pipeWrite(o io.Writer, c chan []byte) {
-	for {
-		// fetch a buffer from the channel and write to the outbound
-		_, err := o.Write(<-c)
-		if err != nil {
-			panic(err)
+// removePipe stops and removes a pipe along with its instances if it is a template, opMux must be held
+func (s *Server) removePipe(e *pipeEntry) {
+	s.stopPipe(e)
+	s.mux.Lock()
+	delete(s.pipes, e.config.ID)
+	s.mux.Unlock()
+	if isTemplate(e.config) {
+		for _, i := range s.pipeEntries() {
+			if i.template == e.config.ID {
+				s.removePipe(i)
+			}
 		}
 	}
 }
 
-func pipeRead(in io.Reader, channels []chan []byte) {
-	for {
-		// read from inbound
-		buffer := make([]byte, 1316)
-		n, err := in.Read(buffer)
-		if n == 0 {
-			continue
+func (s *Server) pipeEntry(id string) (*pipeEntry, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	e, ok := s.pipes[id]
+	if !ok {
+		return nil, fmt.Errorf("pipe %s %w", id, ErrNotFound)
+	}
+	return e, nil
+}
+
+// pipeEntries returns a snapshot of the pipes
+func (s *Server) pipeEntries() []*pipeEntry {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	res := make([]*pipeEntry, 0, len(s.pipes))
+	for _, e := range s.pipes {
+		res = append(res, e)
+	}
+	return res
+}
+
+// Pipe returns the status of a pipe
+func (s *Server) Pipe(id string) (PipeStatus, error) {
+	e, err := s.pipeEntry(id)
+	if err != nil {
+		return PipeStatus{}, err
+	}
+	return s.pipeStatus(e), nil
+}
+
+// Pipes returns the status of all the pipes, sorted by id
+func (s *Server) Pipes() []PipeStatus {
+	res := []PipeStatus{}
+	for _, e := range s.pipeEntries() {
+		res = append(res, s.pipeStatus(e))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (s *Server) pipeStatus(e *pipeEntry) PipeStatus {
+	s.mux.RLock()
+	res := PipeStatus{PipeConfig: e.config, State: StateStopped, Attached: []string{}, Template: e.template}
+	res.Outs = append([]string(nil), e.config.Outs...)
+	p, stopped := e.pipe, e.stopped
+	s.mux.RUnlock()
+	if p == nil {
+		return res
+	}
+	if p.running() {
+		res.State = StateRunning
+		res.Attached = append(res.Attached, p.outputIDs()...)
+	} else if !stopped && s.isRunning(e.config.In) {
+		// the input is running but the pipe is stopped by its error policy
+		res.State = StateFailed
+	}
+	return res
+}
+
+// Subscribe returns a channel receiving the events of the server, events are dropped while the channel is full.
+// The returned function unsubscribes and closes the channel.
+func (s *Server) Subscribe(size int) (<-chan Event, func()) {
+	return s.events.subscribe(size)
+}
+
+// componentsOf returns the components of the kind
+func (s *Server) componentsOf(kind Kind) []*component {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	var res []*component
+	for _, c := range s.components {
+		if c.kind == kind {
+			res = append(res, c)
 		}
-		if err != nil {
-			// TODO: handle error
-			panic(err)
+	}
+	return res
+}
+
+// Run initializes all the components, starts the pipes and blocks until ctx is done or the server is shut down
+func (s *Server) Run(ctx context.Context) error {
+	s.opMux.Lock()
+	if s.closed {
+		s.opMux.Unlock()
+		return ErrServerClosed
+	}
+	for _, kind := range kinds {
+		for _, c := range s.componentsOf(kind) {
+			if err := s.startComponent(c); err != nil {
+				s.opMux.Unlock()
+				return err
+			}
+		}
+	}
+
+	s.running = true
+	for _, e := range s.pipeEntries() {
+		s.startPipe(e)
+	}
+	s.opMux.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-s.closing:
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the server: the inbounds are closed first, then the pipes are drained into the outputs,
+// and finally the processes and outbounds are closed. If ctx is done before the pipes are drained, the remaining
+// components are closed immediately and the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.opMux.Lock()
+		defer s.opMux.Unlock()
+		s.closed = true
+		s.running = false
+		close(s.closing)
+		s.logger.Info("shutting down")
+		// keep the first error but carry on closing the rest of the components
+		fail := func(err error) {
+			if err != nil && s.shutdownErr == nil {
+				s.shutdownErr = err
+			}
+		}
+
+		// stop the inbounds so that no more data enters the pipes
+		fail(s.closeAll(ctx, KindInbound))
+		fail(s.waitPipes(ctx, func(in string) bool {
+			c, err := s.component(s.ownerOf(in))
+			return err == nil && c.kind == KindInbound
+		}))
+
+		// the processes exit once their stdin is closed, and the data left in their stdout is drained afterwards
+		fail(s.closeAll(ctx, KindProcess))
+		fail(s.waitPipes(ctx, func(in string) bool { return true }))
+
+		// close the outbounds regardless of the deadline, since nothing writes to them any more
+		fail(s.closeAll(context.Background(), KindOutbound))
+		s.logger.Info("server stopped")
+	})
+	return s.shutdownErr
+}
+
+// waitPipes blocks until all the pipes with the input matching filter are drained or ctx is done
+func (s *Server) waitPipes(ctx context.Context, filter func(in string) bool) error {
+	done := make(chan struct{})
+	go func() {
+		for _, e := range s.pipeEntries() {
+			if e.pipe != nil && filter(e.config.In) {
+				e.pipe.wait()
+			}
 		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-		// feed the buffer to all channels
-		for _, c := range channels {
-			c <- buffer[:n]
+// closeAll closes the running components of the kind concurrently and waits until they are closed or ctx is done
+func (s *Server) closeAll(ctx context.Context, kind Kind) error {
+	var wg sync.WaitGroup
+	for _, c := range s.componentsOf(kind) {
+		if c.state != StateRunning {
+			continue
 		}
+		wg.Add(1)
+		go func(c *component) {
+			defer wg.Done()
+			if err := c.instance.Close(); err != nil {
+				s.logger.WithError(err).WithFields(log.Fields{string(kind): c.config.ID}).Warn("failed to close " + string(kind))
+			}
+			s.setState(c, StateStopped, nil)
+		}(c)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
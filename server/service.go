@@ -0,0 +1,23 @@
+package server
+
+import (
+	"fmt"
+)
+
+// AddService shares an object configured outside of the components with them, e.g. the TURN server
+func (s *Server) AddService(name string, service interface{}) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.services[name] = service
+}
+
+// Service returns a shared object, the error wraps ErrNotFound if it is not configured
+func (s *Server) Service(name string) (interface{}, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	service, ok := s.services[name]
+	if !ok {
+		return nil, fmt.Errorf("service %s %w", name, ErrNotFound)
+	}
+	return service, nil
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientCounter is implemented by the outbounds serving several clients
+type ClientCounter interface {
+	// Clients returns the number of connected clients
+	Clients() int
+}
+
+// DropCounter is implemented by the outbounds dropping data on their own, e.g. for slow clients
+type DropCounter interface {
+	// Dropped returns the number of packets dropped so far
+	Dropped() uint64
+}
+
+// StatusReporter is implemented by the components describing their live state, e.g. their connected peers
+type StatusReporter interface {
+	// Status returns a value serializable to JSON, it is served by the admin API along with the component
+	Status() interface{}
+}
+
+// ConnectionReporter is implemented by the components sampling the statistics of their connections, e.g. the SRT
+// inbound and outbound
+type ConnectionReporter interface {
+	// Connections returns the latest sample of each connection
+	Connections() []ConnectionStats
+}
+
+// ConnectionStats is a sample of the statistics of a connection to a peer, the totals are counted since the connection
+// is established and the losses are also counted over the latest sampling interval
+type ConnectionStats struct {
+	Addr string `json:"addr"`
+	// Stream is the stream the connection publishes or plays, empty if none
+	Stream    string    `json:"stream,omitempty"`
+	Since     time.Time `json:"since"`
+	SampledAt time.Time `json:"sampledAt"`
+	// RTT is the round trip time in milliseconds
+	RTT float64 `json:"rttMs"`
+	// Bandwidth is the estimated bandwidth, SendRate and RecvRate are the rates over the latest interval, in Mb/s
+	Bandwidth float64 `json:"bandwidthMbps"`
+	SendRate  float64 `json:"sendRateMbps"`
+	RecvRate  float64 `json:"recvRateMbps"`
+
+	BytesSent            uint64 `json:"bytesSent"`
+	BytesReceived        uint64 `json:"bytesReceived"`
+	PacketsSent          uint64 `json:"packetsSent"`
+	PacketsReceived      uint64 `json:"packetsReceived"`
+	PacketsSendLost      uint64 `json:"packetsSendLost"`
+	PacketsRecvLost      uint64 `json:"packetsRecvLost"`
+	PacketsRetransmitted uint64 `json:"packetsRetransmitted"`
+	// PacketsSendDropped and PacketsRecvDropped count the packets dropped for being too late
+	PacketsSendDropped uint64 `json:"packetsSendDropped"`
+	PacketsRecvDropped uint64 `json:"packetsRecvDropped"`
+
+	IntervalSendLost      uint64 `json:"intervalSendLost"`
+	IntervalRecvLost      uint64 `json:"intervalRecvLost"`
+	IntervalRetransmitted uint64 `json:"intervalRetransmitted"`
+}
+
+// ViewerManager is implemented by the outbounds tracking each of their viewers, e.g. the WebRTC outbound
+type ViewerManager interface {
+	// Viewers returns the statistics of the viewers
+	Viewers() []ViewerStats
+	// Kick disconnects a viewer, an error wrapping ErrNotFound is returned if there is no such viewer
+	Kick(id string) error
+}
+
+// ViewerStats describes a viewer and the statistics of its connection
+type ViewerStats struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+	// Protocol is the signaling the viewer connected with, e.g. "whep"
+	Protocol string    `json:"protocol"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	// LastActivity is the time of the latest RTCP packet received from the viewer
+	LastActivity time.Time `json:"lastActivity"`
+	// RTT is the round trip time of the nominated ICE candidate pair in milliseconds
+	RTT           float64 `json:"rttMs"`
+	BytesSent     uint64  `json:"bytesSent"`
+	BytesReceived uint64  `json:"bytesReceived"`
+	// LocalCandidate and RemoteCandidate are the types of the candidates of the nominated pair, e.g. "relay"
+	LocalCandidate  string             `json:"localCandidate,omitempty"`
+	RemoteCandidate string             `json:"remoteCandidate,omitempty"`
+	Tracks          []ViewerTrackStats `json:"tracks"`
+}
+
+// ViewerTrackStats are the statistics of a track sent to a viewer as reported by the viewer, the losses and the
+// jitter are taken from its latest receiver report
+type ViewerTrackStats struct {
+	ID           string  `json:"id"`
+	PacketsLost  uint32  `json:"packetsLost"`
+	FractionLost float64 `json:"fractionLost"`
+	Jitter       float64 `json:"jitterMs"`
+	NACKs        uint64  `json:"nacks"`
+	PLIs         uint64  `json:"plis"`
+	FIRs         uint64  `json:"firs"`
+}
+
+// Stats is a snapshot of the counters of the server
+type Stats struct {
+	Components []ComponentStats `json:"components"`
+	Pipes      []PipeStats      `json:"pipes"`
+}
+
+// ComponentStats is a snapshot of the counters of a component
+type ComponentStats struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Kind  Kind   `json:"kind"`
+	State State  `json:"state"`
+	// Starts is the number of times the component is started
+	Starts uint64 `json:"starts"`
+	// StartedAt is the time of the latest start, zero if the component is not running
+	StartedAt time.Time `json:"startedAt"`
+	// Clients is the number of connected clients, nil if the component does not implement ClientCounter
+	Clients *int `json:"clients,omitempty"`
+	// Dropped is the number of packets dropped by the component, nil if it does not implement DropCounter
+	Dropped *uint64 `json:"dropped,omitempty"`
+	// Connections holds the statistics of the connections of a running component implementing ConnectionReporter
+	Connections []ConnectionStats `json:"connections,omitempty"`
+}
+
+// PipeStats is a snapshot of the counters of a pipe, the counters are kept when the pipe is restarted
+type PipeStats struct {
+	ID          string        `json:"id"`
+	In          string        `json:"in"`
+	Running     bool          `json:"running"`
+	BytesRead   uint64        `json:"bytesRead"`
+	PacketsRead uint64        `json:"packetsRead"`
+	ReadErrors  uint64        `json:"readErrors"`
+	Outputs     []OutputStats `json:"outputs"`
+}
+
+// OutputStats is a snapshot of the counters of a pipe output
+type OutputStats struct {
+	ID             string `json:"id"`
+	Attached       bool   `json:"attached"`
+	BytesWritten   uint64 `json:"bytesWritten"`
+	PacketsWritten uint64 `json:"packetsWritten"`
+	Dropped        uint64 `json:"dropped"`
+	WriteErrors    uint64 `json:"writeErrors"`
+	// Depth is the number of packets waiting in the channel of the output
+	Depth    int            `json:"depth"`
+	Capacity int            `json:"capacity"`
+	Overflow OverflowPolicy `json:"overflow"`
+}
+
+// pipeCounters keeps the counters of a pipe across restarts
+type pipeCounters struct {
+	bytesRead   uint64
+	packetsRead uint64
+	readErrors  uint64
+	outputs     map[string]*outputCounters
+	outputsMux  sync.Mutex
+}
+
+// outputCounters keeps the counters of a pipe output
+type outputCounters struct {
+	bytesWritten   uint64
+	packetsWritten uint64
+	dropped        uint64
+	writeErrors    uint64
+}
+
+func newPipeCounters() *pipeCounters {
+	return &pipeCounters{outputs: make(map[string]*outputCounters)}
+}
+
+// output returns the counters of the output, they are created on the first call
+func (c *pipeCounters) output(id string) *outputCounters {
+	c.outputsMux.Lock()
+	defer c.outputsMux.Unlock()
+	o, ok := c.outputs[id]
+	if !ok {
+		o = &outputCounters{}
+		c.outputs[id] = o
+	}
+	return o
+}
+
+// Stats returns a snapshot of the counters of all the components and the pipes, sorted by id
+func (s *Server) Stats() Stats {
+	res := Stats{Components: []ComponentStats{}, Pipes: []PipeStats{}}
+
+	s.mux.RLock()
+	for _, c := range s.components {
+		cs := ComponentStats{
+			ID:     c.config.ID,
+			Type:   c.config.Type,
+			Kind:   c.kind,
+			State:  c.state,
+			Starts: c.starts,
+		}
+		if c.state == StateRunning {
+			cs.StartedAt = c.startedAt
+		}
+		if cc, ok := c.instance.(ClientCounter); ok {
+			n := cc.Clients()
+			cs.Clients = &n
+		}
+		if dc, ok := c.instance.(DropCounter); ok {
+			n := dc.Dropped()
+			cs.Dropped = &n
+		}
+		if cr, ok := c.instance.(ConnectionReporter); ok && c.state == StateRunning {
+			cs.Connections = cr.Connections()
+		}
+		res.Components = append(res.Components, cs)
+	}
+	s.mux.RUnlock()
+	sort.Slice(res.Components, func(i, j int) bool { return res.Components[i].ID < res.Components[j].ID })
+
+	for _, e := range s.pipeEntries() {
+		res.Pipes = append(res.Pipes, s.pipeStats(e))
+	}
+	sort.Slice(res.Pipes, func(i, j int) bool { return res.Pipes[i].ID < res.Pipes[j].ID })
+	return res
+}
+
+// Connections returns the statistics of the connections of a component, an empty list if it does not implement
+// ConnectionReporter or is not running
+func (s *Server) Connections(id string) ([]ConnectionStats, error) {
+	c, err := s.component(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	res := []ConnectionStats{}
+	if cr, ok := c.instance.(ConnectionReporter); ok && c.state == StateRunning {
+		res = append(res, cr.Connections()...)
+	}
+	return res, nil
+}
+
+// Viewers returns the statistics of the viewers of a component, an empty list if it does not implement ViewerManager
+// or is not running
+func (s *Server) Viewers(id string) ([]ViewerStats, error) {
+	vm, err := s.viewerManager(id)
+	if err != nil {
+		return nil, err
+	}
+	res := []ViewerStats{}
+	if vm != nil {
+		res = append(res, vm.Viewers()...)
+	}
+	return res, nil
+}
+
+// KickViewer disconnects a viewer of a component
+func (s *Server) KickViewer(id, viewer string) error {
+	vm, err := s.viewerManager(id)
+	if err != nil {
+		return err
+	}
+	if vm == nil {
+		return fmt.Errorf("viewer %s %w", viewer, ErrNotFound)
+	}
+	return vm.Kick(viewer)
+}
+
+// viewerManager returns the component as a ViewerManager, nil if it does not implement it or is not running
+func (s *Server) viewerManager(id string) (ViewerManager, error) {
+	c, err := s.component(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if vm, ok := c.instance.(ViewerManager); ok && c.state == StateRunning {
+		return vm, nil
+	}
+	return nil, nil
+}
+
+func (s *Server) pipeStats(e *pipeEntry) PipeStats {
+	s.mux.RLock()
+	config, p := e.config, e.pipe
+	s.mux.RUnlock()
+	res := PipeStats{
+		ID:          config.ID,
+		In:          config.In,
+		BytesRead:   atomic.LoadUint64(&e.counters.bytesRead),
+		PacketsRead: atomic.LoadUint64(&e.counters.packetsRead),
+		ReadErrors:  atomic.LoadUint64(&e.counters.readErrors),
+		Outputs:     []OutputStats{},
+	}
+	attached := make(map[string]*pipeOutput)
+	if p != nil && p.running() {
+		res.Running = true
+		for _, o := range p.outputs() {
+			attached[o.id] = o
+		}
+	}
+	for _, out := range config.Outs {
+		c := e.counters.output(out)
+		options, _ := config.output(out)
+		st := OutputStats{
+			ID:             out,
+			BytesWritten:   atomic.LoadUint64(&c.bytesWritten),
+			PacketsWritten: atomic.LoadUint64(&c.packetsWritten),
+			Dropped:        atomic.LoadUint64(&c.dropped),
+			WriteErrors:    atomic.LoadUint64(&c.writeErrors),
+			Overflow:       options.Overflow,
+		}
+		if o, ok := attached[out]; ok {
+			st.Attached = true
+			st.Depth = len(o.c)
+			st.Capacity = cap(o.c)
+		}
+		res.Outputs = append(res.Outputs, st)
+	}
+	return res
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// StreamPlaceholder is replaced by the name of a stream in the pipe templates, e.g. "srt-in/{stream}"
+const StreamPlaceholder = "{stream}"
+
+// AddStream adds the input of a stream provided by a running component, e.g. a stream published to an SRT inbound,
+// under "<id>/<stream>". The pipe templates reading from "<id>/{stream}" are instantiated for the stream and started.
+func (s *Server) AddStream(id, stream string, r PacketReader) error {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	c, err := s.component(id)
+	if err != nil {
+		return err
+	}
+	name := id + "/" + stream
+	s.mux.Lock()
+	if c.state != StateRunning || c.closed {
+		s.mux.Unlock()
+		return errors.New(string(c.kind) + " " + id + " is not running")
+	}
+	if _, ok := s.readers[name]; ok {
+		s.mux.Unlock()
+		return fmt.Errorf("stream %s %w", name, ErrExists)
+	}
+	s.readers[name] = r
+	s.mux.Unlock()
+	s.logger.WithField("stream", name).Info("stream added")
+
+	for _, t := range s.pipeEntries() {
+		if isTemplate(t.config) {
+			s.instantiate(t, name)
+		}
+	}
+	return nil
+}
+
+// RemoveStream removes the input of a stream along with the pipes instantiated for it, nothing is done if the input
+// is not r any more
+func (s *Server) RemoveStream(id, stream string, r PacketReader) {
+	s.opMux.Lock()
+	defer s.opMux.Unlock()
+	name := id + "/" + stream
+	s.mux.Lock()
+	if cur, ok := s.readers[name]; !ok || cur != r {
+		s.mux.Unlock()
+		return
+	}
+	delete(s.readers, name)
+	s.mux.Unlock()
+	s.removeInstances(func(in string) bool { return in == name })
+	s.logger.WithField("stream", name).Info("stream removed")
+}
+
+// removeStreams removes the inputs and the writers of the streams of a component along with the pipes instantiated for
+// them, opMux must be held
+func (s *Server) removeStreams(id string) {
+	s.mux.Lock()
+	for name := range s.readers {
+		if strings.HasPrefix(name, id+"/") {
+			delete(s.readers, name)
+		}
+	}
+	for name := range s.writers {
+		if strings.HasPrefix(name, id+"/") {
+			delete(s.writers, name)
+		}
+	}
+	s.mux.Unlock()
+	s.removeInstances(func(in string) bool { return strings.HasPrefix(in, id+"/") })
+}
+
+// removeInstances removes the pipes instantiated from a template whose input matches, opMux must be held
+func (s *Server) removeInstances(match func(in string) bool) {
+	for _, e := range s.pipeEntries() {
+		if e.template != "" && match(e.config.In) {
+			s.removePipe(e)
+		}
+	}
+}
+
+// writer returns the writer of an output, the writer of a stream is requested from its owner the first time
+func (s *Server) writer(out string) (PacketWriter, bool) {
+	s.mux.RLock()
+	w, ok := s.writers[out]
+	s.mux.RUnlock()
+	if ok {
+		return w, true
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if w, ok := s.writers[out]; ok {
+		return w, true
+	}
+	w, err := s.streamWriterLocked(out)
+	if err != nil {
+		return nil, false
+	}
+	s.writers[out] = w
+	return w, true
+}
+
+// hasWriterLocked reports whether the output is a writer or the writer of a stream
+func (s *Server) hasWriterLocked(out string) bool {
+	if _, ok := s.writers[out]; ok {
+		return true
+	}
+	_, err := s.streamWriterLocked(out)
+	return err == nil
+}
+
+// streamWriterLocked requests the writer of a stream from the owner of the output
+func (s *Server) streamWriterLocked(out string) (PacketWriter, error) {
+	owner := s.ownerOfLocked(out)
+	c, ok := s.components[owner]
+	if !ok || !strings.HasPrefix(out, owner+"/") {
+		return nil, errors.New("unknown outbound: " + out)
+	}
+	p, ok := c.instance.(StreamWriterProvider)
+	if !ok {
+		return nil, errors.New(string(c.kind) + " " + owner + " does not provide streams")
+	}
+	return p.StreamWriter(strings.TrimPrefix(out, owner+"/"))
+}
+
+// Attached reports whether the output is fed by a running pipe
+func (s *Server) Attached(out string) bool {
+	for _, e := range s.pipeEntries() {
+		s.mux.RLock()
+		p := e.pipe
+		s.mux.RUnlock()
+		if p != nil && p.running() && contains(p.outputIDs(), out) {
+			return true
+		}
+	}
+	return false
+}
+
+// instantiate creates the pipe of a template for the input of a stream and starts it, opMux must be held
+func (s *Server) instantiate(t *pipeEntry, in string) {
+	s.mux.RLock()
+	template := t.config
+	s.mux.RUnlock()
+	stream, ok := streamOf(template.In, in)
+	if !ok {
+		return
+	}
+	config := PipeConfig{
+		ID:          instanceID(template.ID, stream),
+		In:          in,
+		PipeOptions: template.PipeOptions,
+	}
+	for _, out := range template.Outs {
+		config.Outs = append(config.Outs, strings.ReplaceAll(out, StreamPlaceholder, stream))
+	}
+	if template.Outputs != nil {
+		config.Outputs = make(map[string]OutputOptions)
+		for out, options := range template.Outputs {
+			config.Outputs[strings.ReplaceAll(out, StreamPlaceholder, stream)] = options
+		}
+	}
+
+	logger := s.logger.WithFields(log.Fields{"pipe": config.ID, "template": template.ID})
+	e := &pipeEntry{config: config, counters: newPipeCounters(), template: template.ID}
+	s.mux.Lock()
+	if _, ok := s.pipes[config.ID]; ok {
+		s.mux.Unlock()
+		logger.Warn("pipe already exists, template not instantiated")
+		return
+	}
+	s.pipes[config.ID] = e
+	s.mux.Unlock()
+	logger.WithField("in", in).Info("pipe instantiated")
+	if s.running {
+		s.startPipe(e)
+	}
+}
+
+// instantiateAll creates the pipes of a template for the inputs of the existing streams, opMux must be held
+func (s *Server) instantiateAll(t *pipeEntry) {
+	s.mux.RLock()
+	var inputs []string
+	for in := range s.readers {
+		if _, ok := streamOf(t.config.In, in); ok {
+			inputs = append(inputs, in)
+		}
+	}
+	s.mux.RUnlock()
+	for _, in := range inputs {
+		s.instantiate(t, in)
+	}
+}
+
+// isTemplate reports whether the pipe is a template instantiated for each stream of its input
+func isTemplate(config PipeConfig) bool {
+	return strings.Contains(config.In, StreamPlaceholder)
+}
+
+// streamOf returns the name of the stream if the input matches the input of a template
+func streamOf(template, in string) (string, bool) {
+	i := strings.Index(template, StreamPlaceholder)
+	if i < 0 {
+		return "", false
+	}
+	prefix, suffix := template[:i], template[i+len(StreamPlaceholder):]
+	if len(in) <= len(prefix)+len(suffix) || !strings.HasPrefix(in, prefix) || !strings.HasSuffix(in, suffix) {
+		return "", false
+	}
+	return in[len(prefix) : len(in)-len(suffix)], true
+}
+
+// instanceID returns the id of the pipe instantiated from a template for a stream
+func instanceID(template, stream string) string {
+	if strings.Contains(template, StreamPlaceholder) {
+		return strings.ReplaceAll(template, StreamPlaceholder, stream)
+	}
+	return template + "/" + stream
+}
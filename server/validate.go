@@ -0,0 +1,247 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationError lists all the problems found in a config
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the components and the pipes of the config and returns all the problems found as a
+// *ValidationError. The components are created to find the readers and the writers they provide, but they are not
+// started, so no listener is opened and no process is run.
+func (s *Server) Validate(config *Config) error {
+	var errs []error
+
+	// the components are created on a scratch server sharing the registered types
+	v := New()
+	v.registeredInbound = s.registeredInbound
+	v.registeredOutbound = s.registeredOutbound
+	v.registeredProcess = s.registeredProcess
+	lists := map[Kind][]ComponentConfig{
+		KindInbound:  config.Inbounds,
+		KindOutbound: config.Outbounds,
+		KindProcess:  config.Processes,
+	}
+	for _, kind := range kinds {
+		for _, c := range lists[kind] {
+			if err := v.add(kind, c); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %s: %w", kind, c.ID, err))
+			}
+		}
+	}
+
+	errs = append(errs, v.checkGraph(config.Pipes, true)...)
+	if len(errs) > 0 {
+		return &ValidationError{errs}
+	}
+	return nil
+}
+
+// checkGraph checks the pipes against the readers and the writers of the server. The pipes must not contain a
+// cycle, an output must not be fed by several pipes and a component with tracks can only be read or fed through its
+// tracks. If unread is set, the inbounds not read by any pipe are reported as well.
+func (s *Server) checkGraph(pipes []PipeConfig, unread bool) []error {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	var errs []error
+
+	ids := make(map[string]bool)
+	fedBy := make(map[string][]string)
+	read := make(map[string]bool)
+	edges := make(map[string][]string)
+	for _, p := range pipes {
+		if p.ID == "" {
+			p.ID = p.In
+		}
+		if ids[p.ID] {
+			errs = append(errs, fmt.Errorf("pipe %s %w", p.ID, ErrExists))
+			continue
+		}
+		ids[p.ID] = true
+		opt := p.PipeOptions
+		if err := opt.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid pipe %s: %w", p.ID, err))
+		}
+		template := isTemplate(p)
+		// reader is the input of the pipe, or the component providing the streams of a template
+		var reader PacketReader
+		if template {
+			owner := s.ownerOfLocked(p.In)
+			if _, ok := s.components[owner]; !ok || p.In != owner+"/"+StreamPlaceholder {
+				errs = append(errs, fmt.Errorf("pipe %s reads from unknown input %s, a template must read from <id>/%s",
+					p.ID, p.In, StreamPlaceholder))
+			}
+			reader = s.readers[owner]
+		} else if r, ok := s.readers[p.In]; !ok {
+			errs = append(errs, fmt.Errorf("pipe %s reads from unknown input %s", p.ID, p.In))
+		} else if tracks := s.subReadersLocked(p.In); len(tracks) > 0 {
+			errs = append(errs, fmt.Errorf("pipe %s reads from %s directly, use one of its tracks instead: %s",
+				p.ID, p.In, strings.Join(tracks, ", ")))
+		} else {
+			reader = r
+		}
+		if reader != nil && !flagsKeyframes(reader) {
+			for _, out := range p.Outs {
+				if o, _ := opt.output(out); o.Overflow == OverflowDropUntilKeyframe {
+					errs = append(errs, fmt.Errorf("pipe %s: %s does not flag the keyframes, the %s overflow policy of %s would drop all the packets",
+						p.ID, p.In, OverflowDropUntilKeyframe, out))
+				}
+			}
+		}
+		read[s.ownerOfLocked(p.In)] = true
+
+		seen := make(map[string]bool)
+		for _, out := range p.Outs {
+			if seen[out] {
+				errs = append(errs, fmt.Errorf("pipe %s lists output %s more than once", p.ID, out))
+				continue
+			}
+			seen[out] = true
+			if template {
+				// the outputs of the instances are only known once the streams are published
+				if !strings.Contains(out, StreamPlaceholder) {
+					errs = append(errs, fmt.Errorf("pipe %s writes every stream to %s, use %s in the output",
+						p.ID, out, StreamPlaceholder))
+				}
+				fedBy[out] = append(fedBy[out], p.ID)
+				continue
+			}
+			if strings.Contains(out, StreamPlaceholder) {
+				errs = append(errs, fmt.Errorf("pipe %s writes to %s but is not a template", p.ID, out))
+				continue
+			}
+			if !s.hasWriterLocked(out) {
+				errs = append(errs, fmt.Errorf("pipe %s writes to unknown output %s", p.ID, out))
+				continue
+			}
+			if tracks := s.subWritersLocked(out); len(tracks) > 0 {
+				errs = append(errs, fmt.Errorf("pipe %s writes to %s directly, use one of its tracks instead: %s",
+					p.ID, out, strings.Join(tracks, ", ")))
+			}
+			fedBy[out] = append(fedBy[out], p.ID)
+			edges[s.ownerOfLocked(p.In)] = append(edges[s.ownerOfLocked(p.In)], s.ownerOfLocked(out))
+		}
+		var configured []string
+		for out := range p.Outputs {
+			if !seen[out] {
+				configured = append(configured, out)
+			}
+		}
+		sort.Strings(configured)
+		for _, out := range configured {
+			errs = append(errs, fmt.Errorf("pipe %s configures %s which is not one of its outputs", p.ID, out))
+		}
+	}
+
+	var outs []string
+	for out := range fedBy {
+		outs = append(outs, out)
+	}
+	sort.Strings(outs)
+	for _, out := range outs {
+		if len(fedBy[out]) > 1 {
+			errs = append(errs, fmt.Errorf("output %s is fed by several pipes: %s", out, strings.Join(fedBy[out], ", ")))
+		}
+	}
+
+	for _, cycle := range findCycles(edges) {
+		errs = append(errs, fmt.Errorf("pipes form a cycle: %s", strings.Join(cycle, " -> ")))
+	}
+
+	if unread {
+		var unreadIDs []string
+		for id, c := range s.components {
+			if c.kind == KindInbound && !read[id] {
+				unreadIDs = append(unreadIDs, id)
+			}
+		}
+		sort.Strings(unreadIDs)
+		for _, id := range unreadIDs {
+			errs = append(errs, fmt.Errorf("inbound %s is not read by any pipe", id))
+		}
+	}
+	return errs
+}
+
+// subWritersLocked returns the writers provided under the id of a component, e.g. "webrtc-out:video"
+func (s *Server) subWritersLocked(id string) []string {
+	var res []string
+	for w := range s.writers {
+		if strings.HasPrefix(w, id+":") {
+			res = append(res, w)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// subReadersLocked returns the readers provided under the id of a component, e.g. "whip-in:video"
+func (s *Server) subReadersLocked(id string) []string {
+	var res []string
+	for r := range s.readers {
+		if strings.HasPrefix(r, id+":") {
+			res = append(res, r)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// findCycles returns the cycles of the graph, each cycle is reported once starting and ending with the same node
+func findCycles(edges map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var res [][]string
+
+	var visit func(node string)
+	visit = func(node string) {
+		state[node] = visiting
+		path = append(path, node)
+		for _, next := range edges[node] {
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case visiting:
+				// the path from next back to next is a cycle
+				for i := range path {
+					if path[i] == next {
+						res = append(res, append(append([]string(nil), path[i:]...), next))
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+	}
+
+	var nodes []string
+	for node := range edges {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		if state[node] == unvisited {
+			visit(node)
+		}
+	}
+	return res
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	component := func(id, typ string) ComponentConfig {
+		return ComponentConfig{ID: id, Type: typ}
+	}
+	pipe := func(id, in string, outs ...string) PipeConfig {
+		return PipeConfig{ID: id, In: in, Outs: outs}
+	}
+	tests := []struct {
+		name   string
+		config Config
+		errs   []string
+	}{
+		{
+			name: "valid",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in", "fake")},
+				Processes: []ComponentConfig{component("proc", "fake")},
+				Outbounds: []ComponentConfig{component("out1", "fake"), component("out2", "fake")},
+				Pipes:     []PipeConfig{pipe("", "in", "proc", "out1"), pipe("", "proc", "out2")},
+			},
+		},
+		{
+			name: "duplicate component ids",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in", "fake")},
+				Outbounds: []ComponentConfig{component("in", "fake")},
+				Pipes:     []PipeConfig{pipe("", "in")},
+			},
+			errs: []string{"invalid outbound in: component in already exists"},
+		},
+		{
+			name: "duplicate pipe ids",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in", "fake")},
+				Outbounds: []ComponentConfig{component("out1", "fake"), component("out2", "fake")},
+				Pipes:     []PipeConfig{pipe("", "in", "out1"), pipe("in", "in", "out2")},
+			},
+			errs: []string{"pipe in already exists"},
+		},
+		{
+			name: "dangling output",
+			config: Config{
+				Inbounds: []ComponentConfig{component("in", "fake")},
+				Pipes:    []PipeConfig{pipe("", "in", "out")},
+			},
+			errs: []string{"pipe in writes to unknown output out"},
+		},
+		{
+			name: "dangling input",
+			config: Config{
+				Outbounds: []ComponentConfig{component("out", "fake")},
+				Pipes:     []PipeConfig{pipe("", "in", "out")},
+			},
+			errs: []string{"pipe in reads from unknown input in"},
+		},
+		{
+			name: "output listed twice",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in", "fake")},
+				Outbounds: []ComponentConfig{component("out", "fake")},
+				Pipes:     []PipeConfig{pipe("", "in", "out", "out")},
+			},
+			errs: []string{"pipe in lists output out more than once"},
+		},
+		{
+			name: "output fed twice",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in1", "fake"), component("in2", "fake")},
+				Outbounds: []ComponentConfig{component("out", "fake")},
+				Pipes:     []PipeConfig{pipe("", "in1", "out"), pipe("", "in2", "out")},
+			},
+			errs: []string{"output out is fed by several pipes: in1, in2"},
+		},
+		{
+			name: "options of another output",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in", "fake")},
+				Outbounds: []ComponentConfig{component("out", "fake")},
+				Pipes: []PipeConfig{{In: "in", Outs: []string{"out"}, PipeOptions: PipeOptions{
+					Outputs: map[string]OutputOptions{"other": {BufferSize: 10}},
+				}}},
+			},
+			errs: []string{"pipe in configures other which is not one of its outputs"},
+		},
+		{
+			name: "self loop",
+			config: Config{
+				Processes: []ComponentConfig{component("a", "fake")},
+				Pipes:     []PipeConfig{pipe("", "a", "a")},
+			},
+			errs: []string{"pipes form a cycle: a -> a"},
+		},
+		{
+			name: "cycle",
+			config: Config{
+				Processes: []ComponentConfig{component("a", "fake"), component("b", "fake"), component("c", "fake")},
+				Pipes:     []PipeConfig{pipe("", "a", "b"), pipe("", "b", "c"), pipe("", "c", "a")},
+			},
+			errs: []string{"pipes form a cycle: a -> b -> c -> a"},
+		},
+		{
+			name: "two cycles",
+			config: Config{
+				Processes: []ComponentConfig{
+					component("a", "fake"), component("b", "fake"), component("c", "fake"), component("d", "fake"),
+				},
+				Pipes: []PipeConfig{pipe("", "a", "b"), pipe("", "b", "a"), pipe("", "c", "d"), pipe("", "d", "c")},
+			},
+			errs: []string{"pipes form a cycle: a -> b -> a", "pipes form a cycle: c -> d -> c"},
+		},
+		{
+			name: "chain",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in", "fake")},
+				Processes: []ComponentConfig{component("a", "fake"), component("b", "fake")},
+				Outbounds: []ComponentConfig{component("out", "fake")},
+				Pipes:     []PipeConfig{pipe("", "in", "a"), pipe("", "a", "b"), pipe("", "b", "out")},
+			},
+		},
+		{
+			name: "tracks",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in", "tracks")},
+				Outbounds: []ComponentConfig{component("out", "tracks")},
+				Pipes:     []PipeConfig{pipe("", "in", "out"), pipe("", "in:video", "out:video")},
+			},
+			errs: []string{
+				"pipe in reads from in directly, use one of its tracks instead: in:audio, in:video",
+				"pipe in writes to out directly, use one of its tracks instead: out:audio, out:video",
+			},
+		},
+		{
+			name: "unread inbound",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in1", "fake"), component("in2", "fake")},
+				Outbounds: []ComponentConfig{component("out", "fake")},
+				Pipes:     []PipeConfig{pipe("", "in1", "out")},
+			},
+			errs: []string{"inbound in2 is not read by any pipe"},
+		},
+		{
+			name: "drop until keyframe",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in1", "keyframes"), component("in2", "fake")},
+				Outbounds: []ComponentConfig{component("out1", "fake"), component("out2", "fake"), component("out3", "fake")},
+				Pipes: []PipeConfig{
+					{In: "in1", Outs: []string{"out1"}, PipeOptions: PipeOptions{
+						OutputOptions: OutputOptions{Overflow: OverflowDropUntilKeyframe},
+					}},
+					{In: "in2", Outs: []string{"out2", "out3"}, PipeOptions: PipeOptions{
+						Outputs: map[string]OutputOptions{"out3": {Overflow: OverflowDropUntilKeyframe}},
+					}},
+				},
+			},
+			errs: []string{"pipe in2: in2 does not flag the keyframes, the dropUntilKeyframe overflow policy of out3 would drop all the packets"},
+		},
+		{
+			name: "template",
+			config: Config{
+				Inbounds:  []ComponentConfig{component("in", "fake")},
+				Outbounds: []ComponentConfig{component("out", "fake")},
+				Pipes: []PipeConfig{
+					pipe("", "in", "out"), pipe("t1", "in/"+StreamPlaceholder, "out"), pipe("t2", "other/"+StreamPlaceholder),
+					pipe("t3", "in/"+StreamPlaceholder, "out/"+StreamPlaceholder),
+				},
+			},
+			errs: []string{
+				"pipe t1 writes every stream to out, use {stream} in the output",
+				"pipe t2 reads from unknown input other/{stream}, a template must read from <id>/{stream}",
+				"output out is fed by several pipes: in, t1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newFakeServer().Validate(&tt.config)
+			var errs []string
+			if err != nil {
+				verr, ok := err.(*ValidationError)
+				if !ok {
+					t.Fatalf("got %T, want a *ValidationError", err)
+				}
+				for _, e := range verr.Errors {
+					errs = append(errs, e.Error())
+				}
+			}
+			if !reflect.DeepEqual(errs, tt.errs) {
+				t.Fatalf("got the errors %q, want %q", errs, tt.errs)
+			}
+		})
+	}
+}
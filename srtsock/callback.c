@@ -0,0 +1,11 @@
+#include <srt/srt.h>
+#include "_cgo_export.h"
+
+// listenCallback calls the Go callback registered under the key pointed by opaq
+static int listenCallback(void* opaq, SRTSOCKET ns, int hsversion, const struct sockaddr* peeraddr, const char* streamid) {
+	return srtsockAccept(*(int*)opaq, ns, (struct sockaddr*)peeraddr, (char*)streamid);
+}
+
+int srtsockSetListenCallback(SRTSOCKET lsn, int* key) {
+	return srt_listen_callback(lsn, &listenCallback, key);
+}
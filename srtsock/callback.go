@@ -0,0 +1,144 @@
+package srtsock
+
+/*
+#include <stdlib.h>
+#include <srt/srt.h>
+
+int srtsockSetListenCallback(SRTSOCKET lsn, int* key);
+*/
+import "C"
+
+import (
+	"errors"
+	"github.com/haivision/srtgo"
+	"net"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The reasons of a rejection defined by the SRT access control guidelines, they are reported to the peer
+const (
+	RejectBadRequest   = 1400
+	RejectUnauthorized = 1401
+	RejectForbidden    = 1403
+	RejectNotFound     = 1404
+	RejectBadMode      = 1405
+	RejectConflict     = 1409
+)
+
+// RejectError rejects a connection in a ListenCallback with a reason
+type RejectError struct {
+	Reason int
+	Err    error
+}
+
+// Reject returns an error rejecting a connection with a reason
+func Reject(reason int, err error) error {
+	return &RejectError{Reason: reason, Err: err}
+}
+
+func (e *RejectError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// Conn is a connection being accepted by a listener
+type Conn struct {
+	sock C.SRTSOCKET
+	// Addr is the address of the peer
+	Addr string
+	// StreamID is the stream id sent by the peer
+	StreamID string
+}
+
+// SetPassphrase sets the passphrase the peer must use, in place of the one of the listener
+func (c *Conn) SetPassphrase(passphrase string) error {
+	p := C.CString(passphrase)
+	defer C.free(unsafe.Pointer(p))
+	if C.srt_setsockflag(c.sock, C.SRTO_PASSPHRASE, unsafe.Pointer(p), C.int(len(passphrase))) != 0 {
+		return lastError("failed to set the passphrase")
+	}
+	return nil
+}
+
+// ListenCallback decides whether a listener accepts a connection, before the handshake completes. It runs on the
+// receiving thread of libsrt and must not block. An error rejects the connection with the reason of a RejectError,
+// RejectForbidden otherwise.
+type ListenCallback func(c *Conn) error
+
+var (
+	callbacks    = make(map[C.int]ListenCallback)
+	lastKey      C.int
+	callbacksMux sync.Mutex
+)
+
+// SetListenCallback sets the callback of a listener created by srtgo, it must be set before Listen. The returned
+// function removes the callback once the listener is closed.
+func SetListenCallback(sck *srtgo.SrtSocket, cb ListenCallback) (func(), error) {
+	callbacksMux.Lock()
+	lastKey++
+	key := lastKey
+	callbacks[key] = cb
+	callbacksMux.Unlock()
+	// libsrt keeps the pointer, so the key is allocated by C
+	opaq := (*C.int)(C.malloc(C.size_t(unsafe.Sizeof(key))))
+	*opaq = key
+	remove := func() {
+		callbacksMux.Lock()
+		delete(callbacks, key)
+		callbacksMux.Unlock()
+		C.free(unsafe.Pointer(opaq))
+	}
+	if C.srtsockSetListenCallback(C.SRTSOCKET(sck.GetSocket()), opaq) != 0 {
+		err := lastError("failed to set the listen callback")
+		remove()
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(remove) }, nil
+}
+
+//export srtsockAccept
+func srtsockAccept(key C.int, ns C.SRTSOCKET, peer *C.struct_sockaddr, streamID *C.char) C.int {
+	callbacksMux.Lock()
+	cb := callbacks[key]
+	callbacksMux.Unlock()
+	if cb == nil {
+		// the listener is being closed
+		return -1
+	}
+	c := &Conn{sock: ns, Addr: peerAddr(peer)}
+	if streamID != nil {
+		c.StreamID = C.GoString(streamID)
+	}
+	if err := cb(c); err != nil {
+		reason := RejectForbidden
+		var rejectErr *RejectError
+		if errors.As(err, &rejectErr) {
+			reason = rejectErr.Reason
+		}
+		C.srt_setrejectreason(ns, C.int(reason))
+		return -1
+	}
+	return 0
+}
+
+// peerAddr returns the address of the peer as "host:port", "" if its family is unknown
+func peerAddr(sa *C.struct_sockaddr) string {
+	if sa == nil {
+		return ""
+	}
+	switch (*syscall.RawSockaddr)(unsafe.Pointer(sa)).Family {
+	case syscall.AF_INET:
+		in := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return (&net.UDPAddr{IP: append(net.IP(nil), in.Addr[:]...), Port: getPort(&in.Port)}).String()
+	case syscall.AF_INET6:
+		in := (*syscall.RawSockaddrInet6)(unsafe.Pointer(sa))
+		return (&net.UDPAddr{IP: append(net.IP(nil), in.Addr[:]...), Port: getPort(&in.Port)}).String()
+	}
+	return ""
+}
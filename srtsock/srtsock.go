@@ -0,0 +1,71 @@
+// Package srtsock exposes the features of libsrt not covered by srtgo, such as the rendezvous mode and the listen
+// callback, on the sockets created by srtgo
+package srtsock
+
+/*
+#cgo LDFLAGS: -lsrt
+#include <srt/srt.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"github.com/haivision/srtgo"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Rendezvous prepares a socket created by srtgo for the rendezvous mode, the socket is bound to the local port and
+// Connect then connects it to the remote peer, which connects the other way round at the same time. The local address
+// has the family of the remote host.
+func Rendezvous(sck *srtgo.SrtSocket, host string, localPort uint16) error {
+	sock := C.SRTSOCKET(sck.GetSocket())
+	yes := C.int(1)
+	if C.srt_setsockflag(sock, C.SRTO_RENDEZVOUS, unsafe.Pointer(&yes), C.int(unsafe.Sizeof(yes))) != 0 {
+		return lastError("failed to set the rendezvous mode")
+	}
+	remote, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return err
+	}
+	local := net.IPv4zero
+	if remote.IP.To4() == nil {
+		local = net.IPv6unspecified
+	}
+	sa, n := sockaddr(local, localPort)
+	if C.srt_bind(sock, (*C.struct_sockaddr)(sa), n) != 0 {
+		return lastError("failed to bind the local port")
+	}
+	return nil
+}
+
+// sockaddr returns the socket address of an IP and a port
+func sockaddr(ip net.IP, port uint16) (unsafe.Pointer, C.int) {
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := &syscall.RawSockaddrInet4{Family: syscall.AF_INET}
+		copy(sa.Addr[:], ip4)
+		setPort(&sa.Port, port)
+		return unsafe.Pointer(sa), C.int(syscall.SizeofSockaddrInet4)
+	}
+	sa := &syscall.RawSockaddrInet6{Family: syscall.AF_INET6}
+	copy(sa.Addr[:], ip.To16())
+	setPort(&sa.Port, port)
+	return unsafe.Pointer(sa), C.int(syscall.SizeofSockaddrInet6)
+}
+
+// setPort sets a port in network byte order
+func setPort(p *uint16, port uint16) {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	b[0], b[1] = byte(port>>8), byte(port)
+}
+
+// getPort reads a port in network byte order
+func getPort(p *uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	return int(b[0])<<8 | int(b[1])
+}
+
+func lastError(msg string) error {
+	return errors.New(msg + ": " + C.GoString(C.srt_getlasterror_str()))
+}
@@ -0,0 +1,168 @@
+// Package srtstats samples the statistics of the SRT connections periodically
+package srtstats
+
+import (
+	"github.com/haivision/srtgo"
+	"github.com/howyoungzhou/golive/server"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultInterval = 5000
+
+// Options of the sampler
+type Options struct {
+	// Interval between the samples in milliseconds (5000 by default), a negative interval disables the sampling
+	Interval int
+}
+
+// Sampler samples the statistics of a connection and logs them
+type Sampler struct {
+	set    *Set
+	sck    *srtgo.SrtSocket
+	sample server.ConnectionStats
+	mux    sync.Mutex
+	done   chan struct{}
+	once   sync.Once
+	logger *log.Entry
+}
+
+// Set keeps the samplers of the connections of a component
+type Set struct {
+	options  Options
+	samplers map[*Sampler]struct{}
+	mux      sync.Mutex
+	logger   *log.Entry
+	// stats reads the statistics of a connection, it is replaced by the tests
+	stats func(sck *srtgo.SrtSocket) (*srtgo.SrtStats, error)
+}
+
+// NewSet creates an empty set of samplers
+func NewSet(options Options, logger *log.Entry) *Set {
+	if options.Interval == 0 {
+		options.Interval = defaultInterval
+	}
+	return &Set{
+		options:  options,
+		samplers: make(map[*Sampler]struct{}),
+		logger:   logger,
+		stats:    func(sck *srtgo.SrtSocket) (*srtgo.SrtStats, error) { return sck.Stats() },
+	}
+}
+
+// Start starts sampling a connection until Stop is called
+func (s *Set) Start(sck *srtgo.SrtSocket, addr, stream string) *Sampler {
+	logger := s.logger.WithField("addr", addr)
+	if stream != "" {
+		logger = logger.WithField("stream", stream)
+	}
+	res := &Sampler{
+		set:    s,
+		sck:    sck,
+		sample: server.ConnectionStats{Addr: addr, Stream: stream, Since: time.Now()},
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+	if s.options.Interval < 0 {
+		return res
+	}
+	s.mux.Lock()
+	s.samplers[res] = struct{}{}
+	s.mux.Unlock()
+	go func() {
+		res.run(time.Duration(s.options.Interval) * time.Millisecond)
+		s.remove(res)
+	}()
+	return res
+}
+
+func (s *Set) remove(sampler *Sampler) {
+	s.mux.Lock()
+	delete(s.samplers, sampler)
+	s.mux.Unlock()
+}
+
+// List returns the latest sample of each connection sorted by address
+func (s *Set) List() []server.ConnectionStats {
+	s.mux.Lock()
+	res := make([]server.ConnectionStats, 0, len(s.samplers))
+	for sampler := range s.samplers {
+		res = append(res, sampler.Stats())
+	}
+	s.mux.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Addr < res[j].Addr })
+	return res
+}
+
+func (s *Sampler) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		stats, err := s.set.stats(s.sck)
+		if err != nil {
+			// the connection is closed
+			return
+		}
+		s.update(stats)
+	}
+}
+
+// update records a sample, the local measurements of srtgo are cleared by each call to Stats so they cover the
+// latest interval
+func (s *Sampler) update(stats *srtgo.SrtStats) {
+	s.mux.Lock()
+	sample := &s.sample
+	sample.SampledAt = time.Now()
+	sample.RTT = stats.MsRTT
+	sample.Bandwidth = stats.MbpsBandwidth
+	sample.SendRate = stats.MbpsSendRate
+	sample.RecvRate = stats.MbpsRecvRate
+	sample.BytesSent = uint64(stats.ByteSentTotal)
+	sample.BytesReceived = uint64(stats.ByteRecvTotal)
+	sample.PacketsSent = uint64(stats.PktSentTotal)
+	sample.PacketsReceived = uint64(stats.PktRecvTotal)
+	sample.PacketsSendLost = uint64(stats.PktSndLossTotal)
+	sample.PacketsRecvLost = uint64(stats.PktRcvLossTotal)
+	sample.PacketsRetransmitted = uint64(stats.PktRetransTotal)
+	sample.PacketsSendDropped = uint64(stats.PktSndDropTotal)
+	sample.PacketsRecvDropped = uint64(stats.PktRcvDropTotal)
+	sample.IntervalSendLost = uint64(stats.PktSndLoss)
+	sample.IntervalRecvLost = uint64(stats.PktRcvLoss)
+	sample.IntervalRetransmitted = uint64(stats.PktRetrans)
+	res := *sample
+	s.mux.Unlock()
+
+	s.logger.WithFields(log.Fields{
+		"rttMs":         res.RTT,
+		"bandwidthMbps": res.Bandwidth,
+		"sendRateMbps":  res.SendRate,
+		"recvRateMbps":  res.RecvRate,
+		"sendLost":      res.IntervalSendLost,
+		"recvLost":      res.IntervalRecvLost,
+		"retransmitted": res.IntervalRetransmitted,
+		"sendDropped":   res.PacketsSendDropped,
+		"recvDropped":   res.PacketsRecvDropped,
+	}).Info("Connection stats")
+}
+
+// Stats returns the latest sample
+func (s *Sampler) Stats() server.ConnectionStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.sample
+}
+
+// Stop stops sampling, it is safe to call it several times
+func (s *Sampler) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+		s.set.remove(s)
+	})
+}
@@ -0,0 +1,146 @@
+package srtstats
+
+import (
+	"errors"
+	"github.com/haivision/srtgo"
+	"github.com/howyoungzhou/golive/server"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSet(options Options, stats func() (*srtgo.SrtStats, error)) *Set {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	s := NewSet(options, log.NewEntry(logger))
+	s.stats = func(*srtgo.SrtSocket) (*srtgo.SrtStats, error) { return stats() }
+	return s
+}
+
+// waitFor polls a condition for a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	stats := &srtgo.SrtStats{
+		MsRTT:           12.5,
+		MbpsBandwidth:   100,
+		MbpsSendRate:    2.5,
+		MbpsRecvRate:    0.5,
+		ByteSentTotal:   1000,
+		ByteRecvTotal:   2000,
+		PktSentTotal:    10,
+		PktRecvTotal:    20,
+		PktSndLossTotal: 3,
+		PktRcvLossTotal: 4,
+		PktRetransTotal: 5,
+		PktSndDropTotal: 6,
+		PktRcvDropTotal: 7,
+		PktSndLoss:      1,
+		PktRcvLoss:      2,
+		PktRetrans:      3,
+	}
+	s := newTestSet(Options{Interval: 1}, func() (*srtgo.SrtStats, error) { return stats, nil })
+	before := time.Now()
+	sampler := s.Start(nil, "127.0.0.1:1234", "live/cam1")
+	defer sampler.Stop()
+	waitFor(t, "a sample", func() bool { return !sampler.Stats().SampledAt.IsZero() })
+
+	got := sampler.Stats()
+	if got.Since.Before(before) || got.SampledAt.Before(got.Since) {
+		t.Fatalf("got the connection since %v sampled at %v, want both after %v", got.Since, got.SampledAt, before)
+	}
+	got.Since, got.SampledAt = time.Time{}, time.Time{}
+	want := server.ConnectionStats{
+		Addr:                  "127.0.0.1:1234",
+		Stream:                "live/cam1",
+		RTT:                   12.5,
+		Bandwidth:             100,
+		SendRate:              2.5,
+		RecvRate:              0.5,
+		BytesSent:             1000,
+		BytesReceived:         2000,
+		PacketsSent:           10,
+		PacketsReceived:       20,
+		PacketsSendLost:       3,
+		PacketsRecvLost:       4,
+		PacketsRetransmitted:  5,
+		PacketsSendDropped:    6,
+		PacketsRecvDropped:    7,
+		IntervalSendLost:      1,
+		IntervalRecvLost:      2,
+		IntervalRetransmitted: 3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got the sample %+v, want %+v", got, want)
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newTestSet(Options{Interval: 1}, func() (*srtgo.SrtStats, error) { return &srtgo.SrtStats{}, nil })
+	var samplers []*Sampler
+	for _, addr := range []string{"127.0.0.1:3000", "127.0.0.1:1000", "127.0.0.1:2000"} {
+		samplers = append(samplers, s.Start(nil, addr, ""))
+	}
+	var addrs []string
+	for _, c := range s.List() {
+		addrs = append(addrs, c.Addr)
+	}
+	if want := []string{"127.0.0.1:1000", "127.0.0.1:2000", "127.0.0.1:3000"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got the connections %v, want %v", addrs, want)
+	}
+
+	samplers[0].Stop()
+	samplers[0].Stop()
+	if n := len(s.List()); n != 2 {
+		t.Fatalf("got %d connections once one is stopped, want 2", n)
+	}
+	samplers[1].Stop()
+	samplers[2].Stop()
+	if n := len(s.List()); n != 0 {
+		t.Fatalf("got %d connections once all are stopped, want none", n)
+	}
+}
+
+func TestClosedConnection(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	s := newTestSet(Options{Interval: 1}, func() (*srtgo.SrtStats, error) {
+		calls <- struct{}{}
+		return nil, errors.New("closed")
+	})
+	sampler := s.Start(nil, "127.0.0.1:1234", "")
+	// the sampler stops by itself once the connection is closed
+	waitFor(t, "the removal of the sampler", func() bool { return len(s.List()) == 0 })
+	sampler.Stop()
+	if n := len(calls); n != 1 {
+		t.Fatalf("got %d samples of the closed connection, want 1", n)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	s := newTestSet(Options{Interval: -1}, func() (*srtgo.SrtStats, error) {
+		t.Error("a connection is sampled with the sampling disabled")
+		return nil, errors.New("disabled")
+	})
+	sampler := s.Start(nil, "127.0.0.1:1234", "")
+	time.Sleep(10 * time.Millisecond)
+	if n := len(s.List()); n != 0 {
+		t.Fatalf("got %d connections with the sampling disabled, want none", n)
+	}
+	if got := sampler.Stats(); got.Addr != "127.0.0.1:1234" || !got.SampledAt.IsZero() {
+		t.Fatalf("got the sample %+v, want only the address", got)
+	}
+	sampler.Stop()
+
+	if d := NewSet(Options{}, nil); d.options.Interval != defaultInterval {
+		t.Fatalf("got the interval %d by default, want %d", d.options.Interval, defaultInterval)
+	}
+}
@@ -0,0 +1,117 @@
+// Package streamid parses the SRT stream ids following the SRT access control guidelines, e.g.
+// "#!::r=live/cam1,m=publish"
+package streamid
+
+import (
+	"errors"
+	"strings"
+)
+
+// Mode is the requested mode of an SRT connection
+type Mode string
+
+const (
+	// ModeRequest plays a stream, it is the default mode
+	ModeRequest Mode = "request"
+	// ModePublish publishes a stream
+	ModePublish Mode = "publish"
+	// ModeBidirectional publishes and plays a stream
+	ModeBidirectional Mode = "bidirectional"
+)
+
+const prefix = "#!::"
+
+// StreamID is a parsed SRT stream id, the keys not set are left empty
+type StreamID struct {
+	User     string
+	Resource string
+	Host     string
+	Session  string
+	Type     string
+	Mode     Mode
+	// Keys holds all the keys of the stream id, including the non-standard ones
+	Keys map[string]string
+}
+
+// Parse parses a stream id. A stream id not starting with "#!::" is used as the resource as a whole. The commas and
+// the backslashes of the values are escaped with a backslash, e.g. "#!::u=a\,b" for the user "a,b".
+func Parse(s string) (*StreamID, error) {
+	res := &StreamID{Keys: make(map[string]string)}
+	if !strings.HasPrefix(s, prefix) {
+		res.Resource = s
+		return res, nil
+	}
+	kvs, err := split(s[len(prefix):])
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, errors.New("invalid stream id key: " + kv)
+		}
+		k, v := kv[:i], kv[i+1:]
+		res.Keys[k] = v
+		switch k {
+		case "u":
+			res.User = v
+		case "r":
+			res.Resource = v
+		case "h":
+			res.Host = v
+		case "s":
+			res.Session = v
+		case "t":
+			res.Type = v
+		case "m":
+			res.Mode = Mode(v)
+		}
+	}
+	switch res.Mode {
+	case "", ModeRequest, ModePublish, ModeBidirectional:
+	default:
+		return nil, errors.New("unknown stream id mode: " + string(res.Mode))
+	}
+	return res, nil
+}
+
+// split splits the key-value pairs of a stream id on the unescaped commas and unescapes them
+func split(s string) ([]string, error) {
+	var res []string
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			i++
+			if i == len(s) || (s[i] != ',' && s[i] != '\\') {
+				return nil, errors.New("invalid escape sequence in stream id: " + s)
+			}
+			b.WriteByte(s[i])
+		case ',':
+			res = append(res, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(res, b.String()), nil
+}
+
+// ValidResource reports whether a resource can be used as the name of a stream, i.e. it is made of letters, digits
+// and "._-/" and its segments separated by "/" are neither empty, "." nor ".."
+func ValidResource(r string) bool {
+	for _, seg := range strings.Split(r, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	for _, c := range r {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
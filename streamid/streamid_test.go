@@ -0,0 +1,98 @@
+package streamid
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want *StreamID
+		err  bool
+	}{
+		{name: "empty", in: "", want: &StreamID{Keys: map[string]string{}}},
+		{name: "no prefix", in: "live/cam1", want: &StreamID{Resource: "live/cam1", Keys: map[string]string{}}},
+		{name: "partial prefix", in: "#!:r=live", want: &StreamID{Resource: "#!:r=live", Keys: map[string]string{}}},
+		{
+			name: "standard keys",
+			in:   "#!::u=alice,r=live/cam1,h=example.com,s=42,t=stream,m=publish",
+			want: &StreamID{
+				User: "alice", Resource: "live/cam1", Host: "example.com", Session: "42", Type: "stream", Mode: ModePublish,
+				Keys: map[string]string{"u": "alice", "r": "live/cam1", "h": "example.com", "s": "42", "t": "stream", "m": "publish"},
+			},
+		},
+		{
+			name: "custom keys",
+			in:   "#!::r=live,token=a=b",
+			want: &StreamID{Resource: "live", Keys: map[string]string{"r": "live", "token": "a=b"}},
+		},
+		{
+			name: "escaped comma",
+			in:   `#!::u=a\,b,r=live`,
+			want: &StreamID{User: "a,b", Resource: "live", Keys: map[string]string{"u": "a,b", "r": "live"}},
+		},
+		{
+			name: "escaped backslash",
+			in:   `#!::u=a\\,r=live`,
+			want: &StreamID{User: `a\`, Resource: "live", Keys: map[string]string{"u": `a\`, "r": "live"}},
+		},
+		{name: "empty value", in: "#!::r=", want: &StreamID{Keys: map[string]string{"r": ""}}},
+		{name: "prefix only", in: "#!::", err: true},
+		{name: "trailing comma", in: "#!::r=live,", err: true},
+		{name: "missing key", in: "#!::=live", err: true},
+		{name: "missing value", in: "#!::r", err: true},
+		{name: "invalid escape", in: `#!::r=li\ve`, err: true},
+		{name: "trailing backslash", in: `#!::r=live\`, err: true},
+		{name: "unknown mode", in: "#!::r=live,m=pull", err: true},
+		{name: "request", in: "#!::r=live,m=request", want: &StreamID{Resource: "live", Mode: ModeRequest, Keys: map[string]string{"r": "live", "m": "request"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %+v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidResource(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"live", true},
+		{"live/cam1", true},
+		{"a.b_c-d/E9", true},
+		{"", false},
+		{"/live", false},
+		{"live/", false},
+		{"/", false},
+		{"live//cam1", false},
+		{".", false},
+		{"../live", false},
+		{"live/./cam1", false},
+		{"live/..", false},
+		{"..live", true},
+		{"live cam", false},
+		{"live,cam", false},
+		{"live?cam", false},
+		{"caméra", false},
+	}
+	for _, tt := range tests {
+		if got := ValidResource(tt.in); got != tt.want {
+			t.Errorf("ValidResource(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
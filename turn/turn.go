@@ -0,0 +1,190 @@
+// Package turn runs a TURN and STUN server relaying the media of the WebRTC viewers behind a symmetric NAT, with
+// long-term credentials handed out per session
+package turn
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/pion/turn/v2"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"sync"
+)
+
+const (
+	// ServiceName is the name the server is shared with the components under, see server.AddService
+	ServiceName = "turn"
+
+	defaultListenAddress = ":3478"
+	defaultRealm         = "golive"
+)
+
+// Options configures the TURN server
+type Options struct {
+	// ListenAddress is the UDP address of the server, ":3478" by default
+	ListenAddress string `json:"listenAddress"`
+	// TCPListenAddress also serves TURN over TCP if set, e.g. ":3478"
+	TCPListenAddress string `json:"tcpListenAddress"`
+	// PublicIP is the IP of the relayed addresses, it must be reachable by the clients and by the WebRTC components
+	PublicIP string `json:"publicIP"`
+	// RelayPortMin and RelayPortMax limit the ports of the relayed addresses, any port is used by default
+	RelayPortMin uint16 `json:"relayPortMin"`
+	RelayPortMax uint16 `json:"relayPortMax"`
+	// Realm is the realm of the credentials, "golive" by default
+	Realm string `json:"realm"`
+	// URLs are the URLs of the server handed to the clients, "turn:<public IP>:<port>" by default, along with the TCP
+	// one if TCPListenAddress is set
+	URLs []string `json:"urls"`
+}
+
+// ICEServer is the server and the credentials of a session, as the RTCIceServer of the browsers
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username"`
+	Credential string   `json:"credential"`
+}
+
+// Server is a TURN server shared by the WebRTC components, the credentials of a session are valid until it is revoked
+type Server struct {
+	options *Options
+	server  *turn.Server
+	// localURLs reach the server through the loopback interface, the relayed addresses of the components may not be
+	// reachable through the public IP from the host itself
+	localURLs []string
+	// keys are the authentication keys by username, users are the usernames by session
+	keys   map[string][]byte
+	users  map[string]string
+	mux    sync.Mutex
+	logger *log.Entry
+}
+
+// New creates a new instance of Server
+func New(options *Options) *Server {
+	if options.ListenAddress == "" {
+		options.ListenAddress = defaultListenAddress
+	}
+	if options.Realm == "" {
+		options.Realm = defaultRealm
+	}
+	return &Server{
+		options: options,
+		keys:    make(map[string][]byte),
+		users:   make(map[string]string),
+		logger:  log.New().WithFields(log.Fields{"module": "TURN"}),
+	}
+}
+
+// Init starts listening
+func (s *Server) Init() error {
+	ip := net.ParseIP(s.options.PublicIP)
+	if ip == nil {
+		return errors.New("invalid public IP: " + s.options.PublicIP)
+	}
+	config := turn.ServerConfig{Realm: s.options.Realm, AuthHandler: s.authenticate}
+	conn, err := net.ListenPacket("udp4", s.options.ListenAddress)
+	if err != nil {
+		return err
+	}
+	config.PacketConnConfigs = []turn.PacketConnConfig{{PacketConn: conn, RelayAddressGenerator: s.relay(ip)}}
+	urls := []string{"turn:" + net.JoinHostPort(ip.String(), port(conn.LocalAddr()))}
+	s.localURLs = []string{"turn:" + net.JoinHostPort("127.0.0.1", port(conn.LocalAddr()))}
+	if s.options.TCPListenAddress != "" {
+		l, err := net.Listen("tcp4", s.options.TCPListenAddress)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		config.ListenerConfigs = []turn.ListenerConfig{{Listener: l, RelayAddressGenerator: s.relay(ip)}}
+		urls = append(urls, "turn:"+net.JoinHostPort(ip.String(), port(l.Addr()))+"?transport=tcp")
+	}
+	if len(s.options.URLs) == 0 {
+		s.options.URLs = urls
+	}
+	if s.server, err = turn.NewServer(config); err != nil {
+		conn.Close()
+		for _, l := range config.ListenerConfigs {
+			l.Listener.Close()
+		}
+		return err
+	}
+	s.logger.WithFields(log.Fields{"addr": conn.LocalAddr(), "urls": s.options.URLs}).Info("TURN server is listening")
+	return nil
+}
+
+// relay allocates the relayed addresses on the public IP
+func (s *Server) relay(ip net.IP) turn.RelayAddressGenerator {
+	if s.options.RelayPortMin != 0 || s.options.RelayPortMax != 0 {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: ip,
+			Address:      "0.0.0.0",
+			MinPort:      s.options.RelayPortMin,
+			MaxPort:      s.options.RelayPortMax,
+		}
+	}
+	return &turn.RelayAddressGeneratorStatic{RelayAddress: ip, Address: "0.0.0.0"}
+}
+
+func port(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return strconv.Itoa(a.Port)
+	case *net.TCPAddr:
+		return strconv.Itoa(a.Port)
+	}
+	return ""
+}
+
+// authenticate returns the key of a username, the refreshes of the allocations of a revoked session are refused
+func (s *Server) authenticate(username, realm string, addr net.Addr) ([]byte, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	key, ok := s.keys[username]
+	if !ok {
+		s.logger.WithFields(log.Fields{"addr": addr, "username": username}).Debug("unknown username")
+	}
+	return key, ok
+}
+
+// Credentials issues the credentials of a session, the previous ones of the session are revoked
+func (s *Server) Credentials(session string) ICEServer {
+	username, password := random(), random()
+	s.mux.Lock()
+	if old, ok := s.users[session]; ok {
+		delete(s.keys, old)
+	}
+	s.users[session] = username
+	s.keys[username] = turn.GenerateAuthKey(username, s.options.Realm, password)
+	s.mux.Unlock()
+	return ICEServer{URLs: s.options.URLs, Username: username, Credential: password}
+}
+
+// LocalURLs returns the URLs of the server for the components, along with the credentials of their session
+func (s *Server) LocalURLs() []string {
+	return s.localURLs
+}
+
+// Revoke revokes the credentials of a session
+func (s *Server) Revoke(session string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if username, ok := s.users[session]; ok {
+		delete(s.keys, username)
+		delete(s.users, session)
+	}
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+func random() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,147 @@
+package turn
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pion/turn/v2"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// freePorts returns a free UDP port and a free TCP port on the loopback interface
+func freePorts(t *testing.T) []string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return []string{port(conn.LocalAddr()), port(l.Addr())}
+}
+
+func TestOptions(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name   string
+		config string
+		// urls are the URLs handed to the clients, "<port>" and "<tcp port>" are replaced by free ports in the config
+		// and in the URLs
+		urls    []string
+		realm   string
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			config: `{"publicIP": "203.0.113.1", "listenAddress": "127.0.0.1:<port>"}`,
+			urls:   []string{"turn:203.0.113.1:<port>"},
+			realm:  "golive",
+		},
+		{
+			name:   "TCP",
+			config: `{"publicIP": "203.0.113.1", "listenAddress": "127.0.0.1:<port>", "tcpListenAddress": "127.0.0.1:<tcp port>"}`,
+			urls:   []string{"turn:203.0.113.1:<port>", "turn:203.0.113.1:<tcp port>?transport=tcp"},
+			realm:  "golive",
+		},
+		{
+			name:   "IPv6",
+			config: `{"publicIP": "2001:db8::1", "listenAddress": "127.0.0.1:<port>"}`,
+			urls:   []string{"turn:[2001:db8::1]:<port>"},
+			realm:  "golive",
+		},
+		{
+			name: "custom",
+			config: `{"publicIP": "203.0.113.1", "listenAddress": "127.0.0.1:<port>", "realm": "example.com",
+				"urls": ["turn:turn.example.com:443?transport=tcp"], "relayPortMin": 50000, "relayPortMax": 50100}`,
+			urls:  []string{"turn:turn.example.com:443?transport=tcp"},
+			realm: "example.com",
+		},
+		{name: "no public IP", config: `{"listenAddress": "127.0.0.1:<port>"}`, wantErr: true},
+		{name: "malformed public IP", config: `{"publicIP": "turn.example.com", "listenAddress": "127.0.0.1:<port>"}`, wantErr: true},
+		{name: "address in use", config: `{"publicIP": "203.0.113.1", "listenAddress": "` + conn.LocalAddr().String() + `"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports := freePorts(t)
+			replacer := strings.NewReplacer("<port>", ports[0], "<tcp port>", ports[1])
+			var options Options
+			if err := json.Unmarshal([]byte(replacer.Replace(tt.config)), &options); err != nil {
+				t.Fatal(err)
+			}
+			s := New(&options)
+			s.logger.Logger.SetOutput(ioutil.Discard)
+			err := s.Init()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got the error %v, want an error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer s.Close()
+
+			if want := "turn:127.0.0.1:" + ports[0]; !reflect.DeepEqual(s.LocalURLs(), []string{want}) {
+				t.Errorf("got the local URLs %v, want %s", s.LocalURLs(), want)
+			}
+			var urls []string
+			for _, u := range tt.urls {
+				urls = append(urls, replacer.Replace(u))
+			}
+			if !reflect.DeepEqual(s.options.URLs, urls) {
+				t.Errorf("got the URLs %v, want %v", s.options.URLs, urls)
+			}
+			if s.options.Realm != tt.realm {
+				t.Errorf("got the realm %q, want %q", s.options.Realm, tt.realm)
+			}
+		})
+	}
+
+	if s := New(&Options{}); s.options.ListenAddress != defaultListenAddress || s.options.Realm != defaultRealm {
+		t.Fatalf("got the defaults %+v", s.options)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	s := New(&Options{URLs: []string{"turn:203.0.113.1:3478"}})
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+	valid := func(c ICEServer) bool {
+		key, ok := s.authenticate(c.Username, defaultRealm, addr)
+		return ok && bytes.Equal(key, turn.GenerateAuthKey(c.Username, defaultRealm, c.Credential))
+	}
+
+	first := s.Credentials("session")
+	if !reflect.DeepEqual(first.URLs, s.options.URLs) || first.Username == "" || first.Credential == "" {
+		t.Fatalf("got the credentials %+v", first)
+	}
+	if !valid(first) {
+		t.Fatal("the credentials are refused")
+	}
+	other := s.Credentials("other")
+	if other.Username == first.Username || other.Credential == first.Credential {
+		t.Fatal("two sessions share their credentials")
+	}
+
+	// the new credentials of a session revoke the previous ones
+	second := s.Credentials("session")
+	if valid(first) || !valid(second) {
+		t.Fatal("the previous credentials of the session are still accepted")
+	}
+	s.Revoke("session")
+	if valid(second) || !valid(other) {
+		t.Fatal("the revocation of a session affects the wrong credentials")
+	}
+	s.Revoke("unknown")
+	if _, ok := s.authenticate("unknown", defaultRealm, addr); ok {
+		t.Fatal("an unknown username is accepted")
+	}
+}
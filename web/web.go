@@ -0,0 +1,177 @@
+// Package web serves the routes of all the HTTP-facing components on shared listeners, each component mounts its
+// handler under its own path prefix
+package web
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/howyoungzhou/golive/server"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ServiceName is the name the server is shared with the components under, see server.AddService
+const ServiceName = "http"
+
+// Options configures the shared HTTP server
+type Options struct {
+	Listeners []ListenerOptions `json:"listeners"`
+	// Static serves directories by path prefix, e.g. {"/player": "./www"}
+	Static map[string]string `json:"static"`
+}
+
+// ListenerOptions configures an address the routes are served on, with TLS if both CertFile and KeyFile are set.
+// CORS is only enabled if origins are allowed.
+type ListenerOptions struct {
+	Address  string      `json:"address"`
+	CertFile string      `json:"certFile"`
+	KeyFile  string      `json:"keyFile"`
+	CORS     cors.Config `json:"cors"`
+}
+
+// Server dispatches the requests of its listeners to the handler mounted under the longest matching prefix
+type Server struct {
+	options  *Options
+	srvs     []*http.Server
+	handlers map[string]http.Handler
+	mux      sync.RWMutex
+	logger   *log.Entry
+}
+
+// New creates a new instance of Server
+func New(options *Options) *Server {
+	return &Server{
+		options:  options,
+		handlers: make(map[string]http.Handler),
+		logger:   log.New().WithFields(log.Fields{"module": "HTTP"}),
+	}
+}
+
+// Shared returns the shared HTTP server of a server, the components use it when no listen address of their own is set
+func Shared(s *server.Server) (*Server, error) {
+	if s == nil {
+		return nil, errors.New("no listen address is set and there is no http server")
+	}
+	service, err := s.Service(ServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("no listen address is set and there is no http server: %w", err)
+	}
+	return service.(*Server), nil
+}
+
+// CORS returns the middleware of a CORS config, nil if no origin is allowed
+func CORS(config cors.Config) (gin.HandlerFunc, error) {
+	if !config.AllowAllOrigins && len(config.AllowOrigins) == 0 {
+		return nil, nil
+	}
+	// Validate turns the "*" origin into AllowAllOrigins, which cors.New rejects along with the origins
+	check := config
+	if err := check.Validate(); err != nil {
+		return nil, err
+	}
+	return cors.New(config), nil
+}
+
+// Init mounts the static directories and starts the listeners
+func (s *Server) Init() error {
+	for prefix, dir := range s.options.Static {
+		h := http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.FileServer(http.Dir(dir)))
+		if _, err := s.Mount(prefix, h); err != nil {
+			return err
+		}
+	}
+	for _, l := range s.options.Listeners {
+		r := gin.Default()
+		middleware, err := CORS(l.CORS)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		if middleware != nil {
+			r.Use(middleware)
+		}
+		r.NoRoute(s.dispatch)
+		ln, err := net.Listen("tcp", l.Address)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		srv := &http.Server{Handler: r}
+		s.srvs = append(s.srvs, srv)
+		go s.serve(srv, ln, l)
+	}
+	return nil
+}
+
+func (s *Server) serve(srv *http.Server, ln net.Listener, options ListenerOptions) {
+	logger := s.logger.WithField("addr", ln.Addr())
+	var err error
+	if options.CertFile != "" && options.KeyFile != "" {
+		logger.Info("HTTPS server is listening")
+		err = srv.ServeTLS(ln, options.CertFile, options.KeyFile)
+	} else {
+		logger.Info("HTTP server is listening")
+		err = srv.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		logger.WithError(err).Error("HTTP server ended with error")
+	}
+}
+
+// Mount serves a handler under a path prefix, the handler receives the full path of the requests. The returned function
+// unmounts it.
+func (s *Server) Mount(prefix string, h http.Handler) (func(), error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.handlers[prefix]; ok {
+		return nil, fmt.Errorf("path %s is already mounted", prefix+"/")
+	}
+	s.handlers[prefix] = h
+	s.logger.WithField("prefix", prefix+"/").Debug("handler mounted")
+	return func() {
+		s.mux.Lock()
+		delete(s.handlers, prefix)
+		s.mux.Unlock()
+	}, nil
+}
+
+// dispatch passes a request to the handler of the longest prefix of its path
+func (s *Server) dispatch(c *gin.Context) {
+	p := c.Request.URL.Path
+	var h http.Handler
+	s.mux.RLock()
+	for prefix := p; h == nil; {
+		h = s.handlers[prefix]
+		i := strings.LastIndexByte(prefix, '/')
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	s.mux.RUnlock()
+	if h == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	// the status is set to 404 for the unmatched routes, the handlers writing no status answer 200
+	c.Status(http.StatusOK)
+	h.ServeHTTP(c.Writer, c.Request)
+}
+
+// Close stops the listeners
+func (s *Server) Close() error {
+	var res error
+	for _, srv := range s.srvs {
+		if err := srv.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	s.srvs = nil
+	return res
+}
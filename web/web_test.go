@@ -0,0 +1,165 @@
+package web
+
+import (
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// named answers with its name, without writing any status
+type named string
+
+func (n named) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, string(n))
+}
+
+func newTestServer(t *testing.T, options *Options) *Server {
+	s := New(options)
+	s.logger.Logger.SetOutput(ioutil.Discard)
+	return s
+}
+
+// get sends a GET request to the handlers mounted on a server
+func get(s *Server, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(s.dispatch)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		mounts []string
+		path   string
+		// want is the prefix of the handler answering, empty for a 404
+		want string
+	}{
+		{name: "exact", mounts: []string{"/live"}, path: "/live", want: "/live"},
+		{name: "trailing slash", mounts: []string{"/live"}, path: "/live/", want: "/live"},
+		{name: "sub path", mounts: []string{"/live"}, path: "/live/cam1/whep", want: "/live"},
+		{name: "longest prefix", mounts: []string{"/live", "/live/cam1", "/live/cam1/whep/session"}, path: "/live/cam1/whep", want: "/live/cam1"},
+		{name: "longest prefix mounted with a slash", mounts: []string{"/live", "/live/cam1/"}, path: "/live/cam1/whep", want: "/live/cam1/"},
+		{name: "segment boundary", mounts: []string{"/live", "/live/cam1"}, path: "/live/cam10", want: "/live"},
+		{name: "not a segment", mounts: []string{"/live"}, path: "/lively"},
+		{name: "root", mounts: []string{"/", "/live"}, path: "/player/index.html", want: "/"},
+		{name: "root before a deeper mount", mounts: []string{"/", "/live/cam1"}, path: "/live/cam2", want: "/"},
+		{name: "unknown", mounts: []string{"/live", "/api"}, path: "/player"},
+		{name: "nothing mounted", path: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &Options{})
+			for _, m := range tt.mounts {
+				if _, err := s.Mount(m, named(m)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			res := get(s, tt.path)
+			if tt.want == "" {
+				if res.Code != http.StatusNotFound {
+					t.Fatalf("got %d from %q, want %d", res.Code, res.Body, http.StatusNotFound)
+				}
+				return
+			}
+			if res.Code != http.StatusOK || res.Body.String() != tt.want {
+				t.Fatalf("got %d from %q, want %d from %q", res.Code, res.Body, http.StatusOK, tt.want)
+			}
+		})
+	}
+}
+
+func TestMount(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   string
+		second  string
+		wantErr bool
+	}{
+		{name: "same prefix", first: "/live", second: "/live", wantErr: true},
+		{name: "trailing slash", first: "/live", second: "/live/", wantErr: true},
+		{name: "root", first: "/", second: "", wantErr: true},
+		{name: "nested", first: "/live", second: "/live/cam1"},
+		{name: "sibling", first: "/live", second: "/api"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &Options{})
+			unmount, err := s.Mount(tt.first, named("first"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Mount(tt.second, named("second")); (err != nil) != tt.wantErr {
+				t.Fatalf("got the error %v mounting %q after %q, want an error: %v", err, tt.second, tt.first, tt.wantErr)
+			}
+			if !tt.wantErr {
+				return
+			}
+			// the conflicting handler is not mounted, and the prefix can be mounted again once released
+			if res := get(s, tt.first); res.Body.String() != "first" {
+				t.Fatalf("got %q, want the first handler", res.Body)
+			}
+			unmount()
+			if res := get(s, tt.first); res.Code != http.StatusNotFound {
+				t.Fatalf("got %d once unmounted, want %d", res.Code, http.StatusNotFound)
+			}
+			if _, err := s.Mount(tt.second, named("second")); err != nil {
+				t.Fatal(err)
+			}
+			if res := get(s, tt.first); res.Body.String() != "second" {
+				t.Fatalf("got %q, want the second handler", res.Body)
+			}
+		})
+	}
+}
+
+func TestStatic(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "player.js"), []byte("player"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, &Options{Static: map[string]string{"/player/": dir}})
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if res := get(s, "/player/player.js"); res.Code != http.StatusOK || res.Body.String() != "player" {
+		t.Fatalf("got %d from %q, want the file", res.Code, res.Body)
+	}
+	if res := get(s, "/player/missing.js"); res.Code != http.StatusNotFound {
+		t.Fatalf("got %d for a missing file, want %d", res.Code, http.StatusNotFound)
+	}
+	if _, err := s.Mount("/player", named("other")); err == nil {
+		t.Fatal("a handler is mounted over a static directory")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         cors.Config
+		wantMiddleware bool
+		wantErr        bool
+	}{
+		{name: "disabled"},
+		{name: "all origins", config: cors.Config{AllowOrigins: []string{"*"}}, wantMiddleware: true},
+		{name: "origins", config: cors.Config{AllowOrigins: []string{"https://example.com"}}, wantMiddleware: true},
+		{name: "malformed origin", config: cors.Config{AllowOrigins: []string{"example.com"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware, err := CORS(tt.config)
+			if (err != nil) != tt.wantErr || (middleware != nil) != tt.wantMiddleware {
+				t.Fatalf("got the middleware %v and the error %v", middleware != nil, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Package whip implements the parts of the WebRTC-HTTP ingestion and egress protocols (WHIP and WHEP) shared by the
+// inbounds and the outbounds
+package whip
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/pion/webrtc/v3"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ContentTypeSDP is the content type of the offers and the answers
+	ContentTypeSDP = "application/sdp"
+	// ContentTypeTrickle is the content type of the SDP fragments trickling ICE candidates
+	ContentTypeTrickle = "application/trickle-ice-sdpfrag"
+)
+
+// ErrICERestart is returned when an SDP fragment requests an ICE restart, which is not supported
+var ErrICERestart = errors.New("ICE restarts are not supported")
+
+// Frag is a parsed SDP fragment sent with PATCH to trickle ICE candidates
+type Frag struct {
+	Ufrag      string
+	Pwd        string
+	Candidates []webrtc.ICECandidateInit
+}
+
+// NewSessionID returns a random id for the URL of a session
+func NewSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ICEServerLink returns the Link header advertising an ICE server along with its credentials
+func ICEServerLink(url, username, credential string) string {
+	return "<" + url + `>; rel="ice-server"; username="` + username + `"; credential="` + credential +
+		`"; credential-type="password"`
+}
+
+// BearerToken returns the bearer token of the Authorization header, empty if there is none
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return h[7:]
+	}
+	return ""
+}
+
+// ParseFrag parses an SDP fragment, the candidates are attached to the mid of the media section they are listed in.
+// The end of the candidates is ignored, the agent keeps checking the pairs it has.
+func ParseFrag(frag string) (*Frag, error) {
+	res := &Frag{}
+	var mid *string
+	media := -1
+	for _, line := range strings.Split(frag, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "m="):
+			media++
+			mid = nil
+		case strings.HasPrefix(line, "a=mid:"):
+			v := strings.TrimPrefix(line, "a=mid:")
+			mid = &v
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			res.Ufrag = strings.TrimPrefix(line, "a=ice-ufrag:")
+		case strings.HasPrefix(line, "a=ice-pwd:"):
+			res.Pwd = strings.TrimPrefix(line, "a=ice-pwd:")
+		case strings.HasPrefix(line, "a=candidate:"):
+			c := webrtc.ICECandidateInit{Candidate: strings.TrimPrefix(line, "a="), SDPMid: mid}
+			if media >= 0 {
+				index := uint16(media)
+				c.SDPMLineIndex = &index
+			}
+			res.Candidates = append(res.Candidates, c)
+		case len(line) < 2 || line[1] != '=':
+			return nil, errors.New("malformed SDP fragment line: " + line)
+		}
+	}
+	return res, nil
+}
+
+// Trickle adds the candidates of an SDP fragment to a peer connection
+func Trickle(pc *webrtc.PeerConnection, frag *Frag) error {
+	remote := pc.RemoteDescription()
+	if remote == nil {
+		return errors.New("no remote description")
+	}
+	if frag.Ufrag != "" && frag.Ufrag != ICEUfrag(remote.SDP) {
+		return ErrICERestart
+	}
+	for _, c := range frag.Candidates {
+		if err := pc.AddICECandidate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ICEUfrag returns the first ICE username fragment of an SDP, empty if there is none
+func ICEUfrag(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "a=ice-ufrag:") {
+			return strings.TrimPrefix(line, "a=ice-ufrag:")
+		}
+	}
+	return ""
+}
+
+// ETag returns the entity tag of a session, it identifies its ICE session through the username fragment of the answer
+func ETag(answer string) string {
+	return `"` + ICEUfrag(answer) + `"`
+}
+
+// MatchETag reports whether the If-Match header of a request matches the entity tag, a missing header matches
+func MatchETag(r *http.Request, etag string) bool {
+	h := r.Header.Get("If-Match")
+	if h == "" || h == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(h, ",") {
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}